@@ -0,0 +1,133 @@
+package ibclientportal
+
+import "strings"
+
+// AvailabilityStatus is the first character of the market-data availability
+// field (6509): whether the account is receiving live data for a contract.
+type AvailabilityStatus string
+
+const (
+	// AvailabilityUnknown means the gateway has not reported availability.
+	AvailabilityUnknown AvailabilityStatus = ""
+	// AvailabilityRealtime is live market data.
+	AvailabilityRealtime AvailabilityStatus = "R"
+	// AvailabilityDelayed is market data delayed by (usually) 15 minutes,
+	// sent when the account has no live subscription for the contract.
+	AvailabilityDelayed AvailabilityStatus = "D"
+	// AvailabilityFrozen is the last value recorded before the market closed.
+	AvailabilityFrozen AvailabilityStatus = "Z"
+	// AvailabilityFrozenDelayed is frozen data that is also delayed.
+	AvailabilityFrozenDelayed AvailabilityStatus = "Y"
+	// AvailabilityNotSubscribed means the account has no market-data
+	// subscription for the contract, live or delayed.
+	AvailabilityNotSubscribed AvailabilityStatus = "N"
+	// AvailabilityIncomplete means the gateway is still resolving the market
+	// data for the contract.
+	AvailabilityIncomplete AvailabilityStatus = "i"
+	// AvailabilityVDRExempt is data exempt from the Vendor Display Rule.
+	AvailabilityVDRExempt AvailabilityStatus = "v"
+)
+
+func (s AvailabilityStatus) String() string {
+	switch s {
+	case AvailabilityUnknown:
+		return "unknown"
+	case AvailabilityRealtime:
+		return "realtime"
+	case AvailabilityDelayed:
+		return "delayed"
+	case AvailabilityFrozen:
+		return "frozen"
+	case AvailabilityFrozenDelayed:
+		return "frozen delayed"
+	case AvailabilityNotSubscribed:
+		return "not subscribed"
+	case AvailabilityIncomplete:
+		return "incomplete"
+	case AvailabilityVDRExempt:
+		return "VDR exempt"
+	}
+	return "unrecognized (" + string(s) + ")"
+}
+
+// Availability is the decoded market-data availability field (6509). IB sends
+// it as a short code such as "RpB": the first character is the Status, and the
+// characters after it describe how the data is delivered.
+type Availability struct {
+	// Raw is the code as the gateway sent it.
+	Raw string
+	// Status says whether the data is realtime, delayed, frozen or not
+	// subscribed.
+	Status AvailabilityStatus
+	// Snapshot is set ("P") when the data is a snapshot rather than a
+	// streaming feed.
+	Snapshot bool
+	// Consolidated is set ("p") when the data is consolidated across
+	// exchanges.
+	Consolidated bool
+	// Book is set ("B") when the data includes the order book.
+	Book bool
+}
+
+// ParseAvailability decodes the market-data availability field. An empty
+// string decodes to an Availability with status AvailabilityUnknown.
+func ParseAvailability(s string) Availability {
+	s = strings.TrimSpace(s)
+	a := Availability{Raw: s}
+	if s == "" {
+		return a
+	}
+	a.Status = AvailabilityStatus(s[:1])
+	for _, c := range s[1:] {
+		switch c {
+		case 'P':
+			a.Snapshot = true
+		case 'p':
+			a.Consolidated = true
+		case 'B':
+			a.Book = true
+		}
+	}
+	return a
+}
+
+// Known reports whether the gateway has reported availability at all.
+func (a Availability) Known() bool {
+	return a.Status != AvailabilityUnknown
+}
+
+// IsRealtime reports whether the data is live. Anything else — delayed,
+// frozen, not subscribed, or not yet reported — must not be priced as a live
+// quote.
+func (a Availability) IsRealtime() bool {
+	return a.Status == AvailabilityRealtime
+}
+
+// IsStreaming reports whether the data is a streaming feed rather than a
+// snapshot.
+func (a Availability) IsStreaming() bool {
+	return a.Known() && !a.Snapshot
+}
+
+func (a Availability) String() string {
+	if !a.Known() {
+		return a.Status.String()
+	}
+	delivery := "streaming"
+	if a.Snapshot {
+		delivery = "snapshot"
+	}
+	return a.Status.String() + " " + delivery + " (" + a.Raw + ")"
+}
+
+// AvailabilityEvent reports that a contract's market-data availability
+// changed, including the first time it is reported.
+type AvailabilityEvent struct {
+	// Conid is the contract whose availability changed.
+	Conid int
+	// Availability is the new value.
+	Availability Availability
+	// Previous is the value before the change; its Status is
+	// AvailabilityUnknown the first time a contract reports.
+	Previous Availability
+}
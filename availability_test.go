@@ -0,0 +1,37 @@
+package ibclientportal
+
+import "testing"
+
+func TestParseAvailability(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		in        string
+		status    AvailabilityStatus
+		snapshot  bool
+		book      bool
+		realtime  bool
+		streaming bool
+	}{
+		{"RpB", AvailabilityRealtime, false, true, true, true},
+		{"RP", AvailabilityRealtime, true, false, true, false},
+		{"DPB", AvailabilityDelayed, true, true, false, false},
+		{"Z", AvailabilityFrozen, false, false, false, true},
+		{"N", AvailabilityNotSubscribed, false, false, false, true},
+		{"", AvailabilityUnknown, false, false, false, false},
+	}
+	for _, c := range cases {
+		a := ParseAvailability(c.in)
+		if a.Raw != c.in || a.Status != c.status || a.Snapshot != c.snapshot || a.Book != c.book {
+			t.Errorf("ParseAvailability(%q) = %#v", c.in, a)
+		}
+		if a.IsRealtime() != c.realtime {
+			t.Errorf("ParseAvailability(%q).IsRealtime() = %t, want %t", c.in, a.IsRealtime(), c.realtime)
+		}
+		if a.IsStreaming() != c.streaming {
+			t.Errorf("ParseAvailability(%q).IsStreaming() = %t, want %t", c.in, a.IsStreaming(), c.streaming)
+		}
+	}
+	if got := ParseAvailability("DP").String(); got != "delayed snapshot (DP)" {
+		t.Errorf("unexpected String() %q", got)
+	}
+}
@@ -0,0 +1,298 @@
+package ibclientportal
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Bar is an OHLCV bar built from streaming ticks by a BarBuilder.
+type Bar struct {
+	// Conid is the contract the bar is for.
+	Conid int
+	// Start and End bound the bar's interval, [Start, End), in the
+	// BarBuilder's exchange time zone.
+	Start time.Time
+	End   time.Time
+	// Open, High, Low and Close are the first, highest, lowest and last trade
+	// prices in the interval. For an empty bar they are set by the
+	// EmptyBarPolicy.
+	Open  float64
+	High  float64
+	Low   float64
+	Close float64
+	// Volume is the volume traded in the interval, the increase in the
+	// gateway's cumulative day volume. The gateway abbreviates large volumes
+	// ("1.5M"), so it is only as precise as the abbreviation.
+	Volume float64
+	// Ticks is the number of last-price updates in the interval.
+	Ticks int
+	// Empty is set if no trade price was seen in the interval.
+	Empty bool
+}
+
+// EmptyBarPolicy is what a BarBuilder emits for an interval in which a
+// contract it has seen before did not trade.
+type EmptyBarPolicy int
+
+const (
+	// EmptyBarsSkip emits nothing for a silent interval, so the bars for a
+	// contract may have gaps.
+	EmptyBarsSkip EmptyBarPolicy = iota
+	// EmptyBarsCarry emits a flat bar at the previous bar's close, with no
+	// volume.
+	EmptyBarsCarry
+	// EmptyBarsZero emits a bar with zero prices and no volume.
+	EmptyBarsZero
+)
+
+func (p EmptyBarPolicy) String() string {
+	switch p {
+	case EmptyBarsSkip:
+		return "skip"
+	case EmptyBarsCarry:
+		return "carry"
+	case EmptyBarsZero:
+		return "zero"
+	}
+	return "unknown"
+}
+
+// BarBuilderOptions configures NewBarBuilder.
+type BarBuilderOptions struct {
+	// Interval is the width of each bar, such as time.Second or
+	// 5*time.Minute. It must divide a day evenly.
+	Interval time.Duration
+	// Location is the exchange time zone bars are aligned to, counting from
+	// midnight; nil means America/New_York.
+	Location *time.Location
+	// EmptyBars is what to emit for silent intervals.
+	EmptyBars EmptyBarPolicy
+	// MaxEmptyBars caps the empty bars emitted for one silent period, such as
+	// the hours between sessions; the rest are skipped. 0 means no cap.
+	MaxEmptyBars int
+}
+
+// BarBuilder aggregates market-data updates into OHLCV bars per contract,
+// from the last price (FieldLastPrice) and the cumulative day volume
+// (FieldVolume), so the contract's subscription must include both. A bar is
+// complete, and emitted, once an update or Advance moves the builder's clock
+// past its end.
+//
+// The clock is each update's Received time, or the wall clock for updates
+// without one, so bars built from a Replay fall on the recorded times. A
+// drop in the cumulative volume, which the gateway resets at the start of a
+// session, is taken as a reset rather than negative volume. A BarBuilder is
+// safe for concurrent use.
+type BarBuilder struct {
+	interval  time.Duration
+	loc       *time.Location
+	empty     EmptyBarPolicy
+	maxEmpty  int
+	wallClock func() time.Time
+
+	mu     sync.Mutex
+	clock  time.Time
+	states map[int]*barState
+}
+
+// barState is a contract's open bar and what carries over between bars.
+type barState struct {
+	cur      Bar
+	open     bool
+	last     float64 // close of the last bar with a trade
+	hasLast  bool
+	cumVol   float64
+	hasVol   bool
+	next     time.Time // start of the first interval not yet emitted
+	produced bool      // whether any bar has been emitted
+}
+
+// NewBarBuilder returns a BarBuilder with the given options. It returns an
+// error if the interval does not divide a day or the default time zone cannot
+// be loaded.
+func NewBarBuilder(opts BarBuilderOptions) (*BarBuilder, error) {
+	if opts.Interval < time.Second || (24*time.Hour)%opts.Interval != 0 {
+		return nil, fmt.Errorf("ibclientportal: bar interval %v must be at least a second and divide a day evenly", opts.Interval)
+	}
+	loc := opts.Location
+	if loc == nil {
+		var err error
+		loc, err = time.LoadLocation("America/New_York")
+		if err != nil {
+			return nil, fmt.Errorf("ibclientportal: loading exchange time zone: %w", err)
+		}
+	}
+	return &BarBuilder{
+		interval:  opts.Interval,
+		loc:       loc,
+		empty:     opts.EmptyBars,
+		maxEmpty:  opts.MaxEmptyBars,
+		wallClock: time.Now,
+		states:    make(map[int]*barState),
+	}, nil
+}
+
+// align returns the start of the interval containing t.
+func (b *BarBuilder) align(t time.Time) time.Time {
+	t = t.In(b.loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, b.loc)
+	return midnight.Add(t.Sub(midnight) / b.interval * b.interval)
+}
+
+// Add applies an update and returns the bars it completed, for any contract,
+// by moving the clock to the update's time.
+func (b *BarBuilder) Add(u MarketDataUpdate) []Bar {
+	now := u.Received
+	if now.IsZero() {
+		now = b.wallClock()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bars := b.advance(now)
+
+	price, _ := u.Price(FieldLastPrice)
+	traded := price.Valid && !price.PreviousClose
+	st := b.states[u.Conid]
+	if st == nil {
+		st = &barState{}
+		b.states[u.Conid] = st
+	}
+	var volume float64
+	if n := fieldNumber(u.Fields, FieldVolume); n.Valid {
+		if st.hasVol {
+			volume = n.Value - st.cumVol
+			if volume < 0 {
+				// A new session: the count restarted from zero.
+				volume = n.Value
+			}
+		}
+		st.cumVol, st.hasVol = n.Value, true
+	}
+	if !traded && volume == 0 {
+		return bars
+	}
+	if !st.open {
+		start := b.align(b.clock)
+		st.cur = Bar{Conid: u.Conid, Start: start, End: start.Add(b.interval)}
+		st.open = true
+	}
+	if traded {
+		p := price.Value
+		if st.cur.Ticks == 0 {
+			st.cur.Open, st.cur.High, st.cur.Low = p, p, p
+		}
+		st.cur.High = max(st.cur.High, p)
+		st.cur.Low = min(st.cur.Low, p)
+		st.cur.Close = p
+		st.cur.Ticks++
+	}
+	st.cur.Volume += volume
+	return bars
+}
+
+// Advance moves the clock to now, if that is later than the last update, and
+// returns the bars that completed, including empty bars for silent
+// intervals. Call it periodically when updates may stop, so the last bars
+// before a lull are not held back.
+func (b *BarBuilder) Advance(now time.Time) []Bar {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.advance(now)
+}
+
+// advance moves the clock forward and emits completed bars, ordered by end
+// time and then conid. b.mu must be held.
+func (b *BarBuilder) advance(now time.Time) []Bar {
+	if !now.After(b.clock) {
+		return nil
+	}
+	b.clock = now
+	var bars []Bar
+	for conid, st := range b.states {
+		if st.open && !st.cur.End.After(now) {
+			bar := st.cur
+			if bar.Ticks == 0 {
+				// Volume without a price: the trade price update was missed
+				// or coalesced, so carry the last price.
+				bar.Empty = true
+				if st.hasLast {
+					bar.Open, bar.High, bar.Low, bar.Close = st.last, st.last, st.last, st.last
+				}
+			} else {
+				st.last, st.hasLast = bar.Close, true
+			}
+			bars = append(bars, bar)
+			st.open = false
+			st.next = bar.End
+			st.produced = true
+		}
+		if b.empty == EmptyBarsSkip || !st.produced {
+			continue
+		}
+		if b.empty == EmptyBarsCarry && !st.hasLast {
+			continue
+		}
+		for n := 0; !st.next.Add(b.interval).After(now); n++ {
+			if b.maxEmpty > 0 && n >= b.maxEmpty {
+				st.next = b.align(now)
+				break
+			}
+			bar := Bar{Conid: conid, Start: st.next, End: st.next.Add(b.interval), Empty: true}
+			if b.empty == EmptyBarsCarry {
+				bar.Open, bar.High, bar.Low, bar.Close = st.last, st.last, st.last, st.last
+			}
+			bars = append(bars, bar)
+			st.next = bar.End
+		}
+	}
+	slices.SortFunc(bars, func(x, y Bar) int {
+		if c := x.End.Compare(y.End); c != 0 {
+			return c
+		}
+		return cmp.Compare(x.Conid, y.Conid)
+	})
+	return bars
+}
+
+// Run feeds every update from src into the builder and delivers the completed
+// bars on the returned channel, which is closed when src ends; a bar still
+// open then is discarded. Between updates the clock runs on from the last
+// update's time, so bars complete on time during a lull, whether src is a
+// live Stream or a Replay. The channel must be drained.
+func (b *BarBuilder) Run(src UpdateSource) <-chan Bar {
+	out := make(chan Bar, 64)
+	go func() {
+		defer close(out)
+		tick := time.NewTicker(max(b.interval/10, 10*time.Millisecond))
+		defer tick.Stop()
+		var lastTime, lastWall time.Time
+		updates := src.Updates()
+		for {
+			var bars []Bar
+			select {
+			case u, ok := <-updates:
+				if !ok {
+					return
+				}
+				lastWall = b.wallClock()
+				lastTime = u.Received
+				if lastTime.IsZero() {
+					lastTime = lastWall
+				}
+				bars = b.Add(u)
+			case <-tick.C:
+				if lastTime.IsZero() {
+					continue
+				}
+				bars = b.Advance(lastTime.Add(b.wallClock().Sub(lastWall)))
+			}
+			for _, bar := range bars {
+				out <- bar
+			}
+		}
+	}()
+	return out
+}
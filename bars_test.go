@@ -0,0 +1,124 @@
+package ibclientportal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBarBuilder(t *testing.T) {
+	t.Parallel()
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	b, err := NewBarBuilder(BarBuilderOptions{
+		Interval:  time.Minute,
+		Location:  ny,
+		EmptyBars: EmptyBarsCarry,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := time.Date(2026, 10, 19, 9, 30, 0, 0, ny)
+	tick := func(conid int, offset time.Duration, fields string) []Bar {
+		u := testUpdate(conid, fields)
+		u.Received = open.Add(offset)
+		return b.Add(u)
+	}
+
+	tick(1, 0, `{"31":"100.00","87":"1.00K"}`)
+	tick(1, 10*time.Second, `{"31":"101.50","87":"1.20K"}`)
+	tick(1, 20*time.Second, `{"31":"C99.00"}`) // a previous close is not a trade
+	tick(1, 30*time.Second, `{"31":"99.50","87":"1.50K"}`)
+	bars := tick(1, 61*time.Second, `{"31":"100.25","87":"1.60K"}`)
+	if len(bars) != 1 {
+		t.Fatalf("got %d bars, want 1", len(bars))
+	}
+	want := Bar{
+		Conid: 1, Start: open, End: open.Add(time.Minute),
+		Open: 100, High: 101.5, Low: 99.5, Close: 99.5, Volume: 500, Ticks: 3,
+	}
+	if got := bars[0]; got != want {
+		t.Errorf("first bar = %+v, want %+v", got, want)
+	}
+
+	// Two silent minutes, then a session reset of the cumulative volume.
+	bars = tick(1, 4*time.Minute+5*time.Second, `{"31":"100.75","87":"200"}`)
+	if len(bars) != 3 {
+		t.Fatalf("got %d bars, want 3: %+v", len(bars), bars)
+	}
+	if bars[0].Close != 100.25 || bars[0].Volume != 100 {
+		t.Errorf("second bar = %+v", bars[0])
+	}
+	for _, bar := range bars[1:] {
+		if !bar.Empty || bar.Open != 100.25 || bar.Close != 100.25 || bar.Volume != 0 {
+			t.Errorf("empty bar = %+v, want flat at 100.25", bar)
+		}
+	}
+	if !bars[2].Start.Equal(open.Add(3 * time.Minute)) {
+		t.Errorf("last empty bar starts %v", bars[2].Start)
+	}
+	bars = b.Advance(open.Add(5 * time.Minute))
+	if len(bars) != 1 || bars[0].Volume != 200 || bars[0].Close != 100.75 {
+		t.Errorf("bar after the reset = %+v, want volume 200", bars)
+	}
+}
+
+func TestBarBuilderOptions(t *testing.T) {
+	t.Parallel()
+	if _, err := NewBarBuilder(BarBuilderOptions{Interval: 7 * time.Hour, Location: time.UTC}); err == nil {
+		t.Error("expected an error for an interval that does not divide a day")
+	}
+	b, err := NewBarBuilder(BarBuilderOptions{
+		Interval:     5 * time.Second,
+		Location:     time.UTC,
+		EmptyBars:    EmptyBarsZero,
+		MaxEmptyBars: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 19, 13, 30, 2, 0, time.UTC)
+	u := testUpdate(7, `{"31":"5.00"}`)
+	u.Received = start
+	b.Add(u)
+	bars := b.Advance(start.Add(time.Minute))
+	if len(bars) != 3 {
+		t.Fatalf("got %d bars, want the bar and 2 empty ones: %+v", len(bars), bars)
+	}
+	if !bars[0].Start.Equal(start.Add(-2 * time.Second)) {
+		t.Errorf("bar starts %v, want aligned to 13:30:00", bars[0].Start)
+	}
+	if !bars[1].Empty || bars[1].Close != 0 {
+		t.Errorf("empty bar = %+v, want zero prices", bars[1])
+	}
+	if bars := b.Advance(start.Add(time.Minute + 5*time.Second)); len(bars) != 1 {
+		t.Errorf("got %d bars after the capped gap, want 1", len(bars))
+	}
+}
+
+func TestBarBuilderRun(t *testing.T) {
+	t.Parallel()
+	b, err := NewBarBuilder(BarBuilderOptions{Interval: time.Second, Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &chanSource{ch: make(chan MarketDataUpdate)}
+	bars := b.Run(src)
+	u := testUpdate(3, `{"31":"1.00"}`)
+	u.Received = time.Now().Add(-900 * time.Millisecond)
+	src.ch <- u
+	// No further updates: the bar completes on the clock.
+	select {
+	case bar := <-bars:
+		if bar.Conid != 3 || bar.Close != 1 {
+			t.Errorf("bar = %+v", bar)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the bar to complete during a lull")
+	}
+	close(src.ch)
+	if _, ok := <-bars; ok {
+		t.Error("expected the bars channel to close with the source")
+	}
+}
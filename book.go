@@ -0,0 +1,274 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BookLevel is one price level of an OrderBook.
+type BookLevel struct {
+	// Price is the level's price.
+	Price float64
+	// Size is the quantity bid or offered at Price.
+	Size float64
+	// Exchange is the exchange quoting the level, when the gateway attributes
+	// it; otherwise the exchange the book was requested from ("SMART" when
+	// none was given).
+	Exchange string
+}
+
+// OrderBook is a consistent snapshot of a contract's market depth, built from
+// the gateway's "sbd" frames. Bids are ordered best (highest) first and Asks
+// best (lowest) first.
+type OrderBook struct {
+	// Conid is the contract the book is for.
+	Conid int
+	// Account is the account the book was requested under.
+	Account string
+	// Bids are the bid levels, best first.
+	Bids []BookLevel
+	// Asks are the ask levels, best first.
+	Asks []BookLevel
+	// Last is the last traded price, from the ladder row the gateway marks as
+	// its focus, and LastSize the size traded there if it sent one.
+	Last     float64
+	LastSize float64
+	// Seq counts the frames applied to the book, starting at 1. It resets when
+	// the stream reconnects and the gateway resends the book.
+	Seq uint64
+	// Updated is when the last frame was applied.
+	Updated time.Time
+}
+
+// Spread returns the difference between the best ask and the best bid. The
+// second return value is false if either side is empty.
+func (b OrderBook) Spread() (float64, bool) {
+	if len(b.Bids) == 0 || len(b.Asks) == 0 {
+		return 0, false
+	}
+	return b.Asks[0].Price - b.Bids[0].Price, true
+}
+
+// bookRow is one row of the gateway's price ladder. The gateway numbers the
+// rows and updates them in place: asks above the focus row, bids below.
+type bookRow struct {
+	price    float64
+	bid, ask float64
+	lastSize float64
+	focus    bool
+	exchange string
+}
+
+// orderBook is the maintained state of one book.
+type orderBook struct {
+	conid    int
+	account  string
+	exchange string
+	rows     map[int]bookRow
+	seq      uint64
+	updated  time.Time
+}
+
+func newOrderBook(account string, conid int, exchange string) *orderBook {
+	if exchange == "" {
+		exchange = "SMART"
+	}
+	return &orderBook{
+		conid:    conid,
+		account:  account,
+		exchange: exchange,
+		rows:     make(map[int]bookRow),
+	}
+}
+
+// rawBookRow is a ladder row as the gateway sends it. Sizes and prices are
+// strings; a price may carry the last trade size in parentheses, as in
+// "192.28 (100)".
+type rawBookRow struct {
+	Row      int    `json:"row"`
+	Focus    int    `json:"focus"`
+	Price    string `json:"price"`
+	Bid      string `json:"bid"`
+	Ask      string `json:"ask"`
+	Exchange string `json:"exchange"`
+}
+
+// apply applies one "sbd" frame's rows to the book, replacing each row it
+// carries. A row sent with a price but no size has been emptied; a row sent
+// with no price has been removed.
+func (b *orderBook) apply(data []byte, now time.Time) error {
+	var frame struct {
+		Data []rawBookRow `json:"data"`
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return err
+	}
+	for _, raw := range frame.Data {
+		if strings.TrimSpace(raw.Price) == "" {
+			delete(b.rows, raw.Row)
+			continue
+		}
+		row, err := parseBookRow(raw)
+		if err != nil {
+			return err
+		}
+		b.rows[raw.Row] = row
+	}
+	b.seq++
+	b.updated = now
+	return nil
+}
+
+func parseBookRow(raw rawBookRow) (bookRow, error) {
+	row := bookRow{focus: raw.Focus == 1, exchange: raw.Exchange}
+	price, last, _ := strings.Cut(raw.Price, "(")
+	p, err := ParseNumber(price)
+	if err != nil {
+		return row, fmt.Errorf("ibclientportal: book row %d: %w", raw.Row, err)
+	}
+	row.price = p
+	if last = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(last), ")")); last != "" {
+		row.lastSize, _ = ParseNumber(last)
+	}
+	if raw.Bid != "" {
+		if row.bid, err = ParseNumber(raw.Bid); err != nil {
+			return row, fmt.Errorf("ibclientportal: book row %d: %w", raw.Row, err)
+		}
+	}
+	if raw.Ask != "" {
+		if row.ask, err = ParseNumber(raw.Ask); err != nil {
+			return row, fmt.Errorf("ibclientportal: book row %d: %w", raw.Row, err)
+		}
+	}
+	return row, nil
+}
+
+// snapshot returns a copy of the book that shares nothing with it.
+func (b *orderBook) snapshot() OrderBook {
+	ob := OrderBook{
+		Conid:   b.conid,
+		Account: b.account,
+		Seq:     b.seq,
+		Updated: b.updated,
+	}
+	for _, row := range b.rows {
+		exchange := row.exchange
+		if exchange == "" {
+			exchange = b.exchange
+		}
+		if row.bid > 0 {
+			ob.Bids = append(ob.Bids, BookLevel{Price: row.price, Size: row.bid, Exchange: exchange})
+		}
+		if row.ask > 0 {
+			ob.Asks = append(ob.Asks, BookLevel{Price: row.price, Size: row.ask, Exchange: exchange})
+		}
+		if row.focus {
+			ob.Last = row.price
+			ob.LastSize = row.lastSize
+		}
+	}
+	slices.SortFunc(ob.Bids, func(a, b BookLevel) int { return compareFloat(b.Price, a.Price) })
+	slices.SortFunc(ob.Asks, func(a, b BookLevel) int { return compareFloat(a.Price, b.Price) })
+	return ob
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// SubscribeBook streams market depth for a contract (the "sbd" topic). The
+// gateway requires the account the book is requested under; exchange selects
+// a single venue and may be empty for IB's aggregated SMART book.
+//
+// The Stream applies each frame to a maintained OrderBook, in the order the
+// frames arrive, and delivers a snapshot of the whole book after each one.
+// Books for every contract share one channel, which behaves as the one from
+// SubscribeOrders does. Read the current book at any time with Book. The
+// subscription is replayed on reconnect, and the book is rebuilt from the
+// gateway's fresh ladder.
+func (s *Stream) SubscribeBook(accountID string, conid int, exchange string) (<-chan OrderBook, error) {
+	if accountID == "" {
+		return nil, fmt.Errorf("ibclientportal: SubscribeBook: no account ID given")
+	}
+	msg := "sbd+" + accountID + "+" + strconv.Itoa(conid)
+	if exchange != "" {
+		msg += "+" + exchange
+	}
+	s.subsMu.Lock()
+	ch := s.books.open(s)
+	s.depth[bookTopic(accountID, conid)] = newOrderBook(accountID, conid, exchange)
+	s.subsMu.Unlock()
+	return ch, s.subscribeTopic(bookTopic(accountID, conid), msg)
+}
+
+// UnsubscribeBook stops market depth for every contract subscribed under the
+// account; the gateway offers no way to stop a single contract's book.
+func (s *Stream) UnsubscribeBook(accountID string) error {
+	prefix := "sbd+" + accountID + "+"
+	s.subsMu.Lock()
+	for key := range s.depth {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.depth, key)
+			delete(s.topics, key)
+		}
+	}
+	s.subsMu.Unlock()
+	return s.writeText("ubd+" + accountID)
+}
+
+// Book returns a snapshot of a contract's current order book. The second
+// return value is false if the contract's book is not subscribed. If the book
+// is subscribed under several accounts, any one of them is returned.
+func (s *Stream) Book(conid int) (OrderBook, bool) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for _, b := range s.depth {
+		if b.conid == conid {
+			return b.snapshot(), true
+		}
+	}
+	return OrderBook{}, false
+}
+
+func bookTopic(accountID string, conid int) string {
+	return "sbd+" + accountID + "+" + strconv.Itoa(conid)
+}
+
+// resetBooks empties every book, because the gateway resends each ladder in
+// full when the subscriptions are replayed after a reconnect.
+func (s *Stream) resetBooks() {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for key, b := range s.depth {
+		s.depth[key] = newOrderBook(b.account, b.conid, b.exchange)
+	}
+}
+
+// dispatchBook applies an "sbd" frame to its book and delivers a snapshot.
+func (s *Stream) dispatchBook(topic string, data []byte) {
+	s.subsMu.Lock()
+	b := s.depth[topic]
+	if b == nil {
+		s.subsMu.Unlock()
+		return
+	}
+	if err := b.apply(data, time.Now()); err != nil {
+		s.subsMu.Unlock()
+		wsDebugf("applying %s frame: %v", topic, err)
+		return
+	}
+	snap := b.snapshot()
+	ch := s.books.ch
+	s.subsMu.Unlock()
+	deliver(s, ch, snap)
+}
@@ -0,0 +1,148 @@
+package ibclientportal
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestStreamBook replays "sbd" frames through a fake gateway and checks the
+// book maintained from them.
+//
+// The frames in testdata/sbd_265598.jsonl are synthetic, not captured from a
+// gateway. Their shape follows IBKR's Client Portal websocket documentation
+// for the "sbd" (BookTrader) topic: numbered ladder rows with a price and a
+// bid or ask size, sizes with thousands separators, the focus row carrying
+// the last price and size as "192.30 (100)", and rows updated in place by
+// number. The later frames exercise partial updates, a focus move, a row
+// cleared with an empty price and an exchange attribution.
+func TestStreamBook(t *testing.T) {
+	t.Parallel()
+
+	recorded, err := os.ReadFile("testdata/sbd_265598.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := bytes.Split(bytes.TrimSpace(recorded), []byte("\n"))
+
+	received := make(chan string, 4)
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg := string(data)
+			if msg == "tic" {
+				continue
+			}
+			received <- msg
+			if msg == "sbd+DU123+265598" {
+				for _, frame := range frames {
+					conn.WriteMessage(websocket.TextMessage, frame)
+				}
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := New(srv.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatalf("DialStream: %v", err)
+	}
+	defer stream.Close()
+
+	books, err := stream.SubscribeBook("DU123", 265598, "")
+	if err != nil {
+		t.Fatalf("SubscribeBook: %v", err)
+	}
+	var got []OrderBook
+	for len(got) < len(frames) {
+		select {
+		case b := <-books:
+			got = append(got, b)
+		case <-ctx.Done():
+			t.Fatalf("timed out after %d books", len(got))
+		}
+	}
+
+	first := got[0]
+	if first.Seq != 1 || len(first.Bids) != 3 || len(first.Asks) != 3 {
+		t.Fatalf("unexpected first book: %+v", first)
+	}
+	if first.Asks[0].Price != 192.31 || first.Asks[1].Size != 1200 || first.Bids[0].Price != 192.29 {
+		t.Errorf("first book levels out of order: %+v", first)
+	}
+	if spread, ok := first.Spread(); !ok || spread < 0.0199 || spread > 0.0201 {
+		t.Errorf("first spread = %v, %v; want 0.02", spread, ok)
+	}
+	if got[1].Asks[0].Size != 500 || got[1].Bids[0].Size != 100 {
+		t.Errorf("second book did not apply the size changes: %+v", got[1])
+	}
+
+	final := got[len(got)-1]
+	wantBids := []BookLevel{
+		{Price: 192.29, Size: 100, Exchange: "SMART"},
+		{Price: 192.28, Size: 700, Exchange: "SMART"},
+		{Price: 192.26, Size: 900, Exchange: "ARCA"},
+	}
+	wantAsks := []BookLevel{
+		{Price: 192.32, Size: 1200, Exchange: "SMART"},
+		{Price: 192.33, Size: 300, Exchange: "SMART"},
+	}
+	if !reflect.DeepEqual(final.Bids, wantBids) {
+		t.Errorf("final bids = %+v, want %+v", final.Bids, wantBids)
+	}
+	if !reflect.DeepEqual(final.Asks, wantAsks) {
+		t.Errorf("final asks = %+v, want %+v", final.Asks, wantAsks)
+	}
+	if final.Last != 192.31 || final.LastSize != 200 || final.Seq != 4 {
+		t.Errorf("final last = %v x %v (seq %d), want 192.31 x 200 (seq 4)", final.Last, final.LastSize, final.Seq)
+	}
+
+	// Snapshots share nothing with the maintained book.
+	final.Bids[0].Size = -1
+	current, ok := stream.Book(265598)
+	if !ok || current.Bids[0].Size != 100 {
+		t.Errorf("Book(265598) = %+v, %v", current, ok)
+	}
+
+	if err := stream.UnsubscribeBook("DU123"); err != nil {
+		t.Fatalf("UnsubscribeBook: %v", err)
+	}
+	for _, want := range []string{"sbd+DU123+265598", "ubd+DU123"} {
+		select {
+		case msg := <-received:
+			if msg != want {
+				t.Errorf("gateway received %q, want %q", msg, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	if _, ok := stream.Book(265598); ok {
+		t.Error("expected no book after UnsubscribeBook")
+	}
+}
@@ -70,8 +70,8 @@ const (
 	fieldLastPrice    = ibclientportal.FieldLastPrice
 	fieldBidPrice     = ibclientportal.FieldBidPrice
 	fieldAskPrice     = ibclientportal.FieldAskPrice
-	fieldAvailability = "6509"
-	fieldServerID     = "6119"
+	fieldAvailability = ibclientportal.FieldAvailability
+	fieldServerID     = ibclientportal.FieldServerID
 )
 
 var probeFields = []string{fieldLastPrice, fieldBidPrice, fieldAskPrice, fieldAvailability, fieldServerID}
@@ -0,0 +1,94 @@
+// Command ibclientportal-proxy fronts a Client Portal Gateway on a local port,
+// so that every tool talking to the gateway shares one rate limiter, one
+// response cache and one request log.
+//
+// The gateway's rate limits apply to the session, not to each program, so
+// tools that each throttle themselves still trip them together. Point them at
+// the proxy instead of the gateway:
+//
+//	client := ibclientportal.New("http://localhost:5002")
+//
+// The proxy applies the gateway's documented limits across all of them,
+// caches contract, security-definition and account-list responses for a
+// while, holds account switches until the requests in flight under the old
+// account have finished, and logs every request. Websocket connections are
+// passed through.
+//
+// Usage:
+//
+//	ibclientportal-proxy --host https://localhost:5000 --insecure --listen localhost:5002
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/ibclientportal"
+	"github.com/kevinburke/ibclientportal/proxy"
+)
+
+func main() {
+	host := flag.String("host", "", "gateway base URL (defaults to $IBCLIENTPORTAL_HOST, then "+ibclientportal.DefaultHost+")")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate verification (needed for the default self-signed localhost gateway)")
+	listen := flag.String("listen", "localhost:5002", "address to serve the proxy on")
+	noCache := flag.Bool("no-cache", false, "forward every request, caching nothing")
+	version := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *version {
+		fmt.Println("ibclientportal-proxy version " + ibclientportal.Version)
+		os.Exit(0)
+	}
+	if *host == "" {
+		*host = os.Getenv("IBCLIENTPORTAL_HOST")
+	}
+	if *host == "" {
+		*host = ibclientportal.DefaultHost
+	}
+	upstream, err := url.Parse(strings.TrimRight(*host, "/"))
+	if err != nil || upstream.Host == "" {
+		slog.Error("bad gateway URL", "host", *host, "error", err)
+		os.Exit(2)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if *insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	opts := proxy.Options{Upstream: upstream, Transport: transport}
+	if *noCache {
+		opts.CacheRules = []proxy.CacheRule{}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		slog.Error("could not listen", "addr", *listen, "error", err)
+		os.Exit(1)
+	}
+	srv := &http.Server{Handler: proxy.NewServer(opts), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	slog.Info("serving", "addr", "http://"+ln.Addr().String(), "upstream", upstream.String())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
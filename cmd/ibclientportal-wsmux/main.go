@@ -0,0 +1,103 @@
+// Command ibclientportal-wsmux shares one Client Portal Gateway websocket
+// among many local processes.
+//
+// IBKR allows one brokerage session per user, and a stream belongs to the
+// process that dialed it. ibclientportal-wsmux holds a single upstream stream
+// and serves the gateway's market-data websocket protocol on a local port.
+// Point clients at it instead of the gateway:
+//
+//	stream, err := ibclientportal.New("http://localhost:5001").DialStream(ctx)
+//
+// Subscriptions are counted across clients: the gateway is asked once per
+// conid, for the union of the fields the clients want, so a contract uses one
+// market-data line however many clients watch it, and is unsubscribed when
+// the last of them stops. Only market data ("smd") is relayed.
+//
+// Usage:
+//
+//	ibclientportal-wsmux --host https://localhost:5000 --insecure --listen localhost:5001
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/ibclientportal"
+	"github.com/kevinburke/ibclientportal/wsmux"
+)
+
+func main() {
+	host := flag.String("host", "", "gateway base URL (defaults to $IBCLIENTPORTAL_HOST, then "+ibclientportal.DefaultHost+")")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate verification (needed for the default self-signed localhost gateway)")
+	listen := flag.String("listen", "localhost:5001", "address to serve the local websocket on")
+	maxLines := flag.Int("max-lines", 0, "refuse subscriptions beyond this many market-data lines (0: no limit)")
+	version := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *version {
+		fmt.Println("ibclientportal-wsmux version " + ibclientportal.Version)
+		os.Exit(0)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	client := ibclientportal.New(strings.TrimRight(*host, "/"))
+	if *insecure {
+		client.SetInsecureSkipVerify()
+	}
+	if *maxLines > 0 {
+		client.SetLineManager(ibclientportal.NewLineManager(ibclientportal.LineManagerOptions{MaxLines: *maxLines}))
+	}
+	// Conflate, so that one slow client costs itself intermediate ticks
+	// rather than stalling the others.
+	stream, err := client.DialStreamWithOptions(ctx, ibclientportal.DialStreamOptions{
+		Backpressure: ibclientportal.BackpressureConflate,
+	})
+	if err != nil {
+		slog.Error("could not open the upstream stream", "error", err)
+		os.Exit(1)
+	}
+	defer stream.Close()
+	go func() {
+		for ev := range stream.States() {
+			slog.Info("upstream stream", "state", ev.State.String(), "attempt", ev.Attempt, "error", ev.Err)
+		}
+	}()
+
+	mux := wsmux.NewServerWithOptions(stream, wsmux.Options{Logger: slog.Default()})
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		slog.Error("could not listen", "addr", *listen, "error", err)
+		os.Exit(1)
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-mux.Done():
+		}
+		mux.Close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	slog.Info("serving", "addr", "ws://"+ln.Addr().String()+"/v1/api/ws")
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		slog.Error("upstream stream ended", "error", err)
+		os.Exit(1)
+	}
+}
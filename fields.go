@@ -0,0 +1,327 @@
+package ibclientportal
+
+import (
+	"slices"
+	"strconv"
+)
+
+// Market data field codes used by the streaming websocket (and the REST
+// /iserver/marketdata/snapshot endpoint). These are passed in the fields
+// argument to (*Stream).SubscribeMarketData and appear as keys in the
+// MarketDataUpdate.Fields map. LookupField describes each one.
+//
+// https://www.interactivebrokers.com/campus/ibkr-api-page/cpapi-v1/#market-data-fields
+const (
+	FieldLastPrice                 = "31"
+	FieldSymbol                    = "55"
+	FieldText                      = "58"
+	FieldHigh                      = "70"
+	FieldLow                       = "71"
+	FieldMarketValue               = "73"
+	FieldAvgPrice                  = "74"
+	FieldUnrealizedPnL             = "75"
+	FieldFormattedPosition         = "76"
+	FieldFormattedUnrealizedPnL    = "77"
+	FieldDailyPnL                  = "78"
+	FieldRealizedPnL               = "79"
+	FieldUnrealizedPnLPercent      = "80"
+	FieldChange                    = "82"
+	FieldChangePercent             = "83"
+	FieldBidPrice                  = "84"
+	FieldAskSize                   = "85"
+	FieldAskPrice                  = "86"
+	FieldVolume                    = "87"
+	FieldBidSize                   = "88"
+	FieldRight                     = "201"
+	FieldExchange                  = "6004"
+	FieldConid                     = "6008"
+	FieldSecType                   = "6070"
+	FieldMonths                    = "6072"
+	FieldRegularExpiry             = "6073"
+	FieldServerID                  = "6119"
+	FieldUnderlyingConid           = "6457"
+	FieldServiceParams             = "6508"
+	FieldAvailability              = "6509"
+	FieldCompanyName               = "7051"
+	FieldAskExchange               = "7057"
+	FieldLastExchange              = "7058"
+	FieldLastSize                  = "7059"
+	FieldBidExchange               = "7068"
+	FieldImpliedVolHistVolPercent  = "7084"
+	FieldPutCallInterest           = "7085"
+	FieldPutCallVolume             = "7086"
+	FieldHistoricalVolPercent      = "7087"
+	FieldHistoricalVolClosePercent = "7088"
+	FieldOptionVolume              = "7089"
+	FieldConidEx                   = "7094"
+	FieldCanBeTraded               = "7184"
+	FieldContractDescription       = "7219"
+	FieldContractDescription2      = "7220"
+	FieldListingExchange           = "7221"
+	FieldIndustry                  = "7280"
+	FieldCategory                  = "7281"
+	FieldAverageVolume             = "7282"
+	FieldOptionImpliedVolPercent   = "7283"
+	FieldHistoricalVol             = "7284"
+	FieldPutCallRatio              = "7285"
+	FieldDividendAmount            = "7286"
+	FieldDividendYieldPercent      = "7287"
+	FieldExDividendDate            = "7288"
+	FieldMarketCap                 = "7289"
+	FieldPE                        = "7290"
+	FieldEPS                       = "7291"
+	FieldCostBasis                 = "7292"
+	Field52WeekHigh                = "7293"
+	Field52WeekLow                 = "7294"
+	FieldOpen                      = "7295"
+	FieldClose                     = "7296"
+	FieldDelta                     = "7308"
+	FieldGamma                     = "7309"
+	FieldTheta                     = "7310"
+	FieldVega                      = "7311"
+	FieldOptionVolumeChangePercent = "7607"
+	FieldImpliedVolPercent         = "7633"
+	FieldMark                      = "7635"
+	FieldShortableShares           = "7636"
+	FieldFeeRate                   = "7637"
+	FieldOptionOpenInterest        = "7638"
+	FieldPercentOfMarkValue        = "7639"
+	FieldShortable                 = "7644"
+	FieldMorningstarRating         = "7655"
+	FieldDividends                 = "7671"
+	FieldDividendsTTM              = "7672"
+	FieldEMA200                    = "7674"
+	FieldEMA100                    = "7675"
+	FieldEMA50                     = "7676"
+	FieldEMA20                     = "7677"
+	FieldPriceToEMA200             = "7678"
+	FieldPriceToEMA100             = "7679"
+	FieldPriceToEMA20              = "7681"
+	FieldChangeSinceOpen           = "7682"
+	FieldUpcomingEvent             = "7683"
+	FieldUpcomingEventDate         = "7684"
+	FieldUpcomingAnalystMeeting    = "7685"
+	FieldUpcomingEarnings          = "7686"
+	FieldUpcomingMiscEvent         = "7687"
+	FieldRecentAnalystMeeting      = "7688"
+	FieldRecentEarnings            = "7689"
+	FieldRecentMiscEvent           = "7690"
+	FieldProbabilityOfMaxReturn    = "7694"
+	FieldBreakEven                 = "7695"
+	FieldSPXDelta                  = "7696"
+	FieldFuturesOpenInterest       = "7697"
+	FieldLastYield                 = "7698"
+	FieldBidYield                  = "7699"
+	FieldProbabilityOfMaxLoss      = "7702"
+	FieldProfitProbability         = "7703"
+	FieldOrganizationType          = "7704"
+	FieldDebtClass                 = "7705"
+	FieldRatings                   = "7706"
+	FieldBondStateCode             = "7707"
+	FieldBondType                  = "7708"
+	FieldLastTradingDate           = "7714"
+	FieldIssueDate                 = "7715"
+	FieldBeta                      = "7718"
+	FieldAskYield                  = "7720"
+	FieldPriceToEMA50              = "7724"
+	FieldPriorClose                = "7741"
+	FieldVolumeLong                = "7762"
+	FieldHasTradingPermissions     = "7768"
+	FieldDailyPnLRaw               = "7920"
+	FieldCostBasisRaw              = "7921"
+)
+
+// ValueType describes how a market-data field's value is encoded.
+type ValueType int
+
+const (
+	// ValueString is free text, or a code with its own vocabulary.
+	ValueString ValueType = iota
+	// ValuePrice is a price, which IB may prefix with a marker letter; see
+	// ParsePrice.
+	ValuePrice
+	// ValueNumber is a number, which IB may abbreviate with a K, M or B
+	// suffix; see ParseNumber.
+	ValueNumber
+	// ValuePercent is a percentage, sent with or without a trailing "%".
+	ValuePercent
+	// ValueDate is a date, in a format that varies by field.
+	ValueDate
+	// ValueBool is a flag, sent as "1"/"0" or "true"/"false".
+	ValueBool
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case ValueString:
+		return "string"
+	case ValuePrice:
+		return "price"
+	case ValueNumber:
+		return "number"
+	case ValuePercent:
+		return "percent"
+	case ValueDate:
+		return "date"
+	case ValueBool:
+		return "bool"
+	}
+	return "ValueType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// FieldInfo describes one market-data field code.
+type FieldInfo struct {
+	// Code is the numeric field code, as a string, e.g. "31".
+	Code string
+	// Name is IB's display name for the field.
+	Name string
+	// Type is how the value is encoded.
+	Type ValueType
+	// Unit is what a numeric value measures, e.g. "contract currency" or
+	// "percent". It is empty for non-numeric fields.
+	Unit string
+	// Description adds detail IB documents about the value, if any.
+	Description string
+}
+
+// fieldCatalogue is every field code IB documents, ordered by code.
+var fieldCatalogue = []FieldInfo{
+	{Code: FieldLastPrice, Name: "Last Price", Type: ValuePrice, Unit: "contract currency", Description: "The last traded price. A \"C\" prefix marks the previous close (no trade yet today) and an \"H\" prefix a halted contract."},
+	{Code: FieldSymbol, Name: "Symbol", Type: ValueString},
+	{Code: FieldText, Name: "Text", Type: ValueString},
+	{Code: FieldHigh, Name: "High", Type: ValuePrice, Unit: "contract currency", Description: "The current day's high price."},
+	{Code: FieldLow, Name: "Low", Type: ValuePrice, Unit: "contract currency", Description: "The current day's low price."},
+	{Code: FieldMarketValue, Name: "Market Value", Type: ValueNumber, Unit: "account currency", Description: "The market value of the account's position."},
+	{Code: FieldAvgPrice, Name: "Average Price", Type: ValuePrice, Unit: "contract currency", Description: "The average price of the account's position."},
+	{Code: FieldUnrealizedPnL, Name: "Unrealized PnL", Type: ValueNumber, Unit: "account currency"},
+	{Code: FieldFormattedPosition, Name: "Formatted Position", Type: ValueString},
+	{Code: FieldFormattedUnrealizedPnL, Name: "Formatted Unrealized PnL", Type: ValueString},
+	{Code: FieldDailyPnL, Name: "Daily PnL", Type: ValueNumber, Unit: "account currency"},
+	{Code: FieldRealizedPnL, Name: "Realized PnL", Type: ValueNumber, Unit: "account currency"},
+	{Code: FieldUnrealizedPnLPercent, Name: "Unrealized PnL %", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldChange, Name: "Change", Type: ValueNumber, Unit: "contract currency", Description: "The difference between the last price and the prior close."},
+	{Code: FieldChangePercent, Name: "Change %", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldBidPrice, Name: "Bid Price", Type: ValuePrice, Unit: "contract currency"},
+	{Code: FieldAskSize, Name: "Ask Size", Type: ValueNumber, Unit: "shares or contracts", Description: "For US stocks the size is in lots of 100 shares."},
+	{Code: FieldAskPrice, Name: "Ask Price", Type: ValuePrice, Unit: "contract currency"},
+	{Code: FieldVolume, Name: "Volume", Type: ValueNumber, Unit: "shares or contracts", Description: "The day's volume, abbreviated with a K or M suffix."},
+	{Code: FieldBidSize, Name: "Bid Size", Type: ValueNumber, Unit: "shares or contracts", Description: "For US stocks the size is in lots of 100 shares."},
+	{Code: FieldRight, Name: "Right", Type: ValueString, Description: "\"P\" for a put or \"C\" for a call."},
+	{Code: FieldExchange, Name: "Exchange", Type: ValueString},
+	{Code: FieldConid, Name: "Conid", Type: ValueNumber},
+	{Code: FieldSecType, Name: "Security Type", Type: ValueString},
+	{Code: FieldMonths, Name: "Months", Type: ValueString},
+	{Code: FieldRegularExpiry, Name: "Regular Expiry", Type: ValueString},
+	{Code: FieldServerID, Name: "Market Data Delivery Marker", Type: ValueString, Description: "Internal marker for the market-data delivery method."},
+	{Code: FieldUnderlyingConid, Name: "Underlying Conid", Type: ValueNumber},
+	{Code: FieldServiceParams, Name: "Service Params", Type: ValueString},
+	{Code: FieldAvailability, Name: "Market Data Availability", Type: ValueString, Description: "Whether the data is realtime, delayed, frozen or not subscribed, and whether it is streaming or a snapshot."},
+	{Code: FieldCompanyName, Name: "Company Name", Type: ValueString},
+	{Code: FieldAskExchange, Name: "Ask Exchange", Type: ValueString, Description: "The exchanges at the best ask, one capital-letter code each."},
+	{Code: FieldLastExchange, Name: "Last Exchange", Type: ValueString, Description: "The exchange of the last trade."},
+	{Code: FieldLastSize, Name: "Last Size", Type: ValueNumber, Unit: "shares or contracts", Description: "For US stocks the size is in lots of 100 shares."},
+	{Code: FieldBidExchange, Name: "Bid Exchange", Type: ValueString, Description: "The exchanges at the best bid, one capital-letter code each."},
+	{Code: FieldImpliedVolHistVolPercent, Name: "Implied Vol./Hist. Vol %", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldPutCallInterest, Name: "Put/Call Interest", Type: ValueNumber, Unit: "ratio"},
+	{Code: FieldPutCallVolume, Name: "Put/Call Volume", Type: ValueNumber, Unit: "ratio"},
+	{Code: FieldHistoricalVolPercent, Name: "Hist. Vol. %", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldHistoricalVolClosePercent, Name: "Hist. Vol. Close %", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldOptionVolume, Name: "Option Volume", Type: ValueNumber, Unit: "contracts"},
+	{Code: FieldConidEx, Name: "Conid + Exchange", Type: ValueString},
+	{Code: FieldCanBeTraded, Name: "Can Be Traded", Type: ValueBool},
+	{Code: FieldContractDescription, Name: "Contract Description", Type: ValueString},
+	{Code: FieldContractDescription2, Name: "Contract Description", Type: ValueString},
+	{Code: FieldListingExchange, Name: "Listing Exchange", Type: ValueString},
+	{Code: FieldIndustry, Name: "Industry", Type: ValueString},
+	{Code: FieldCategory, Name: "Category", Type: ValueString},
+	{Code: FieldAverageVolume, Name: "Average Volume", Type: ValueNumber, Unit: "shares or contracts", Description: "The average daily volume over 90 days."},
+	{Code: FieldOptionImpliedVolPercent, Name: "Option Implied Vol. %", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldHistoricalVol, Name: "Historic Volume (30d)", Type: ValuePercent, Unit: "percent", Description: "Deprecated by IB."},
+	{Code: FieldPutCallRatio, Name: "Put/Call Ratio", Type: ValueNumber, Unit: "ratio"},
+	{Code: FieldDividendAmount, Name: "Dividend Amount", Type: ValueNumber, Unit: "contract currency"},
+	{Code: FieldDividendYieldPercent, Name: "Dividend Yield %", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldExDividendDate, Name: "Ex-date of the Dividend", Type: ValueDate},
+	{Code: FieldMarketCap, Name: "Market Cap", Type: ValueNumber, Unit: "contract currency"},
+	{Code: FieldPE, Name: "P/E", Type: ValueNumber, Unit: "ratio"},
+	{Code: FieldEPS, Name: "EPS", Type: ValueNumber, Unit: "contract currency"},
+	{Code: FieldCostBasis, Name: "Cost Basis", Type: ValueNumber, Unit: "account currency"},
+	{Code: Field52WeekHigh, Name: "52 Week High", Type: ValuePrice, Unit: "contract currency"},
+	{Code: Field52WeekLow, Name: "52 Week Low", Type: ValuePrice, Unit: "contract currency"},
+	{Code: FieldOpen, Name: "Open", Type: ValuePrice, Unit: "contract currency", Description: "The current day's opening price."},
+	{Code: FieldClose, Name: "Close", Type: ValuePrice, Unit: "contract currency", Description: "The current day's closing price."},
+	{Code: FieldDelta, Name: "Delta", Type: ValueNumber, Unit: "ratio"},
+	{Code: FieldGamma, Name: "Gamma", Type: ValueNumber, Unit: "ratio"},
+	{Code: FieldTheta, Name: "Theta", Type: ValueNumber, Unit: "ratio"},
+	{Code: FieldVega, Name: "Vega", Type: ValueNumber, Unit: "ratio"},
+	{Code: FieldOptionVolumeChangePercent, Name: "Opt. Volume Change %", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldImpliedVolPercent, Name: "Implied Vol. %", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldMark, Name: "Mark", Type: ValuePrice, Unit: "contract currency"},
+	{Code: FieldShortableShares, Name: "Shortable Shares", Type: ValueNumber, Unit: "shares"},
+	{Code: FieldFeeRate, Name: "Fee Rate", Type: ValuePercent, Unit: "percent", Description: "The interest rate charged on borrowed shares."},
+	{Code: FieldOptionOpenInterest, Name: "Option Open Interest", Type: ValueNumber, Unit: "contracts"},
+	{Code: FieldPercentOfMarkValue, Name: "% of Mark Value", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldShortable, Name: "Shortable", Type: ValueString, Description: "How difficult the security is to sell short."},
+	{Code: FieldMorningstarRating, Name: "Morningstar Rating", Type: ValueString},
+	{Code: FieldDividends, Name: "Dividends", Type: ValueNumber, Unit: "contract currency"},
+	{Code: FieldDividendsTTM, Name: "Dividends TTM", Type: ValueNumber, Unit: "contract currency"},
+	{Code: FieldEMA200, Name: "EMA(200)", Type: ValuePrice, Unit: "contract currency"},
+	{Code: FieldEMA100, Name: "EMA(100)", Type: ValuePrice, Unit: "contract currency"},
+	{Code: FieldEMA50, Name: "EMA(50)", Type: ValuePrice, Unit: "contract currency"},
+	{Code: FieldEMA20, Name: "EMA(20)", Type: ValuePrice, Unit: "contract currency"},
+	{Code: FieldPriceToEMA200, Name: "Price/EMA(200)", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldPriceToEMA100, Name: "Price/EMA(100)", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldPriceToEMA20, Name: "Price/EMA(20)", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldChangeSinceOpen, Name: "Change Since Open", Type: ValueNumber, Unit: "contract currency"},
+	{Code: FieldUpcomingEvent, Name: "Upcoming Event", Type: ValueString},
+	{Code: FieldUpcomingEventDate, Name: "Upcoming Event Date", Type: ValueDate},
+	{Code: FieldUpcomingAnalystMeeting, Name: "Upcoming Analyst Meeting", Type: ValueString},
+	{Code: FieldUpcomingEarnings, Name: "Upcoming Earnings", Type: ValueString},
+	{Code: FieldUpcomingMiscEvent, Name: "Upcoming Misc Event", Type: ValueString},
+	{Code: FieldRecentAnalystMeeting, Name: "Recent Analyst Meeting", Type: ValueString},
+	{Code: FieldRecentEarnings, Name: "Recent Earnings", Type: ValueString},
+	{Code: FieldRecentMiscEvent, Name: "Recent Misc Event", Type: ValueString},
+	{Code: FieldProbabilityOfMaxReturn, Name: "Probability of Max Return", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldBreakEven, Name: "Break Even", Type: ValueString},
+	{Code: FieldSPXDelta, Name: "SPX Delta", Type: ValueNumber, Unit: "ratio"},
+	{Code: FieldFuturesOpenInterest, Name: "Futures Open Interest", Type: ValueNumber, Unit: "contracts"},
+	{Code: FieldLastYield, Name: "Last Yield", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldBidYield, Name: "Bid Yield", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldProbabilityOfMaxLoss, Name: "Probability of Max Loss", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldProfitProbability, Name: "Profit Probability", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldOrganizationType, Name: "Organization Type", Type: ValueString},
+	{Code: FieldDebtClass, Name: "Debt Class", Type: ValueString},
+	{Code: FieldRatings, Name: "Ratings", Type: ValueString},
+	{Code: FieldBondStateCode, Name: "Bond State Code", Type: ValueString},
+	{Code: FieldBondType, Name: "Bond Type", Type: ValueString},
+	{Code: FieldLastTradingDate, Name: "Last Trading Date", Type: ValueDate},
+	{Code: FieldIssueDate, Name: "Issue Date", Type: ValueDate},
+	{Code: FieldBeta, Name: "Beta", Type: ValueNumber, Unit: "ratio"},
+	{Code: FieldAskYield, Name: "Ask Yield", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldPriceToEMA50, Name: "Price/EMA(50)", Type: ValuePercent, Unit: "percent"},
+	{Code: FieldPriorClose, Name: "Prior Close", Type: ValuePrice, Unit: "contract currency"},
+	{Code: FieldVolumeLong, Name: "Volume Long", Type: ValueNumber, Unit: "shares or contracts", Description: "The day's volume as an unabbreviated number."},
+	{Code: FieldHasTradingPermissions, Name: "Has Trading Permissions", Type: ValueBool},
+	{Code: FieldDailyPnLRaw, Name: "Daily PnL Raw", Type: ValueNumber, Unit: "account currency"},
+	{Code: FieldCostBasisRaw, Name: "Cost Basis Raw", Type: ValueNumber, Unit: "account currency"},
+}
+
+var fieldsByCode = func() map[string]FieldInfo {
+	m := make(map[string]FieldInfo, len(fieldCatalogue))
+	for _, f := range fieldCatalogue {
+		m[f.Code] = f
+	}
+	return m
+}()
+
+// LookupField returns the description of a market-data field code. The second
+// return value is false for a code IB does not document.
+func LookupField(code string) (FieldInfo, bool) {
+	f, ok := fieldsByCode[code]
+	return f, ok
+}
+
+// MarketDataFields returns a description of every documented market-data
+// field, ordered by numeric code.
+func MarketDataFields() []FieldInfo {
+	return slices.Clone(fieldCatalogue)
+}
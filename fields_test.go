@@ -0,0 +1,50 @@
+package ibclientportal
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFieldCatalogue(t *testing.T) {
+	t.Parallel()
+	fields := MarketDataFields()
+	if len(fields) < 100 {
+		t.Errorf("expected the catalogue to cover over 100 codes, got %d", len(fields))
+	}
+	prev := -1
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		n, err := strconv.Atoi(f.Code)
+		if err != nil {
+			t.Errorf("field %q: code is not numeric", f.Code)
+			continue
+		}
+		if n <= prev {
+			t.Errorf("field %s is out of order after %d", f.Code, prev)
+		}
+		prev = n
+		if seen[f.Code] {
+			t.Errorf("field %s is listed twice", f.Code)
+		}
+		seen[f.Code] = true
+		if f.Name == "" {
+			t.Errorf("field %s has no name", f.Code)
+		}
+	}
+
+	info, ok := LookupField(FieldAvailability)
+	if !ok || info.Name != "Market Data Availability" || info.Type != ValueString {
+		t.Errorf("unexpected availability field info %#v (ok=%t)", info, ok)
+	}
+	if info, ok := LookupField(FieldLastPrice); !ok || info.Type != ValuePrice || info.Unit == "" {
+		t.Errorf("unexpected last price field info %#v (ok=%t)", info, ok)
+	}
+	if _, ok := LookupField("99999"); ok {
+		t.Error("LookupField reported an undocumented code as known")
+	}
+	// The returned slice is a copy; changing it must not corrupt lookups.
+	fields[0].Name = "changed"
+	if info, _ := LookupField(fields[0].Code); info.Name == "changed" {
+		t.Error("MarketDataFields returned the catalogue itself rather than a copy")
+	}
+}
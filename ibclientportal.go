@@ -29,6 +29,9 @@ type Client struct {
 	rateLimiter       *RateLimiter
 	selectedAccountMu sync.RWMutex
 	selectedAccount   string
+	linesMu           sync.Mutex
+	lines             *LineManager
+	suppression       questionSuppression
 
 	Contracts            *ContractService
 	MarketData           *MarketDataService
@@ -111,6 +114,9 @@ func (c *Client) Tickle(ctx context.Context, data url.Values) (TickleResponse, e
 	path := "/tickle"
 	var val TickleResponse
 	err := c.UpdateResource(ctx, path, data, &val)
+	if err == nil {
+		c.observeSession(ctx, val.Session, val.IServer.AuthStatus.Authenticated)
+	}
 	return val, err
 }
 
@@ -132,6 +138,9 @@ func (c *Client) AuthStatus(ctx context.Context) (AuthStatusResponse, error) {
 	path := "/iserver/auth/status"
 	var val AuthStatusResponse
 	err := c.UpdateResource(ctx, path, nil, &val)
+	if err == nil {
+		c.observeSession(ctx, "", val.Authenticated)
+	}
 	return val, err
 }
 
@@ -515,6 +524,9 @@ func (p *PortfolioService) Ledger(ctx context.Context, accountID string) (Ledger
 
 type OrdersService struct {
 	client *Client
+	// pollInterval is how often WaitForOrder polls the order's status; zero
+	// means defaultOrderPollInterval.
+	pollInterval time.Duration
 }
 
 // TradableAccountsResponse is the response from /iserver/accounts.
@@ -709,7 +721,7 @@ func New(host string) *Client {
 
 	c.Contracts = &ContractService{c}
 	c.MarketData = &MarketDataService{c}
-	c.Orders = &OrdersService{c}
+	c.Orders = &OrdersService{client: c}
 	c.PerformanceAnalytics = &PerformanceAnalyticsService{c}
 	c.Portfolio = &PortfolioService{c}
 	c.SecurityDefinitions = &SecurityDefinitionService{c}
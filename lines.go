@@ -0,0 +1,315 @@
+package ibclientportal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultMaxLines is the number of concurrent market-data lines IBKR allows a
+// typical account. Accounts with more equity or commissions, or with quote
+// booster packs, get more.
+const DefaultMaxLines = 100
+
+// snapshotReleaseTimeout bounds the Unsubscribe call that releases a
+// snapshot's lines once the snapshot has been read.
+const snapshotReleaseTimeout = 10 * time.Second
+
+// LineManagerOptions configures NewLineManager.
+type LineManagerOptions struct {
+	// MaxLines is the number of market-data lines the account may hold at
+	// once; 0 means DefaultMaxLines.
+	MaxLines int
+	// EvictLRU makes room for a new line, when the cap is reached, by
+	// unsubscribing the least recently used streaming subscriptions and
+	// closing their Subscriptions. Without it, a request over the cap fails
+	// with a *LineBudgetError.
+	EvictLRU bool
+	// OnEvict, if set, is called after each eviction. It must not block.
+	OnEvict func(LineEviction)
+}
+
+// LineEviction reports a streaming subscription a LineManager unsubscribed to
+// make room for another contract.
+type LineEviction struct {
+	// Conid is the evicted contract.
+	Conid int
+	// LastUsed is when the subscription was last subscribed or touched.
+	LastUsed time.Time
+	// For is the contract the line was freed for.
+	For int
+}
+
+// LineBudgetError is returned when a snapshot or subscription needs more
+// market-data lines than the LineManager's cap leaves free.
+type LineBudgetError struct {
+	// Held is the number of lines held when the request was made.
+	Held int
+	// Needed is the number of new lines the request needed.
+	Needed int
+	// Max is the cap.
+	Max int
+}
+
+func (e *LineBudgetError) Error() string {
+	return fmt.Sprintf("ibclientportal: market-data line budget exhausted: %d of %d lines held, %d more needed", e.Held, e.Max, e.Needed)
+}
+
+// LineManager counts the account-wide market-data lines held by a client's
+// snapshots and streaming subscriptions, which draw from one pool, and keeps
+// them under a cap. Attach it with (*Client).SetLineManager; from then on
+// Snapshot, SnapshotUntil, (*Stream).SubscribeMarketData and
+// (*Stream).Subscribe take lines from it and fail, or evict, rather than go
+// over the cap. A line is counted once per contract, however many snapshots,
+// streams and subscriptions share it.
+//
+// Snapshot lines are released, with Unsubscribe, as soon as the snapshot has
+// been read. Streaming lines are held until the conid's last subscription is
+// ended or the stream ends. A LineManager is safe for concurrent use.
+type LineManager struct {
+	max      int
+	evictLRU bool
+	onEvict  func(LineEviction)
+
+	mu    sync.Mutex
+	lines map[int]*line
+}
+
+// line is one contract's market-data line and what holds it.
+type line struct {
+	conid     int
+	snapshots int // snapshots in progress
+	streams   map[*Stream]struct{}
+	lastUsed  time.Time
+}
+
+func (l *line) held() bool {
+	return l.snapshots > 0 || len(l.streams) > 0
+}
+
+// NewLineManager returns a LineManager with the given options.
+func NewLineManager(opts LineManagerOptions) *LineManager {
+	max := opts.MaxLines
+	if max <= 0 {
+		max = DefaultMaxLines
+	}
+	return &LineManager{
+		max:      max,
+		evictLRU: opts.EvictLRU,
+		onEvict:  opts.OnEvict,
+		lines:    make(map[int]*line),
+	}
+}
+
+// SetLineManager makes the client's market-data snapshots, and the
+// subscriptions of streams it dials, take their lines from m. Pass nil to
+// stop counting.
+func (c *Client) SetLineManager(m *LineManager) {
+	c.linesMu.Lock()
+	c.lines = m
+	c.linesMu.Unlock()
+}
+
+func (c *Client) lineManager() *LineManager {
+	c.linesMu.Lock()
+	defer c.linesMu.Unlock()
+	return c.lines
+}
+
+// Held returns the number of lines held.
+func (m *LineManager) Held() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.lines)
+}
+
+// Max returns the cap.
+func (m *LineManager) Max() int {
+	return m.max
+}
+
+// Conids returns the contracts holding lines, least recently used first.
+func (m *LineManager) Conids() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.byLastUsed(nil)
+}
+
+// Touch marks a contract's line as used now, so EvictLRU evicts it later
+// than lines not touched since. Subscribing counts as a use.
+func (m *LineManager) Touch(conid int) {
+	m.mu.Lock()
+	if l, ok := m.lines[conid]; ok {
+		l.lastUsed = time.Now()
+	}
+	m.mu.Unlock()
+}
+
+// byLastUsed returns the conids of the held lines, least recently used first,
+// keeping only those keep accepts. m.mu must be held.
+func (m *LineManager) byLastUsed(keep func(*line) bool) []int {
+	ls := make([]*line, 0, len(m.lines))
+	for _, l := range m.lines {
+		if keep == nil || keep(l) {
+			ls = append(ls, l)
+		}
+	}
+	slices.SortFunc(ls, func(a, b *line) int {
+		if c := a.lastUsed.Compare(b.lastUsed); c != 0 {
+			return c
+		}
+		return a.conid - b.conid
+	})
+	conids := make([]int, len(ls))
+	for i, l := range ls {
+		conids[i] = l.conid
+	}
+	return conids
+}
+
+// eviction is a streaming line taken away from its holders.
+type eviction struct {
+	LineEviction
+	streams []*Stream
+}
+
+// acquire takes a line for each of conids, for a snapshot if stream is nil
+// and otherwise for stream's subscription. If that would go over the cap it
+// evicts least recently used streaming lines, when allowed, or returns a
+// *LineBudgetError and takes nothing.
+func (m *LineManager) acquire(conids []int, stream *Stream) error {
+	now := time.Now()
+	m.mu.Lock()
+	var needed []int
+	for _, conid := range conids {
+		if _, ok := m.lines[conid]; !ok && !slices.Contains(needed, conid) {
+			needed = append(needed, conid)
+		}
+	}
+	var evicted []eviction
+	if over := len(m.lines) + len(needed) - m.max; over > 0 {
+		var victims []int
+		if m.evictLRU {
+			victims = m.byLastUsed(func(l *line) bool {
+				return l.snapshots == 0 && !slices.Contains(conids, l.conid)
+			})
+		}
+		if len(victims) < over {
+			err := &LineBudgetError{Held: len(m.lines), Needed: len(needed), Max: m.max}
+			m.mu.Unlock()
+			return err
+		}
+		for _, conid := range victims[:over] {
+			l := m.lines[conid]
+			ev := eviction{LineEviction: LineEviction{Conid: conid, LastUsed: l.lastUsed, For: needed[0]}}
+			for s := range l.streams {
+				ev.streams = append(ev.streams, s)
+			}
+			evicted = append(evicted, ev)
+			delete(m.lines, conid)
+		}
+	}
+	for _, conid := range conids {
+		l, ok := m.lines[conid]
+		if !ok {
+			l = &line{conid: conid, streams: make(map[*Stream]struct{})}
+			m.lines[conid] = l
+		}
+		if stream == nil {
+			l.snapshots++
+		} else {
+			l.streams[stream] = struct{}{}
+		}
+		l.lastUsed = now
+	}
+	onEvict := m.onEvict
+	m.mu.Unlock()
+
+	for _, ev := range evicted {
+		for _, s := range ev.streams {
+			if err := s.evictMarketData(ev.Conid); err != nil {
+				wsDebugf("evicting conid %d: %v", ev.Conid, err)
+			}
+		}
+		if onEvict != nil {
+			onEvict(ev.LineEviction)
+		}
+	}
+	return nil
+}
+
+// releaseSnapshot gives back the lines a snapshot took, returning the conids
+// whose lines are no longer held by anything and should be unsubscribed.
+func (m *LineManager) releaseSnapshot(conids []int) []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var free []int
+	for _, conid := range conids {
+		l, ok := m.lines[conid]
+		if !ok || l.snapshots == 0 {
+			continue
+		}
+		l.snapshots--
+		if !l.held() {
+			delete(m.lines, conid)
+			free = append(free, conid)
+		}
+	}
+	return free
+}
+
+// releaseStream gives back a stream's line for conid. It is a no-op if the
+// stream does not hold one, for example because it was evicted.
+func (m *LineManager) releaseStream(conid int, s *Stream) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.lines[conid]
+	if !ok {
+		return
+	}
+	delete(l.streams, s)
+	if !l.held() {
+		delete(m.lines, conid)
+	}
+}
+
+// releaseAll gives back every line held by a stream that has ended.
+func (m *LineManager) releaseAll(s *Stream) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for conid, l := range m.lines {
+		delete(l.streams, s)
+		if !l.held() {
+			delete(m.lines, conid)
+		}
+	}
+}
+
+// acquireSnapshotLines takes lines for a snapshot from the client's
+// LineManager, if it has one, and returns the function that gives them back
+// and unsubscribes the ones nothing else holds.
+func (m *MarketDataService) acquireSnapshotLines(ctx context.Context, conids []int) (func(), error) {
+	lines := m.client.lineManager()
+	if lines == nil {
+		return func() {}, nil
+	}
+	if err := lines.acquire(conids, nil); err != nil {
+		return nil, err
+	}
+	return func() {
+		free := lines.releaseSnapshot(conids)
+		if len(free) == 0 {
+			return
+		}
+		// Release even if the caller's context is done: the line is held on
+		// the gateway until someone does. A failure is left to
+		// UnsubscribeAll.
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), snapshotReleaseTimeout)
+		defer cancel()
+		for _, conid := range free {
+			_ = m.Unsubscribe(releaseCtx, conid)
+		}
+	}, nil
+}
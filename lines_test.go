@@ -0,0 +1,164 @@
+package ibclientportal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLineManagerSnapshot(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requests []string
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, r.URL.Path+" "+r.URL.Query().Get("conids")+string(body))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/snapshot") {
+			_, _ = w.Write([]byte(`[{"conid":1,"31":"1.00"},{"conid":2,"31":"2.00"}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	defer server.Close()
+
+	lines := NewLineManager(LineManagerOptions{MaxLines: 2})
+	client.SetLineManager(lines)
+
+	_, err := client.MarketData.Snapshot(testContext(t), []int{1, 2, 3}, nil)
+	var budgetErr *LineBudgetError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected a line budget error, got %v", err)
+	}
+	if budgetErr.Needed != 3 || budgetErr.Max != 2 {
+		t.Errorf("unexpected budget error: %+v", budgetErr)
+	}
+	mu.Lock()
+	if len(requests) != 0 {
+		t.Errorf("expected no request over budget, got %q", requests)
+	}
+	mu.Unlock()
+
+	if _, err := client.MarketData.Snapshot(testContext(t), []int{1, 2}, nil); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if held := lines.Held(); held != 0 {
+		t.Errorf("Held() = %d after the snapshot, want 0", held)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"/v1/api/iserver/marketdata/snapshot 1,2",
+		`/v1/api/iserver/marketdata/unsubscribe {"conid":1}`,
+		`/v1/api/iserver/marketdata/unsubscribe {"conid":2}`,
+	}
+	if !slices.Equal(requests, want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+}
+
+func TestLineManagerEvictsStreams(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan string, 16)
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg := string(data); msg != "tic" {
+				received <- msg
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := New(srv.URL)
+	var evictions []LineEviction
+	lines := NewLineManager(LineManagerOptions{
+		MaxLines: 2,
+		EvictLRU: true,
+		OnEvict:  func(ev LineEviction) { evictions = append(evictions, ev) },
+	})
+	client.SetLineManager(lines)
+	stream, err := client.DialStream(ctx)
+	if err != nil {
+		t.Fatalf("DialStream: %v", err)
+	}
+	defer stream.Close()
+
+	for _, conid := range []int{1, 2} {
+		if err := stream.SubscribeMarketData(conid, FieldLastPrice); err != nil {
+			t.Fatalf("subscribe %d: %v", conid, err)
+		}
+		time.Sleep(time.Millisecond) // distinct last-used times
+	}
+	lines.Touch(1)
+	if err := stream.SubscribeMarketData(3, FieldLastPrice); err != nil {
+		t.Fatalf("subscribe 3: %v", err)
+	}
+	if len(evictions) != 1 || evictions[0].Conid != 2 || evictions[0].For != 3 {
+		t.Errorf("evictions = %+v, want conid 2 evicted for 3", evictions)
+	}
+	if got := lines.Conids(); !slices.Equal(got, []int{1, 3}) {
+		t.Errorf("Conids() = %v, want [1 3]", got)
+	}
+
+	want := []string{
+		`smd+1+{"fields":["31"]}`,
+		`smd+2+{"fields":["31"]}`,
+		"umd+2+{}",
+		`smd+3+{"fields":["31"]}`,
+	}
+	for _, w := range want {
+		select {
+		case got := <-received:
+			if got != w {
+				t.Errorf("gateway received %q, want %q", got, w)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %q", w)
+		}
+	}
+
+	if err := stream.UnsubscribeMarketData(1); err != nil {
+		t.Fatalf("unsubscribe: %v", err)
+	}
+	if held := lines.Held(); held != 1 {
+		t.Errorf("Held() = %d after unsubscribing, want 1", held)
+	}
+	stream.Close()
+	for range stream.Updates() {
+	}
+	if held := lines.Held(); held != 0 {
+		t.Errorf("Held() = %d after the stream ended, want 0", held)
+	}
+}
@@ -5,20 +5,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Snapshot is a single contract's market data as returned by
 // /iserver/marketdata/snapshot. Like a streaming MarketDataUpdate it carries
 // raw field values keyed by IB's numeric field codes (see the Field*
-// constants); read them with String or Float.
+// constants); read them with String, Float or Price, or decode them all at once
+// with Quote.
 type Snapshot struct {
 	// Conid is the contract identifier this row is for.
 	Conid int
 	// Fields maps IB numeric field codes to their raw JSON values. A field the
 	// gateway has not resolved yet is absent rather than zero.
 	Fields map[string]json.RawMessage
+	// Availability is the decoded market-data availability field (6509). Its
+	// Status is AvailabilityUnknown unless that field was requested and the
+	// gateway reported it; check IsRealtime before pricing off the snapshot.
+	Availability Availability
 }
 
 // String returns the value of the given field code as a string, stripping
@@ -29,8 +36,9 @@ func (s Snapshot) String(field string) (string, bool) {
 }
 
 // Float returns the value of the given field code as a float64, handling both
-// JSON-number and JSON-string encodings. The second return value reports
-// whether the field was present and parseable as a number.
+// JSON-number and JSON-string encodings. Price markers are stripped; use Price
+// to see them. The second return value reports whether the field was present
+// and parseable as a number.
 func (s Snapshot) Float(field string) (float64, bool) {
 	return fieldFloat(s.Fields, field)
 }
@@ -56,7 +64,8 @@ func fieldFloat(fields map[string]json.RawMessage, field string) (float64, bool)
 		return 0, false
 	}
 	// IB sometimes prefixes prices with markers such as 'C' (previous close)
-	// or 'H'/'L'; strip any leading non-numeric marker characters.
+	// or 'H'/'L'; strip any leading non-numeric marker characters. Price
+	// keeps them for callers that need to know.
 	s = strings.TrimLeft(s, "CHBAlch ")
 	f, err := strconv.ParseFloat(s, 64)
 	if err != nil {
@@ -75,15 +84,29 @@ func fieldFloat(fields map[string]json.RawMessage, field string) (float64, bool)
 //     returns nothing.
 //   - The first call for a contract typically returns little more than the
 //     conid while the backend subscribes to the feed. Poll until the field you
-//     want appears rather than treating one empty response as "no data".
+//     want appears rather than treating one empty response as "no data";
+//     SnapshotUntil does that polling.
 //
 // A snapshot consumes one of the account's concurrent market-data lines, from
 // the same pool as streaming subscriptions, until it is released with
-// Unsubscribe or UnsubscribeAll.
+// Unsubscribe or UnsubscribeAll. If the client has a LineManager, the lines
+// are taken from it and released as soon as the snapshot has been read, and a
+// snapshot that would go over its cap returns a *LineBudgetError.
 func (m *MarketDataService) Snapshot(ctx context.Context, conids []int, fields []string) ([]Snapshot, error) {
 	if len(conids) == 0 {
 		return nil, fmt.Errorf("ibclientportal: Snapshot: no conids given")
 	}
+	release, err := m.acquireSnapshotLines(ctx, conids)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return m.snapshot(ctx, conids, fields)
+}
+
+// snapshot makes one request to the snapshot endpoint, without touching the
+// client's LineManager.
+func (m *MarketDataService) snapshot(ctx context.Context, conids []int, fields []string) ([]Snapshot, error) {
 	if len(fields) == 0 {
 		fields = []string{
 			FieldLastPrice, FieldBidPrice, FieldAskPrice,
@@ -102,6 +125,12 @@ func (m *MarketDataService) Snapshot(ctx context.Context, conids []int, fields [
 	if err := m.client.ListResource(ctx, "/iserver/marketdata/snapshot", query, &rows); err != nil {
 		return nil, err
 	}
+	return parseSnapshotRows(rows), nil
+}
+
+// parseSnapshotRows converts the snapshot endpoint's rows into Snapshot values,
+// reading the conid and dropping the bookkeeping keys.
+func parseSnapshotRows(rows []map[string]json.RawMessage) []Snapshot {
 	snapshots := make([]Snapshot, 0, len(rows))
 	for _, row := range rows {
 		conid := 0
@@ -122,9 +151,297 @@ func (m *MarketDataService) Snapshot(ctx context.Context, conids []int, fields [
 			}
 			fieldsCopy[k] = v
 		}
-		snapshots = append(snapshots, Snapshot{Conid: conid, Fields: fieldsCopy})
+		snapshots = append(snapshots, Snapshot{
+			Conid:        conid,
+			Fields:       fieldsCopy,
+			Availability: ParseAvailability(fieldText(fieldsCopy, FieldAvailability)),
+		})
+	}
+	return snapshots
+}
+
+// Defaults for the zero-valued fields of a SnapshotPolicy.
+const (
+	defaultSnapshotAttempts    = 10
+	defaultSnapshotInterval    = 500 * time.Millisecond
+	defaultSnapshotMaxInterval = 5 * time.Second
+	defaultSnapshotChunkSize   = 50
+)
+
+// SnapshotPolicy controls how SnapshotUntil polls the snapshot endpoint. The
+// zero value is usable.
+type SnapshotPolicy struct {
+	// Required lists the field codes a contract must report before it counts
+	// as resolved. If empty, a contract resolves as soon as it reports any of
+	// the last, bid or ask price.
+	Required []string
+	// MaxAttempts is the most times any one contract is polled. Defaults to 10.
+	MaxAttempts int
+	// Interval is the delay before the first re-poll. It doubles after every
+	// round that leaves a contract unresolved, up to MaxInterval. Defaults to
+	// 500ms.
+	Interval time.Duration
+	// MaxInterval caps the backoff between polls. Defaults to 5s.
+	MaxInterval time.Duration
+	// ChunkSize is the most conids sent in one request; larger lists are split
+	// across several. Defaults to 50.
+	ChunkSize int
+	// RequireRealtime treats a contract as resolved only once its market data
+	// is reported as realtime. A contract reported delayed, frozen or not
+	// subscribed stops being polled and is returned as unresolved, so a
+	// delayed quote never appears among the resolved snapshots.
+	RequireRealtime bool
+	// OnAvailability, if set, is called each time a contract first reports
+	// its market-data availability or reports a different one. It is called
+	// from SnapshotUntil's goroutine, before SnapshotUntil returns.
+	OnAvailability func(AvailabilityEvent)
+}
+
+// SnapshotResult is the outcome of SnapshotUntil.
+type SnapshotResult struct {
+	// Snapshots holds one entry per requested conid that resolved, in the
+	// order the conids were given. Fields from every poll are merged, so a
+	// field reported once and omitted later is still present.
+	Snapshots []Snapshot
+	// Unresolved lists the conids that were still missing a required field
+	// when the attempts ran out, in the order the conids were given.
+	Unresolved []UnresolvedSnapshot
+}
+
+// UnresolvedSnapshot describes a contract SnapshotUntil gave up on.
+type UnresolvedSnapshot struct {
+	// Conid is the contract that never resolved.
+	Conid int
+	// Missing lists the required fields it never reported. When the policy
+	// has no Required fields it holds the last, bid and ask price codes.
+	Missing []string
+	// Availability is the market-data availability from the last poll that
+	// reported one.
+	Availability Availability
+	// Reason is a human-readable explanation, derived from Availability when
+	// the gateway reported it.
+	Reason string
+	// Last is everything the contract did report, merged across polls.
+	Last Snapshot
+}
+
+// priceFields are the fields any of which resolves a contract under a
+// SnapshotPolicy with no Required fields.
+var priceFields = []string{FieldLastPrice, FieldBidPrice, FieldAskPrice}
+
+// SnapshotUntil polls the snapshot endpoint until every conid reports the
+// fields the policy requires, or until the policy's attempts run out. It is
+// the polling loop the Snapshot documentation asks callers to write: only the
+// conids still missing a field are re-polled, the delay between rounds backs
+// off, and long conid lists are split into chunks so no single request grows
+// past what the gateway handles comfortably.
+//
+// The market-data availability field (6509) is always requested along with
+// fields and policy.Required, so a contract that never resolves can be
+// reported with a reason, such as the account not being subscribed to its
+// market data, and so policy.RequireRealtime can keep delayed data out of the
+// result.
+//
+// A request that fails ends the poll: the error is returned along with the
+// contracts resolved so far. Every contract polled holds a market-data line
+// until it is released with Unsubscribe or UnsubscribeAll, as with Snapshot.
+// If the client has a LineManager, every contract's line is taken from it
+// before the first poll and released after the last, so that later polls are
+// not first calls again.
+func (m *MarketDataService) SnapshotUntil(ctx context.Context, conids []int, fields []string, policy SnapshotPolicy) (SnapshotResult, error) {
+	if len(conids) == 0 {
+		return SnapshotResult{}, fmt.Errorf("ibclientportal: SnapshotUntil: no conids given")
+	}
+	attempts := policy.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultSnapshotAttempts
+	}
+	interval := policy.Interval
+	if interval <= 0 {
+		interval = defaultSnapshotInterval
+	}
+	maxInterval := policy.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = defaultSnapshotMaxInterval
+	}
+	chunkSize := policy.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultSnapshotChunkSize
+	}
+	if len(fields) == 0 {
+		fields = []string{
+			FieldLastPrice, FieldBidPrice, FieldAskPrice,
+			FieldBidSize, FieldAskSize, FieldVolume,
+		}
+	}
+	request := appendMissing(nil, fields...)
+	request = appendMissing(request, policy.Required...)
+	request = appendMissing(request, FieldAvailability)
+
+	// Deduplicate while keeping the caller's order for the result.
+	var order []int
+	merged := make(map[int]map[string]json.RawMessage)
+	for _, conid := range conids {
+		if _, ok := merged[conid]; ok {
+			continue
+		}
+		merged[conid] = make(map[string]json.RawMessage)
+		order = append(order, conid)
+	}
+
+	release, err := m.acquireSnapshotLines(ctx, order)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	defer release()
+
+	availability := make(map[int]Availability)
+	settled := make(map[int]bool) // gave up early: known not to be realtime
+	pending := order
+	for attempt := 1; attempt <= attempts && len(pending) > 0; attempt++ {
+		if attempt > 1 {
+			if !sleepContext(ctx, interval) {
+				err = ctx.Err()
+				break
+			}
+			interval = min(interval*2, maxInterval)
+		}
+		for start := 0; start < len(pending) && err == nil; start += chunkSize {
+			chunk := pending[start:min(start+chunkSize, len(pending))]
+			var snapshots []Snapshot
+			snapshots, err = m.snapshot(ctx, chunk, request)
+			for _, snap := range snapshots {
+				fields, ok := merged[snap.Conid]
+				if !ok {
+					continue // a conid we did not ask about
+				}
+				for k, v := range snap.Fields {
+					fields[k] = v
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+		var still []int
+		for _, conid := range pending {
+			avail := ParseAvailability(fieldText(merged[conid], FieldAvailability))
+			if prev := availability[conid]; avail.Known() && avail.Raw != prev.Raw {
+				availability[conid] = avail
+				if policy.OnAvailability != nil {
+					policy.OnAvailability(AvailabilityEvent{Conid: conid, Availability: avail, Previous: prev})
+				}
+			}
+			if policy.RequireRealtime && notRealtime(avail) {
+				// Polling again will not turn delayed data into live data.
+				settled[conid] = true
+				continue
+			}
+			if !snapshotResolved(merged[conid], avail, policy) {
+				still = append(still, conid)
+			}
+		}
+		pending = still
+	}
+
+	var result SnapshotResult
+	for _, conid := range order {
+		avail := availability[conid]
+		snap := Snapshot{Conid: conid, Fields: merged[conid], Availability: avail}
+		if snapshotResolved(snap.Fields, avail, policy) {
+			result.Snapshots = append(result.Snapshots, snap)
+			continue
+		}
+		if err != nil && !settled[conid] {
+			// The poll was cut short; the contract was never given its
+			// attempts, so it is neither resolved nor given up on.
+			continue
+		}
+		missing := missingFields(snap.Fields, policy.Required)
+		result.Unresolved = append(result.Unresolved, UnresolvedSnapshot{
+			Conid:        conid,
+			Missing:      missing,
+			Availability: avail,
+			Reason:       unresolvedReason(avail, len(missing) > 0, policy.RequireRealtime, attempts),
+			Last:         snap,
+		})
+	}
+	return result, err
+}
+
+// snapshotResolved reports whether a contract's merged fields satisfy the
+// policy.
+func snapshotResolved(fields map[string]json.RawMessage, avail Availability, policy SnapshotPolicy) bool {
+	if len(missingFields(fields, policy.Required)) > 0 {
+		return false
+	}
+	return !policy.RequireRealtime || avail.IsRealtime()
+}
+
+// notRealtime reports whether avail definitely describes data that is not
+// live. Incomplete data may still become live, so it does not count.
+func notRealtime(avail Availability) bool {
+	return avail.Known() && !avail.IsRealtime() && avail.Status != AvailabilityIncomplete
+}
+
+// missingFields returns the required fields absent from fields, treating an
+// empty string as absent. With no required fields it returns nil if any price
+// field is present and a copy of priceFields otherwise.
+func missingFields(fields map[string]json.RawMessage, required []string) []string {
+	if len(required) == 0 {
+		for _, code := range priceFields {
+			if v, ok := fieldString(fields, code); ok && v != "" {
+				return nil
+			}
+		}
+		return slices.Clone(priceFields)
+	}
+	var missing []string
+	for _, code := range required {
+		if v, ok := fieldString(fields, code); !ok || v == "" {
+			missing = append(missing, code)
+		}
+	}
+	return missing
+}
+
+// unresolvedReason explains why a contract never resolved, using the
+// market-data availability when the gateway reported it.
+func unresolvedReason(avail Availability, missing, requireRealtime bool, attempts int) string {
+	switch {
+	case avail.Status == AvailabilityNotSubscribed:
+		return fmt.Sprintf("market data not subscribed (availability %q)", avail.Raw)
+	case requireRealtime && notRealtime(avail):
+		return fmt.Sprintf("market data is %s, not realtime (availability %q)", avail.Status, avail.Raw)
+	case !avail.Known() && missing:
+		return fmt.Sprintf("no data after %d attempts", attempts)
+	case !avail.Known():
+		return fmt.Sprintf("availability not reported after %d attempts", attempts)
+	default:
+		return fmt.Sprintf("required fields missing after %d attempts (availability %q)", attempts, avail.Raw)
+	}
+}
+
+// appendMissing appends each value not already in list.
+func appendMissing(list []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// sleepContext waits for d, returning false if ctx was cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
 	}
-	return snapshots, nil
 }
 
 // Unsubscribe releases the market-data line held for one contract, whether it
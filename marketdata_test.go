@@ -3,7 +3,11 @@ package ibclientportal
 import (
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestSnapshot(t *testing.T) {
@@ -122,3 +126,175 @@ func TestMarketDataUnsubscribe(t *testing.T) {
 		t.Errorf("unexpected unsubscribeall request: %s %s", all.method, all.path)
 	}
 }
+
+func TestSnapshotUntil(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	calls := 0
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		queries = append(queries, r.URL.Query().Get("conids"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch n {
+		case 1:
+			// The first poll resolves nothing: the backend is still
+			// subscribing to both feeds.
+			_, _ = w.Write([]byte(`[{"conid":265598},{"conid":8314}]`))
+		default:
+			// Later polls resolve AAPL, while 8314 reports that the account
+			// has no market-data subscription for it.
+			_, _ = w.Write([]byte(`[{"conid":265598,"84":"123.40","86":"123.50"},{"conid":8314,"6509":"N"}]`))
+		}
+	})
+	defer server.Close()
+
+	result, err := client.MarketData.SnapshotUntil(testContext(t), []int{265598, 8314, 265598}, nil, SnapshotPolicy{
+		Required:    []string{FieldBidPrice, FieldAskPrice},
+		MaxAttempts: 3,
+		Interval:    time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("SnapshotUntil: %v", err)
+	}
+	if len(result.Snapshots) != 1 || result.Snapshots[0].Conid != 265598 {
+		t.Fatalf("expected AAPL to resolve, got %#v", result.Snapshots)
+	}
+	if bid, ok := result.Snapshots[0].Float(FieldBidPrice); !ok || bid != 123.40 {
+		t.Errorf("unexpected bid %v (ok=%t)", bid, ok)
+	}
+	if len(result.Unresolved) != 1 {
+		t.Fatalf("expected one unresolved conid, got %#v", result.Unresolved)
+	}
+	u := result.Unresolved[0]
+	if u.Conid != 8314 || u.Availability.Status != AvailabilityNotSubscribed {
+		t.Errorf("unexpected unresolved entry %#v", u)
+	}
+	if !slices.Equal(u.Missing, []string{FieldBidPrice, FieldAskPrice}) {
+		t.Errorf("unexpected missing fields %v", u.Missing)
+	}
+	if !strings.Contains(u.Reason, "not subscribed") {
+		t.Errorf("expected the reason to mention the missing subscription, got %q", u.Reason)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The duplicate conid is polled once, and once AAPL resolved only the
+	// conid still missing fields is polled again.
+	want := []string{"265598,8314", "265598,8314", "8314"}
+	if !slices.Equal(queries, want) {
+		t.Errorf("polled conids %q, want %q", queries, want)
+	}
+}
+
+func TestSnapshotUntilChunks(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		conids := r.URL.Query().Get("conids")
+		mu.Lock()
+		queries = append(queries, conids)
+		mu.Unlock()
+		if fields := r.URL.Query().Get("fields"); fields != "31,6509" {
+			t.Errorf("unexpected fields %q", fields)
+		}
+		var rows []string
+		for _, id := range strings.Split(conids, ",") {
+			rows = append(rows, `{"conid":`+id+`,"31":"1.00"}`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("[" + strings.Join(rows, ",") + "]"))
+	})
+	defer server.Close()
+
+	result, err := client.MarketData.SnapshotUntil(testContext(t), []int{1, 2, 3, 4, 5},
+		[]string{FieldLastPrice}, SnapshotPolicy{ChunkSize: 2})
+	if err != nil {
+		t.Fatalf("SnapshotUntil: %v", err)
+	}
+	if len(result.Snapshots) != 5 || len(result.Unresolved) != 0 {
+		t.Fatalf("expected every conid to resolve, got %#v", result)
+	}
+	for i, snap := range result.Snapshots {
+		if snap.Conid != i+1 {
+			t.Errorf("snapshot %d has conid %d, want the caller's order", i, snap.Conid)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"1,2", "3,4", "5"}; !slices.Equal(queries, want) {
+		t.Errorf("polled conids %q, want %q", queries, want)
+	}
+}
+
+// With RequireRealtime, a contract reporting delayed data must not be returned
+// as resolved even though its prices are present, and must stop being polled.
+func TestSnapshotUntilRequireRealtime(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.Query().Get("conids"))
+		n := len(queries)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			_, _ = w.Write([]byte(`[{"conid":265598,"31":"190.00","6509":"RpB"},{"conid":8314,"31":"12.00","6509":"DPB"},{"conid":756733}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"conid":756733,"31":"500.00","6509":"RpB"}]`))
+	})
+	defer server.Close()
+
+	var events []AvailabilityEvent
+	result, err := client.MarketData.SnapshotUntil(testContext(t), []int{265598, 8314, 756733}, nil, SnapshotPolicy{
+		RequireRealtime: true,
+		Interval:        time.Millisecond,
+		OnAvailability:  func(ev AvailabilityEvent) { events = append(events, ev) },
+	})
+	if err != nil {
+		t.Fatalf("SnapshotUntil: %v", err)
+	}
+	var resolved []int
+	for _, snap := range result.Snapshots {
+		resolved = append(resolved, snap.Conid)
+		if !snap.Availability.IsRealtime() {
+			t.Errorf("conid %d resolved with availability %v", snap.Conid, snap.Availability)
+		}
+	}
+	if !slices.Equal(resolved, []int{265598, 756733}) {
+		t.Errorf("resolved %v, want the two realtime conids", resolved)
+	}
+	if len(result.Unresolved) != 1 || result.Unresolved[0].Conid != 8314 {
+		t.Fatalf("expected the delayed conid to be unresolved, got %#v", result.Unresolved)
+	}
+	if u := result.Unresolved[0]; u.Availability.Status != AvailabilityDelayed || !strings.Contains(u.Reason, "delayed") {
+		t.Errorf("unexpected unresolved entry %#v", u)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected an availability event per conid, got %#v", events)
+	}
+	if events[1].Conid != 8314 || events[1].Availability.Status != AvailabilityDelayed || events[1].Previous.Known() {
+		t.Errorf("unexpected event for the delayed conid: %#v", events[1])
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"265598,8314,756733", "756733"}; !slices.Equal(queries, want) {
+		t.Errorf("polled conids %q, want %q", queries, want)
+	}
+}
+
+func TestMissingFieldsCopies(t *testing.T) {
+	missing := missingFields(nil, nil)
+	if !slices.Equal(missing, priceFields) {
+		t.Fatalf("missingFields = %v, want %v", missing, priceFields)
+	}
+	missing[0] = "changed"
+	if priceFields[0] != FieldLastPrice {
+		priceFields[0] = FieldLastPrice
+		t.Error("changing the result changed priceFields")
+	}
+}
@@ -28,7 +28,12 @@ type OrderRequest struct {
 	// day, that makes a retried submission idempotent on IB's side.
 	COID string `json:"cOID,omitempty"`
 	// ParentID ties a child order to a parent order's COID for bracket orders.
+	// NewBracket sets it.
 	ParentID string `json:"parentId,omitempty"`
+	// IsSingleGroup marks every order in the request as one OCA
+	// (one-cancels-all) group: when one fills, IB cancels the rest. Set it on
+	// each order in the group, or build the group with NewOCAGroup.
+	IsSingleGroup bool `json:"isSingleGroup,omitempty"`
 	// OrderType is "LMT", "MKT", "STP", "STOP_LIMIT", "MIDPRICE", "TRAIL" or
 	// "TRAILLMT".
 	OrderType string `json:"orderType,omitempty"`
@@ -36,9 +41,10 @@ type OrderRequest struct {
 	ListingExchange string `json:"listingExchange,omitempty"`
 	// OutsideRTH allows the order to trade outside regular trading hours.
 	OutsideRTH bool `json:"outsideRTH,omitempty"`
-	// Price is the limit price for LMT and STOP_LIMIT orders.
+	// Price is the limit price for LMT and STOP_LIMIT orders, and the stop
+	// price for STP orders.
 	Price float64 `json:"price,omitempty"`
-	// AuxPrice is the stop price for STP and STOP_LIMIT orders.
+	// AuxPrice is the stop price for STOP_LIMIT orders.
 	AuxPrice float64 `json:"auxPrice,omitempty"`
 	// Side is "BUY" or "SELL".
 	Side string `json:"side,omitempty"`
@@ -110,7 +116,10 @@ type OrderPlacement struct {
 	// MessageIDs holds IB's identifiers for the question, e.g. "o163". They
 	// are stable across orders and can be used to recognise a known question.
 	MessageIDs []string `json:"messageIds,omitempty"`
-	// IsSuppressed reports whether this message class was suppressed.
+	// IsSuppressed reports whether this message class was suppressed. A
+	// question arriving unsuppressed with an ID passed to SuppressQuestions
+	// tells the client the gateway has forgotten its suppressions, and they are
+	// sent again before the next order.
 	IsSuppressed bool `json:"isSuppressed,omitempty"`
 
 	// OrderID is IB's identifier for a successfully placed order.
@@ -167,6 +176,7 @@ func (o *OrdersService) PlaceOrders(ctx context.Context, accountID string, order
 	if len(orders) == 0 {
 		return nil, fmt.Errorf("ibclientportal: PlaceOrders: no orders given")
 	}
+	o.client.reapplySuppression(ctx)
 	path := "/iserver/account/" + url.PathEscape(accountID) + "/orders"
 	body := struct {
 		Orders []OrderRequest `json:"orders"`
@@ -183,6 +193,7 @@ func (o *OrdersService) ModifyOrder(ctx context.Context, accountID, orderID stri
 	if orderID == "" {
 		return nil, fmt.Errorf("ibclientportal: ModifyOrder: no order ID given")
 	}
+	o.client.reapplySuppression(ctx)
 	path := "/iserver/account/" + url.PathEscape(accountID) + "/order/" + url.PathEscape(orderID)
 	return o.placementRequest(ctx, path, order)
 }
@@ -222,6 +233,7 @@ func (o *OrdersService) placementRequest(ctx context.Context, path string, body
 		if err := json.Unmarshal(raw, &placements); err != nil {
 			return nil, fmt.Errorf("ibclientportal: %s: parsing response %s: %w", path, raw, err)
 		}
+		o.client.noteQuestions(placements)
 		for _, p := range placements {
 			if p.Error != "" {
 				return placements, fmt.Errorf("ibclientportal: %s: %s", path, p.Error)
@@ -233,6 +245,7 @@ func (o *OrdersService) placementRequest(ctx context.Context, path string, body
 	if err := json.Unmarshal(raw, &single); err != nil {
 		return nil, fmt.Errorf("ibclientportal: %s: parsing response %s: %w", path, raw, err)
 	}
+	o.client.noteQuestions([]OrderPlacement{single})
 	if single.Error != "" {
 		return []OrderPlacement{single}, fmt.Errorf("ibclientportal: %s: %s", path, single.Error)
 	}
@@ -249,8 +262,8 @@ type CancelOrderResponse struct {
 }
 
 // CancelOrder cancels a live order. IB acknowledges the cancel request
-// synchronously; the order reaches a cancelled state asynchronously, so poll
-// ListOrders to confirm it actually went away.
+// synchronously; the order reaches a cancelled state asynchronously, so use
+// WaitForOrder with UntilCancelled to confirm it actually went away.
 func (o *OrdersService) CancelOrder(ctx context.Context, accountID, orderID string) (CancelOrderResponse, error) {
 	var val CancelOrderResponse
 	if accountID == "" {
@@ -268,14 +281,16 @@ func (o *OrdersService) CancelOrder(ctx context.Context, accountID, orderID stri
 }
 
 // WhatIfAmount is a monetary breakdown in a WhatIfResponse. IB returns these
-// as preformatted strings, currency symbols and all, rather than numbers.
+// as preformatted strings, currency symbols and all, rather than numbers; use
+// Parse to read them.
 type WhatIfAmount struct {
 	Amount     string `json:"amount"`
 	Commission string `json:"commission"`
 	Total      string `json:"total"`
 }
 
-// WhatIfChange is a before/after/delta triple in a WhatIfResponse.
+// WhatIfChange is a before/after/delta triple in a WhatIfResponse, formatted
+// like WhatIfAmount.
 type WhatIfChange struct {
 	Current string `json:"current"`
 	Change  string `json:"change"`
@@ -284,6 +299,7 @@ type WhatIfChange struct {
 
 // WhatIfResponse previews the effect of an order: its cost including estimated
 // commission, and what it does to the account's equity and margin.
+// MarginImpact summarises the margin figures as numbers.
 type WhatIfResponse struct {
 	Amount      WhatIfAmount `json:"amount"`
 	Equity      WhatIfChange `json:"equity"`
@@ -321,3 +337,22 @@ func (o *OrdersService) WhatIf(ctx context.Context, accountID string, orders []O
 	}
 	return val, nil
 }
+
+// OrderBackend is the order lifecycle: placing orders and answering the
+// questions they raise, modifying and cancelling them, and following them to
+// a fill. *OrdersService implements it against the gateway and *PaperBroker
+// in-process, so that code written against an OrderBackend can be tried out
+// on paper and then trade for real by swapping one for the other.
+type OrderBackend interface {
+	PlaceOrders(ctx context.Context, accountID string, orders []OrderRequest) ([]OrderPlacement, error)
+	ConfirmOrder(ctx context.Context, replyID string, confirmed bool) ([]OrderPlacement, error)
+	PlaceAndConfirm(ctx context.Context, accountID string, orders []OrderRequest, policy ConfirmPolicy) (ConfirmResult, error)
+	ModifyOrder(ctx context.Context, accountID, orderID string, order OrderRequest) ([]OrderPlacement, error)
+	CancelOrder(ctx context.Context, accountID, orderID string) (CancelOrderResponse, error)
+	OrderStatus(ctx context.Context, orderID string) (OrderStatus, error)
+	WaitForOrder(ctx context.Context, orderID string, until WaitUntil) (OrderStatus, error)
+	ListOrders(ctx context.Context, query url.Values) (OrdersResponse, error)
+	ListTrades(ctx context.Context, query url.Values) ([]Trade, error)
+}
+
+var _ OrderBackend = (*OrdersService)(nil)
@@ -0,0 +1,148 @@
+package ibclientportal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NewBracket returns a bracket order ready for PlaceOrders: the entry order
+// followed by a take-profit limit order at takeProfit and a stop-loss stop
+// order at stopLoss.
+//
+// The exits are built from the entry: they trade the same contract and the
+// same quantity on the opposite side, keep its account, routing and time in
+// force, and carry the entry's COID as their ParentID, so IB holds them until
+// the entry fills and cancels one when the other fills. Their own COIDs are the
+// entry's with "-tp" and "-sl" appended. An entry whose time in force only
+// covers its own execution (IOC, FOK or OPG) would leave exits that expire as
+// soon as they start working, so their time in force is GTC instead.
+//
+// The entry must have a COID, a side, a quantity (not a cash quantity) and a
+// conid or conidex. The prices must be on the right sides: for a BUY entry the
+// stop-loss is below the take-profit, and below and above a limit entry price
+// respectively; for a SELL entry the reverse.
+func NewBracket(entry OrderRequest, takeProfit, stopLoss float64) ([]OrderRequest, error) {
+	if err := checkOrderRequest(entry); err != nil {
+		return nil, fmt.Errorf("ibclientportal: NewBracket: entry %w", err)
+	}
+	if entry.COID == "" {
+		return nil, errors.New("ibclientportal: NewBracket: entry has no COID for the exits to refer to")
+	}
+	if entry.ParentID != "" || entry.IsSingleGroup {
+		return nil, errors.New("ibclientportal: NewBracket: entry is already part of a bracket or OCA group")
+	}
+	if takeProfit <= 0 || stopLoss <= 0 {
+		return nil, fmt.Errorf("ibclientportal: NewBracket: take-profit %v and stop-loss %v must both be positive", takeProfit, stopLoss)
+	}
+	limit := 0.0
+	if entry.OrderType == "LMT" || entry.OrderType == "STOP_LIMIT" {
+		limit = entry.Price
+	}
+	// sign is +1 when profit is made as the price rises.
+	sign := 1.0
+	if entry.Side == "SELL" {
+		sign = -1
+	}
+	if sign*(takeProfit-stopLoss) <= 0 {
+		return nil, fmt.Errorf("ibclientportal: NewBracket: for a %s entry the stop-loss (%v) must be %s the take-profit (%v)",
+			entry.Side, stopLoss, direction(sign), takeProfit)
+	}
+	if limit > 0 && (sign*(limit-stopLoss) <= 0 || sign*(takeProfit-limit) <= 0) {
+		return nil, fmt.Errorf("ibclientportal: NewBracket: for a %s entry at %v the stop-loss (%v) and take-profit (%v) must be on either side of it",
+			entry.Side, limit, stopLoss, takeProfit)
+	}
+
+	tif := entry.TIF
+	switch tif {
+	case "IOC", "FOK", "OPG":
+		tif = "GTC"
+	}
+	exit := OrderRequest{
+		AcctID:          entry.AcctID,
+		Conid:           entry.Conid,
+		ConidEx:         entry.ConidEx,
+		SecType:         entry.SecType,
+		ParentID:        entry.COID,
+		ListingExchange: entry.ListingExchange,
+		OutsideRTH:      entry.OutsideRTH,
+		Side:            oppositeSide(entry.Side),
+		Ticker:          entry.Ticker,
+		TIF:             tif,
+		Quantity:        entry.Quantity,
+		Referrer:        entry.Referrer,
+	}
+	tp := exit
+	tp.COID = entry.COID + "-tp"
+	tp.OrderType = "LMT"
+	tp.Price = takeProfit
+	sl := exit
+	sl.COID = entry.COID + "-sl"
+	sl.OrderType = "STP"
+	sl.Price = stopLoss
+	return []OrderRequest{entry, tp, sl}, nil
+}
+
+// NewOCAGroup returns orders as a one-cancels-all group ready for PlaceOrders:
+// when any of them fills, IB cancels the others. It sets IsSingleGroup on a
+// copy of each order; the caller's slice is not modified.
+//
+// There must be at least two orders, each with a side, a quantity and a conid
+// or conidex, and none may be part of a bracket. COIDs, where set, must be
+// distinct.
+func NewOCAGroup(orders ...OrderRequest) ([]OrderRequest, error) {
+	if len(orders) < 2 {
+		return nil, fmt.Errorf("ibclientportal: NewOCAGroup: an OCA group needs at least 2 orders, got %d", len(orders))
+	}
+	group := make([]OrderRequest, len(orders))
+	coids := make(map[string]bool, len(orders))
+	for i, o := range orders {
+		if err := checkOrderRequest(o); err != nil {
+			return nil, fmt.Errorf("ibclientportal: NewOCAGroup: order %d %w", i, err)
+		}
+		if o.ParentID != "" {
+			return nil, fmt.Errorf("ibclientportal: NewOCAGroup: order %d is a bracket child (ParentID %q)", i, o.ParentID)
+		}
+		if o.COID != "" {
+			if coids[o.COID] {
+				return nil, fmt.Errorf("ibclientportal: NewOCAGroup: COID %q is used more than once", o.COID)
+			}
+			coids[o.COID] = true
+		}
+		o.IsSingleGroup = true
+		group[i] = o
+	}
+	return group, nil
+}
+
+// checkOrderRequest reports the first missing or inconsistent field in o that
+// a grouped order depends on. The error reads as a predicate of the order, for
+// the caller to prefix.
+func checkOrderRequest(o OrderRequest) error {
+	switch {
+	case o.Conid == 0 && o.ConidEx == "":
+		return errors.New("has no conid or conidex")
+	case o.Side != "BUY" && o.Side != "SELL":
+		return fmt.Errorf("has side %q, want BUY or SELL", o.Side)
+	case o.CashQty != 0:
+		return errors.New("has a cash quantity; grouped orders need a share quantity")
+	case o.Quantity <= 0:
+		return fmt.Errorf("has quantity %v, want a positive number", o.Quantity)
+	case o.OrderType == "":
+		return errors.New("has no order type")
+	}
+	return nil
+}
+
+func oppositeSide(side string) string {
+	if side == "BUY" {
+		return "SELL"
+	}
+	return "BUY"
+}
+
+func direction(sign float64) string {
+	if sign > 0 {
+		return "below"
+	}
+	return "above"
+}
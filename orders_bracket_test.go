@@ -0,0 +1,112 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewBracket(t *testing.T) {
+	entry := OrderRequest{
+		AcctID: "U1234567", Conid: 265598, COID: "aapl-1", OrderType: "LMT",
+		Side: "BUY", TIF: "GTC", Quantity: 10, Price: 190,
+	}
+	orders, err := NewBracket(entry, 200, 185)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 3 {
+		t.Fatalf("got %d orders, want 3", len(orders))
+	}
+	if orders[0] != entry {
+		t.Errorf("entry changed: %+v", orders[0])
+	}
+	tp, sl := orders[1], orders[2]
+	if tp.COID != "aapl-1-tp" || tp.ParentID != "aapl-1" || tp.OrderType != "LMT" || tp.Price != 200 {
+		t.Errorf("unexpected take-profit %+v", tp)
+	}
+	if sl.COID != "aapl-1-sl" || sl.ParentID != "aapl-1" || sl.OrderType != "STP" || sl.Price != 185 {
+		t.Errorf("unexpected stop-loss %+v", sl)
+	}
+	for _, o := range orders[1:] {
+		if o.Side != "SELL" || o.Quantity != 10 || o.Conid != 265598 || o.TIF != "GTC" || o.AcctID != "U1234567" {
+			t.Errorf("exit does not mirror the entry: %+v", o)
+		}
+	}
+	data, _ := json.Marshal(sl)
+	if want := `{"acctId":"U1234567","conid":265598,"cOID":"aapl-1-sl","parentId":"aapl-1","orderType":"STP","price":185,"side":"SELL","tif":"GTC","quantity":10}`; string(data) != want {
+		t.Errorf("stop-loss JSON:\n got %s\nwant %s", data, want)
+	}
+
+	short := OrderRequest{Conid: 265598, COID: "s", OrderType: "MKT", Side: "SELL", TIF: "DAY", Quantity: 5}
+	if _, err := NewBracket(short, 180, 195); err != nil {
+		t.Errorf("short bracket: %v", err)
+	}
+	// Exits of an immediate-or-cancel entry must outlive it.
+	ioc := OrderRequest{Conid: 265598, COID: "i", OrderType: "MKT", Side: "BUY", TIF: "IOC", Quantity: 5}
+	orders, err = NewBracket(ioc, 200, 185)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders[0].TIF != "IOC" || orders[1].TIF != "GTC" || orders[2].TIF != "GTC" {
+		t.Errorf("TIFs = %s, %s, %s; want IOC, GTC, GTC", orders[0].TIF, orders[1].TIF, orders[2].TIF)
+	}
+
+	tests := []struct {
+		name       string
+		entry      OrderRequest
+		tp, sl     float64
+		wantSubstr string
+	}{
+		{"no coid", OrderRequest{Conid: 1, OrderType: "MKT", Side: "BUY", Quantity: 1}, 2, 1, "no COID"},
+		{"no conid", OrderRequest{COID: "a", OrderType: "MKT", Side: "BUY", Quantity: 1}, 2, 1, "no conid"},
+		{"bad side", OrderRequest{Conid: 1, COID: "a", OrderType: "MKT", Side: "buy", Quantity: 1}, 2, 1, "side"},
+		{"cash qty", OrderRequest{Conid: 1, COID: "a", OrderType: "MKT", Side: "BUY", CashQty: 100}, 2, 1, "cash quantity"},
+		{"inverted", OrderRequest{Conid: 1, COID: "a", OrderType: "MKT", Side: "BUY", Quantity: 1}, 1, 2, "below"},
+		{"inverted short", OrderRequest{Conid: 1, COID: "a", OrderType: "MKT", Side: "SELL", Quantity: 1}, 2, 1, "above"},
+		{"outside limit", OrderRequest{Conid: 1, COID: "a", OrderType: "LMT", Side: "BUY", Quantity: 1, Price: 5}, 4, 1, "either side"},
+		{"nested", OrderRequest{Conid: 1, COID: "a", ParentID: "p", OrderType: "MKT", Side: "BUY", Quantity: 1}, 2, 1, "already"},
+	}
+	for _, tt := range tests {
+		_, err := NewBracket(tt.entry, tt.tp, tt.sl)
+		if err == nil || !strings.Contains(err.Error(), tt.wantSubstr) {
+			t.Errorf("%s: got error %v, want one mentioning %q", tt.name, err, tt.wantSubstr)
+		}
+	}
+}
+
+func TestNewOCAGroup(t *testing.T) {
+	orders := []OrderRequest{
+		{Conid: 265598, COID: "a", OrderType: "LMT", Side: "BUY", TIF: "DAY", Quantity: 10, Price: 180},
+		{Conid: 272093, COID: "b", OrderType: "LMT", Side: "BUY", TIF: "DAY", Quantity: 20, Price: 400},
+	}
+	group, err := NewOCAGroup(orders...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, o := range group {
+		if !o.IsSingleGroup {
+			t.Errorf("order %d is not marked as part of the group", i)
+		}
+		if orders[i].IsSingleGroup {
+			t.Errorf("order %d: the caller's order was modified", i)
+		}
+	}
+	data, _ := json.Marshal(group[0])
+	if !strings.Contains(string(data), `"isSingleGroup":true`) {
+		t.Errorf("expected isSingleGroup in %s", data)
+	}
+
+	if _, err := NewOCAGroup(orders[0]); err == nil {
+		t.Error("expected an error for a group of one")
+	}
+	dup := []OrderRequest{orders[0], orders[0]}
+	if _, err := NewOCAGroup(dup...); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Errorf("expected a duplicate COID error, got %v", err)
+	}
+	child := orders[1]
+	child.ParentID = "a"
+	if _, err := NewOCAGroup(orders[0], child); err == nil || !strings.Contains(err.Error(), "order 1") {
+		t.Errorf("expected an error naming the bracket child, got %v", err)
+	}
+}
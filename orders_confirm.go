@@ -0,0 +1,234 @@
+package ibclientportal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ConfirmDecision is a ConfirmPolicy's answer to one question.
+type ConfirmDecision int
+
+const (
+	// ConfirmUnknown means the policy has no answer. PlaceAndConfirm stops
+	// and returns an *UnknownQuestionError, leaving the question unanswered.
+	ConfirmUnknown ConfirmDecision = iota
+	// ConfirmAccept answers the question yes.
+	ConfirmAccept
+	// ConfirmReject answers the question no, which abandons the order.
+	ConfirmReject
+)
+
+func (d ConfirmDecision) String() string {
+	switch d {
+	case ConfirmUnknown:
+		return "unknown"
+	case ConfirmAccept:
+		return "accept"
+	case ConfirmReject:
+		return "reject"
+	default:
+		return fmt.Sprintf("ConfirmDecision(%d)", int(d))
+	}
+}
+
+// DefaultConfirmRounds is the number of rounds of questions PlaceAndConfirm
+// answers when ConfirmPolicy.MaxRounds is zero.
+const DefaultConfirmRounds = 10
+
+// ConfirmPolicy decides how PlaceAndConfirm answers the questions IB asks
+// before transmitting an order. Questions are recognised by their
+// OrderPlacement.MessageIDs, which are stable across orders, rather than by
+// their text, which is not.
+//
+// IDs passed to SuppressQuestions count as accepted unless listed in Reject:
+// IB asks them only when it has lost the suppression, and the caller has
+// already said yes to them.
+//
+// A question carrying several message IDs is rejected if the policy rejects
+// any of them and accepted only if it accepts all of them. A question with an
+// ID the policy does not recognise is never answered on the caller's behalf.
+type ConfirmPolicy struct {
+	// Accept lists message IDs to answer yes, e.g. "o163".
+	Accept []string
+	// Reject lists message IDs to answer no. Reject wins over Accept.
+	Reject []string
+	// Decide, if set, is consulted for message IDs in neither list, with the
+	// question the ID arrived in.
+	Decide func(messageID string, question OrderPlacement) ConfirmDecision
+	// MaxRounds bounds how many rounds of questions are answered before giving
+	// up; zero means DefaultConfirmRounds. Each confirmation can raise a
+	// further question, so this guards against a loop.
+	MaxRounds int
+}
+
+// decide returns the policy's answer to q and the message IDs that produced
+// it: the rejected IDs for a rejection, the unrecognised IDs for ConfirmUnknown.
+func (p ConfirmPolicy) decide(q OrderPlacement, suppressed []string) (ConfirmDecision, []string) {
+	var rejected, unknown []string
+	for _, id := range q.MessageIDs {
+		switch {
+		case slices.Contains(p.Reject, id):
+			rejected = append(rejected, id)
+		case slices.Contains(p.Accept, id), slices.Contains(suppressed, id):
+		case p.Decide != nil:
+			switch p.Decide(id, q) {
+			case ConfirmAccept:
+			case ConfirmReject:
+				rejected = append(rejected, id)
+			default:
+				unknown = append(unknown, id)
+			}
+		default:
+			unknown = append(unknown, id)
+		}
+	}
+	switch {
+	case len(rejected) > 0:
+		return ConfirmReject, rejected
+	case len(unknown) > 0:
+		return ConfirmUnknown, unknown
+	case len(q.MessageIDs) == 0:
+		// Nothing to recognise the question by.
+		return ConfirmUnknown, nil
+	}
+	return ConfirmAccept, nil
+}
+
+// ConfirmStep is one question in a PlaceAndConfirm transcript and how it was
+// answered.
+type ConfirmStep struct {
+	// Round counts from 1 for the questions raised by PlaceOrders itself.
+	Round    int
+	Question OrderPlacement
+	Decision ConfirmDecision
+	// Response is the gateway's reply to the answer. It is empty when the
+	// question was not answered.
+	Response []OrderPlacement
+}
+
+// ConfirmResult is the outcome of PlaceAndConfirm.
+type ConfirmResult struct {
+	// Placed holds every order IB accepted, in the order they were reported.
+	Placed []OrderPlacement
+	// Transcript records every question asked and the answer given.
+	Transcript []ConfirmStep
+}
+
+// UnknownQuestionError is returned by PlaceAndConfirm when IB asks a question
+// the policy has no answer for. The question is left unanswered, so the
+// caller may still answer it with ConfirmOrder(Question.ReplyID, ...).
+type UnknownQuestionError struct {
+	Question OrderPlacement
+	// MessageIDs are the IDs the policy did not recognise. It is empty when
+	// the question carried no message IDs at all.
+	MessageIDs []string
+}
+
+func (e *UnknownQuestionError) Error() string {
+	if len(e.MessageIDs) == 0 {
+		return fmt.Sprintf("ibclientportal: order question without a message ID: %q", e.Question.Question())
+	}
+	return fmt.Sprintf("ibclientportal: no policy for order question %s: %q", strings.Join(e.MessageIDs, ","), e.Question.Question())
+}
+
+// QuestionRejectedError is returned by PlaceAndConfirm when the policy
+// answered a question no, abandoning the order it was about.
+type QuestionRejectedError struct {
+	Question OrderPlacement
+	// MessageIDs are the IDs the policy rejected.
+	MessageIDs []string
+}
+
+func (e *QuestionRejectedError) Error() string {
+	return fmt.Sprintf("ibclientportal: order question %s rejected by policy: %q", strings.Join(e.MessageIDs, ","), e.Question.Question())
+}
+
+// PlaceAndConfirm places orders with PlaceOrders and answers the questions IB
+// raises according to policy, round after round, until no question is left.
+//
+// It returns the placed orders and a transcript of every question and answer.
+// The result is returned alongside any error, so that orders placed before a
+// later question failed are not lost. The error is an *UnknownQuestionError
+// when a question has no answer in the policy, a *QuestionRejectedError when
+// the policy rejected one, or an error from the gateway. Other questions in
+// the same round are still answered, and their orders may still be placed.
+func (o *OrdersService) PlaceAndConfirm(ctx context.Context, accountID string, orders []OrderRequest, policy ConfirmPolicy) (ConfirmResult, error) {
+	return placeAndConfirm(ctx, o, o.client.suppression.list, accountID, orders, policy)
+}
+
+// placeAndConfirm is PlaceAndConfirm for any backend. suppressed returns the
+// message IDs to treat as accepted.
+func placeAndConfirm(ctx context.Context, b OrderBackend, suppressed func() []string, accountID string, orders []OrderRequest, policy ConfirmPolicy) (ConfirmResult, error) {
+	var result ConfirmResult
+	placements, err := b.PlaceOrders(ctx, accountID, orders)
+	if err != nil {
+		// Some orders in the batch may have been placed before one failed.
+		result.Placed = appendPlaced(result.Placed, placements)
+		return result, err
+	}
+	maxRounds := policy.MaxRounds
+	if maxRounds <= 0 {
+		maxRounds = DefaultConfirmRounds
+	}
+	var firstErr error
+	for round := 1; ; round++ {
+		var questions []OrderPlacement
+		for _, p := range placements {
+			switch {
+			case p.IsPlaced():
+				result.Placed = append(result.Placed, p)
+			case p.IsQuestion():
+				questions = append(questions, p)
+			}
+		}
+		if len(questions) == 0 {
+			return result, firstErr
+		}
+		if round > maxRounds {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("ibclientportal: PlaceAndConfirm: still being asked questions after %d rounds", maxRounds)
+			}
+			return result, firstErr
+		}
+		placements = nil
+		for _, q := range questions {
+			decision, ids := policy.decide(q, suppressed())
+			step := ConfirmStep{Round: round, Question: q, Decision: decision}
+			if decision == ConfirmUnknown {
+				result.Transcript = append(result.Transcript, step)
+				if firstErr == nil {
+					firstErr = &UnknownQuestionError{Question: q, MessageIDs: ids}
+				}
+				continue
+			}
+			step.Response, err = b.ConfirmOrder(ctx, q.ReplyID, decision == ConfirmAccept)
+			result.Transcript = append(result.Transcript, step)
+			if err != nil {
+				result.Placed = appendPlaced(result.Placed, step.Response)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			if decision == ConfirmReject {
+				if firstErr == nil {
+					firstErr = &QuestionRejectedError{Question: q, MessageIDs: ids}
+				}
+				continue
+			}
+			placements = append(placements, step.Response...)
+		}
+	}
+}
+
+// appendPlaced appends the placements that were placed to placed.
+func appendPlaced(placed, placements []OrderPlacement) []OrderPlacement {
+	for _, p := range placements {
+		if p.IsPlaced() {
+			placed = append(placed, p)
+		}
+	}
+	return placed
+}
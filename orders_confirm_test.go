@@ -0,0 +1,170 @@
+package ibclientportal
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// questionServer answers order placement with the given chain of questions,
+// one per confirmation, and then with a placed order. It records the bodies
+// of the confirmations it receives.
+func questionServer(t *testing.T, messageIDs ...string) (*Client, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var answers []string
+	question := func(w http.ResponseWriter, i int) {
+		if i == len(messageIDs) {
+			w.Write([]byte(`[{"order_id":"1533204928","order_status":"PreSubmitted"}]`))
+			return
+		}
+		w.Write([]byte(`[{"id":"r` + string(rune('0'+i)) + `","message":["question ` + messageIDs[i] + `"],"messageIds":["` + messageIDs[i] + `"]}]`))
+	}
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/api/iserver/account/U1234567/orders" {
+			question(w, 0)
+			return
+		}
+		reply, ok := strings.CutPrefix(r.URL.Path, "/v1/api/iserver/reply/r")
+		if !ok {
+			t.Errorf("unexpected path %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		answers = append(answers, string(body))
+		mu.Unlock()
+		if strings.Contains(string(body), "false") {
+			w.Write([]byte(`[]`))
+			return
+		}
+		question(w, int(reply[0]-'0')+1)
+	})
+	t.Cleanup(server.Close)
+	return client, &answers
+}
+
+var confirmOrder = []OrderRequest{{Conid: 265598, OrderType: "LMT", Side: "BUY", TIF: "DAY", Quantity: 1, Price: 100}}
+
+func TestPlaceAndConfirm(t *testing.T) {
+	client, answers := questionServer(t, "o163", "o354")
+	res, err := client.Orders.PlaceAndConfirm(testContext(t), "U1234567", confirmOrder, ConfirmPolicy{
+		Accept: []string{"o163", "o354"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Placed) != 1 || res.Placed[0].OrderID != "1533204928" {
+		t.Errorf("unexpected placed orders %+v", res.Placed)
+	}
+	if len(res.Transcript) != 2 {
+		t.Fatalf("got %d transcript steps, want 2", len(res.Transcript))
+	}
+	for i, step := range res.Transcript {
+		if step.Round != i+1 || step.Decision != ConfirmAccept || len(step.Response) != 1 {
+			t.Errorf("step %d: unexpected %+v", i, step)
+		}
+	}
+	if got := strings.Join(*answers, ""); got != `{"confirmed":true}{"confirmed":true}` {
+		t.Errorf("gateway received answers %s", got)
+	}
+}
+
+func TestPlaceAndConfirmUnknownQuestion(t *testing.T) {
+	client, answers := questionServer(t, "o163", "o10151")
+	res, err := client.Orders.PlaceAndConfirm(testContext(t), "U1234567", confirmOrder, ConfirmPolicy{
+		Accept: []string{"o163"},
+	})
+	var unknown *UnknownQuestionError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("expected an *UnknownQuestionError, got %v", err)
+	}
+	if len(unknown.MessageIDs) != 1 || unknown.MessageIDs[0] != "o10151" || unknown.Question.ReplyID != "r1" {
+		t.Errorf("unexpected error %+v", unknown)
+	}
+	if len(*answers) != 1 {
+		t.Errorf("the unknown question should not be answered; gateway received %v", *answers)
+	}
+	if len(res.Placed) != 0 || len(res.Transcript) != 2 || res.Transcript[1].Decision != ConfirmUnknown {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestPlaceAndConfirmReject(t *testing.T) {
+	client, answers := questionServer(t, "o163")
+	_, err := client.Orders.PlaceAndConfirm(testContext(t), "U1234567", confirmOrder, ConfirmPolicy{
+		Decide: func(id string, q OrderPlacement) ConfirmDecision {
+			if id == "o163" {
+				return ConfirmReject
+			}
+			return ConfirmUnknown
+		},
+	})
+	var rejected *QuestionRejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("expected a *QuestionRejectedError, got %v", err)
+	}
+	if len(rejected.MessageIDs) != 1 || rejected.MessageIDs[0] != "o163" {
+		t.Errorf("unexpected error %+v", rejected)
+	}
+	if got := strings.Join(*answers, ""); got != `{"confirmed":false}` {
+		t.Errorf("gateway received answers %s", got)
+	}
+}
+
+func TestPlaceAndConfirmMaxRounds(t *testing.T) {
+	client, answers := questionServer(t, "o1", "o2", "o3")
+	_, err := client.Orders.PlaceAndConfirm(testContext(t), "U1234567", confirmOrder, ConfirmPolicy{
+		Accept:    []string{"o1", "o2", "o3"},
+		MaxRounds: 2,
+	})
+	if err == nil || !strings.Contains(err.Error(), "after 2 rounds") {
+		t.Errorf("expected a round limit error, got %v", err)
+	}
+	if len(*answers) != 2 {
+		t.Errorf("expected 2 answers, got %v", *answers)
+	}
+}
+
+// An order placed in the same batch as one the gateway rejected is still
+// reported.
+func TestPlaceAndConfirmPartialBatch(t *testing.T) {
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"order_id":"1533204928","order_status":"PreSubmitted"},{"error":"insufficient funds"}]`))
+	})
+	defer server.Close()
+	res, err := client.Orders.PlaceAndConfirm(testContext(t), "U1234567", append(slices.Clone(confirmOrder), confirmOrder...), ConfirmPolicy{})
+	if err == nil || !strings.Contains(err.Error(), "insufficient funds") {
+		t.Errorf("expected the rejection as an error, got %v", err)
+	}
+	if len(res.Placed) != 1 || res.Placed[0].OrderID != "1533204928" {
+		t.Errorf("placed = %+v, want the order that was accepted", res.Placed)
+	}
+}
+
+func TestConfirmPolicyDecide(t *testing.T) {
+	p := ConfirmPolicy{Accept: []string{"a", "b"}, Reject: []string{"r"}}
+	tests := []struct {
+		ids  []string
+		want ConfirmDecision
+	}{
+		{[]string{"a", "b"}, ConfirmAccept},
+		{[]string{"a", "r"}, ConfirmReject},
+		{[]string{"a", "x"}, ConfirmUnknown},
+		{[]string{"x", "r"}, ConfirmReject},
+		{[]string{"a", "s"}, ConfirmAccept},
+		{nil, ConfirmUnknown},
+	}
+	for _, tt := range tests {
+		if got, _ := p.decide(OrderPlacement{ReplyID: "q", MessageIDs: tt.ids}, []string{"s"}); got != tt.want {
+			t.Errorf("decide(%v) = %v, want %v", tt.ids, got, tt.want)
+		}
+	}
+}
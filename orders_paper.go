@@ -0,0 +1,869 @@
+package ibclientportal
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PaperBroker is a simulated broker that runs in-process. It implements
+// OrderBackend: orders are placed, questioned, modified, cancelled and filled
+// as they would be through the gateway, but nothing is sent anywhere. Use it
+// to run strategy code against live quotes before trading for real.
+//
+// Orders fill against the quotes the broker is given, by ApplyQuote or from a
+// QuoteBook. A buy trades at the ask and a sell at the bid, or at the last
+// price when the quote has no bid or ask. Each quote fills no more than the
+// size shown on its side of the market, so a large order fills in parts over
+// several quotes. Orders fill on the next quote after they are placed: a
+// QuoteBook's state as it stood when an order was placed is not traded
+// against, only the updates that follow it.
+//
+// MKT, LMT, STP and STOP_LIMIT orders are supported. A STP order's stop price
+// is its Price, and a STOP_LIMIT order's is its AuxPrice; the stop triggers
+// when the last price reaches it. Orders with a ParentID wait for their parent
+// to fill, and are one-cancels-all with their siblings, as in a bracket. Orders
+// with IsSingleGroup are one-cancels-all with the others in the same request.
+// As with IB, a partial fill in a one-cancels-all group reduces the others'
+// quantities by the amount filled, and only a complete fill cancels them.
+// Time in force is not simulated: an order works until it fills or is
+// cancelled.
+//
+// A PaperBroker is safe for concurrent use.
+type PaperBroker struct {
+	book *QuoteBook
+	opts PaperOptions
+	now  func() time.Time
+
+	mu        sync.Mutex
+	nextID    int64
+	nextGroup int
+	orders    []*paperOrder
+	byID      map[string]*paperOrder
+	pending   map[string]*paperPending
+	trades    []Trade
+	positions map[paperPositionKey]*paperPosition
+	quotes    map[int]Quote
+	watching  map[int]func()
+	// changed is closed and replaced whenever an order changes, waking
+	// WaitForOrder.
+	changed chan struct{}
+	closed  bool
+}
+
+// PaperOptions configure a PaperBroker.
+type PaperOptions struct {
+	// Questions are asked before orders are placed or modified, as the
+	// gateway asks its questions, so that the confirmation path is exercised
+	// on paper too.
+	Questions []PaperQuestion
+	// CommissionPerShare and MinCommission set the commission charged on
+	// each execution.
+	CommissionPerShare float64
+	MinCommission      float64
+}
+
+// PaperQuestion is a question a PaperBroker asks.
+type PaperQuestion struct {
+	// MessageID identifies the question, as OrderPlacement.MessageIDs does,
+	// e.g. "o163".
+	MessageID string
+	Message   string
+	// When decides which orders the question is asked about. If it is nil,
+	// the question is asked about every order.
+	When func(OrderRequest) bool
+}
+
+// NewPaperBroker returns a PaperBroker. If book is not nil, orders fill
+// against its quotes: the broker watches each contract it is given an order
+// for. Otherwise quotes are given to the broker with ApplyQuote.
+func NewPaperBroker(book *QuoteBook, opts PaperOptions) *PaperBroker {
+	return &PaperBroker{
+		book:      book,
+		opts:      opts,
+		now:       time.Now,
+		nextID:    1,
+		byID:      make(map[string]*paperOrder),
+		pending:   make(map[string]*paperPending),
+		positions: make(map[paperPositionKey]*paperPosition),
+		quotes:    make(map[int]Quote),
+		watching:  make(map[int]func()),
+		changed:   make(chan struct{}),
+	}
+}
+
+var _ OrderBackend = (*PaperBroker)(nil)
+
+// Close stops watching the QuoteBook. Orders stop filling, but can still be
+// listed, modified and cancelled.
+func (p *PaperBroker) Close() error {
+	p.mu.Lock()
+	stops := make([]func(), 0, len(p.watching))
+	for _, stop := range p.watching {
+		stops = append(stops, stop)
+	}
+	p.closed = true
+	p.mu.Unlock()
+	for _, stop := range stops {
+		stop()
+	}
+	return nil
+}
+
+type paperOrder struct {
+	id       string
+	account  string
+	req      OrderRequest
+	conid    int
+	state    OrderState
+	filled   float64
+	avgPrice float64
+	// triggered is set once a stop order's stop price has been reached.
+	triggered bool
+	// group links one-cancels-all orders; empty for none.
+	group    string
+	parent   *paperOrder
+	placed   time.Time
+	lastFill time.Time
+	// quoteAsOf is when the QuoteBook's state for the contract last changed
+	// before the order was placed; states no newer than it are stale.
+	quoteAsOf time.Time
+}
+
+func (o *paperOrder) buy() bool {
+	return strings.EqualFold(o.req.Side, "BUY")
+}
+
+func (o *paperOrder) remaining() float64 {
+	return o.req.Quantity - o.filled
+}
+
+// paperPending is a request waiting on answers to the broker's questions.
+type paperPending struct {
+	questions []PaperQuestion
+	apply     func() ([]OrderPlacement, error)
+}
+
+// PlaceOrders places the orders, after asking any of the broker's questions
+// that apply to them.
+func (p *PaperBroker) PlaceOrders(ctx context.Context, accountID string, orders []OrderRequest) ([]OrderPlacement, error) {
+	if accountID == "" {
+		return nil, fmt.Errorf("ibclientportal: PlaceOrders: no account ID given")
+	}
+	if len(orders) == 0 {
+		return nil, fmt.Errorf("ibclientportal: PlaceOrders: no orders given")
+	}
+	orders = slices.Clone(orders)
+	for i, o := range orders {
+		if err := checkPaperOrder(o); err != nil {
+			return paperError(fmt.Sprintf("order %d: %v", i, err))
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ask(orders, func() ([]OrderPlacement, error) {
+		return p.place(accountID, orders)
+	})
+}
+
+// ModifyOrder replaces a working order's terms, after asking any of the
+// broker's questions that apply to the new terms. The order's contract and
+// side cannot be changed, and its quantity cannot go below what has filled.
+func (p *PaperBroker) ModifyOrder(ctx context.Context, accountID, orderID string, order OrderRequest) ([]OrderPlacement, error) {
+	if accountID == "" {
+		return nil, fmt.Errorf("ibclientportal: ModifyOrder: no account ID given")
+	}
+	if orderID == "" {
+		return nil, fmt.Errorf("ibclientportal: ModifyOrder: no order ID given")
+	}
+	if err := checkPaperOrder(order); err != nil {
+		return paperError(err.Error())
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.modifiable(accountID, orderID, order); err != nil {
+		return paperError(err.Error())
+	}
+	return p.ask([]OrderRequest{order}, func() ([]OrderPlacement, error) {
+		// The order may have filled or been cancelled while the question
+		// was open.
+		o, err := p.modifiable(accountID, orderID, order)
+		if err != nil {
+			return paperError(err.Error())
+		}
+		o.req.OrderType = order.OrderType
+		o.req.Price = order.Price
+		o.req.AuxPrice = order.AuxPrice
+		o.req.Quantity = order.Quantity
+		o.req.TIF = order.TIF
+		o.req.OutsideRTH = order.OutsideRTH
+		if o.filled >= o.req.Quantity {
+			o.state = OrderStateFilled
+			p.afterFill(o, 0)
+		}
+		p.notify()
+		return []OrderPlacement{{OrderID: OrderID(o.id), OrderStatus: string(o.state), LocalOrderID: o.req.COID}}, nil
+	})
+}
+
+// modifiable returns the order if it can be changed to order's terms.
+func (p *PaperBroker) modifiable(accountID, orderID string, order OrderRequest) (*paperOrder, error) {
+	o, ok := p.byID[orderID]
+	switch {
+	case !ok || o.account != accountID:
+		return nil, fmt.Errorf("order %s not found", orderID)
+	case !o.state.IsWorking():
+		return nil, fmt.Errorf("order %s is %s and cannot be modified", orderID, o.state)
+	case orderConid(order) != 0 && orderConid(order) != o.conid:
+		return nil, fmt.Errorf("order %s is for conid %d, not %d", orderID, o.conid, orderConid(order))
+	case !strings.EqualFold(order.Side, o.req.Side):
+		return nil, fmt.Errorf("order %s is a %s order and cannot become a %s", orderID, o.req.Side, order.Side)
+	case order.Quantity < o.filled:
+		return nil, fmt.Errorf("order %s has already filled %s", orderID, formatAmount(o.filled))
+	}
+	return o, nil
+}
+
+// ask returns the first of the broker's questions that applies to orders, or,
+// if none does, runs apply.
+func (p *PaperBroker) ask(orders []OrderRequest, apply func() ([]OrderPlacement, error)) ([]OrderPlacement, error) {
+	var questions []PaperQuestion
+	for _, q := range p.opts.Questions {
+		if q.When == nil || slices.ContainsFunc(orders, q.When) {
+			questions = append(questions, q)
+		}
+	}
+	if len(questions) == 0 {
+		return apply()
+	}
+	return p.question(&paperPending{questions: questions, apply: apply}), nil
+}
+
+// question records pending and returns its next question.
+func (p *PaperBroker) question(pending *paperPending) []OrderPlacement {
+	replyID := "paper-" + strconv.FormatInt(p.nextID, 10)
+	p.nextID++
+	p.pending[replyID] = pending
+	q := pending.questions[0]
+	return []OrderPlacement{{ReplyID: replyID, Messages: []string{q.Message}, MessageIDs: []string{q.MessageID}}}
+}
+
+// ConfirmOrder answers one of the broker's questions. Answering the last
+// question yes places or modifies the orders it was about; answering no
+// abandons them and returns no placements.
+func (p *PaperBroker) ConfirmOrder(ctx context.Context, replyID string, confirmed bool) ([]OrderPlacement, error) {
+	if replyID == "" {
+		return nil, fmt.Errorf("ibclientportal: ConfirmOrder: no reply ID given")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending, ok := p.pending[replyID]
+	if !ok {
+		return paperError(fmt.Sprintf("no question with reply ID %s", replyID))
+	}
+	delete(p.pending, replyID)
+	if !confirmed {
+		return []OrderPlacement{}, nil
+	}
+	pending.questions = pending.questions[1:]
+	if len(pending.questions) > 0 {
+		return p.question(pending), nil
+	}
+	return pending.apply()
+}
+
+// PlaceAndConfirm is OrdersService.PlaceAndConfirm for the paper broker.
+func (p *PaperBroker) PlaceAndConfirm(ctx context.Context, accountID string, orders []OrderRequest, policy ConfirmPolicy) (ConfirmResult, error) {
+	return placeAndConfirm(ctx, p, func() []string { return nil }, accountID, orders, policy)
+}
+
+// place places orders that have been through the questions.
+func (p *PaperBroker) place(accountID string, orders []OrderRequest) ([]OrderPlacement, error) {
+	for i, req := range orders {
+		if req.COID == "" {
+			continue
+		}
+		if p.findCOID(accountID, req.COID) != nil || slices.ContainsFunc(orders[:i], func(o OrderRequest) bool { return o.COID == req.COID }) {
+			return paperError(fmt.Sprintf("order %d: local order ID %s is already registered", i, req.COID))
+		}
+	}
+	var oca string
+	now := p.now()
+	placed := make([]*paperOrder, len(orders))
+	for i, req := range orders {
+		o := &paperOrder{
+			id:      strconv.FormatInt(p.nextID, 10),
+			account: accountID,
+			req:     req,
+			conid:   orderConid(req),
+			state:   OrderStateSubmitted,
+			placed:  now,
+		}
+		if p.book != nil {
+			if st, ok := p.book.Get(o.conid); ok {
+				o.quoteAsOf = st.Updated
+			}
+		}
+		p.nextID++
+		if req.IsSingleGroup {
+			if oca == "" {
+				oca = p.newGroup()
+			}
+			o.group = oca
+		}
+		if req.ParentID != "" {
+			for _, prev := range placed[:i] {
+				if prev.req.COID == req.ParentID {
+					o.parent = prev
+				}
+			}
+			if o.parent == nil {
+				o.parent = p.findCOID(accountID, req.ParentID)
+			}
+			if o.parent == nil {
+				return paperError(fmt.Sprintf("order %d: parent order %s not found", i, req.ParentID))
+			}
+			if o.group == "" {
+				o.group = "parent:" + o.parent.id
+			}
+			if o.parent.state != OrderStateFilled {
+				o.state = OrderStatePreSubmitted
+			}
+		}
+		placed[i] = o
+	}
+	placements := make([]OrderPlacement, len(placed))
+	for i, o := range placed {
+		p.orders = append(p.orders, o)
+		p.byID[o.id] = o
+		p.watch(o.conid)
+		placements[i] = OrderPlacement{OrderID: OrderID(o.id), OrderStatus: string(o.state), LocalOrderID: o.req.COID}
+	}
+	p.notify()
+	return placements, nil
+}
+
+func (p *PaperBroker) newGroup() string {
+	p.nextGroup++
+	return "oca:" + strconv.Itoa(p.nextGroup)
+}
+
+func (p *PaperBroker) findCOID(accountID, coid string) *paperOrder {
+	for _, o := range p.orders {
+		if o.account == accountID && o.req.COID == coid {
+			return o
+		}
+	}
+	return nil
+}
+
+// watch starts following the contract's quotes in the QuoteBook, if there
+// is one.
+func (p *PaperBroker) watch(conid int) {
+	if p.book == nil || p.closed {
+		return
+	}
+	if _, ok := p.watching[conid]; ok {
+		return
+	}
+	ch, stop := p.book.Watch(conid)
+	p.watching[conid] = stop
+	go func() {
+		for st := range ch {
+			p.applyQuote(st.Quote(), st.Updated)
+		}
+	}()
+}
+
+// CancelOrder cancels a working order. Unlike the gateway's, the cancellation
+// takes effect at once.
+func (p *PaperBroker) CancelOrder(ctx context.Context, accountID, orderID string) (CancelOrderResponse, error) {
+	var val CancelOrderResponse
+	if accountID == "" {
+		return val, fmt.Errorf("ibclientportal: CancelOrder: no account ID given")
+	}
+	if orderID == "" {
+		return val, fmt.Errorf("ibclientportal: CancelOrder: no order ID given")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.byID[orderID]
+	if !ok || o.account != accountID {
+		val.Error = fmt.Sprintf("OrderID %s doesn't exist", orderID)
+		return val, fmt.Errorf("ibclientportal: CancelOrder: %s", val.Error)
+	}
+	val = CancelOrderResponse{OrderID: OrderID(o.id), Conid: int64(o.conid), Account: o.account}
+	if o.state.IsTerminal() {
+		val.Error = fmt.Sprintf("OrderID %s is %s and cannot be cancelled", orderID, o.state)
+		return val, fmt.Errorf("ibclientportal: CancelOrder: %s", val.Error)
+	}
+	p.cancel(o)
+	p.notify()
+	val.Message = "Request was submitted"
+	return val, nil
+}
+
+// cancel cancels o and the children waiting on it.
+func (p *PaperBroker) cancel(o *paperOrder) {
+	o.state = OrderStateCancelled
+	for _, child := range p.orders {
+		if child.parent == o && child.state.IsWorking() {
+			child.state = OrderStateCancelled
+		}
+	}
+}
+
+// ApplyQuote fills the working orders in the quote's contract that it would
+// trade against. q should be the contract's full current quote, as a
+// QuoteBook holds it, not a single incremental update.
+func (p *PaperBroker) ApplyQuote(q Quote) {
+	p.applyQuote(q, time.Time{})
+}
+
+// applyQuote is ApplyQuote for a quote from the QuoteBook state last changed
+// at updated, which orders placed since then do not trade against. A zero
+// updated applies the quote to every working order.
+func (p *PaperBroker) applyQuote(q Quote, updated time.Time) {
+	if q.Conid == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.quotes[q.Conid] = q
+	// Each side of the quote has its size to give; orders take from it in
+	// the order they were placed.
+	bidSize, askSize := quoteSize(q.BidSize), quoteSize(q.AskSize)
+	// Orders a fill releases, such as a bracket's exits, wait for the next
+	// quote.
+	var working []*paperOrder
+	for _, o := range p.orders {
+		if o.conid == q.Conid && o.state == OrderStateSubmitted && (updated.IsZero() || updated.After(o.quoteAsOf)) {
+			working = append(working, o)
+		}
+	}
+	changed := false
+	for _, o := range working {
+		if o.state != OrderStateSubmitted {
+			// Cancelled by a fill earlier in its group.
+			continue
+		}
+		price, ok := o.fillPrice(q)
+		if !ok {
+			continue
+		}
+		avail := &askSize
+		if !o.buy() {
+			avail = &bidSize
+		}
+		qty := math.Min(o.remaining(), *avail)
+		if qty <= 0 {
+			continue
+		}
+		*avail -= qty
+		p.fill(o, qty, price)
+		changed = true
+	}
+	if changed {
+		p.notify()
+	}
+}
+
+// quoteSize returns the size shown on one side of a quote, or no limit if
+// the quote does not show it.
+func quoteSize(n Number) float64 {
+	if n.Valid && n.Value > 0 {
+		return n.Value
+	}
+	return math.Inf(1)
+}
+
+// fillPrice returns the price o would trade at against q. The second return
+// value is false if it would not trade.
+func (o *paperOrder) fillPrice(q Quote) (float64, bool) {
+	touch := q.Ask
+	if !o.buy() {
+		touch = q.Bid
+	}
+	if !touch.Live() || touch.Value <= 0 {
+		touch = q.Last
+	}
+	if !touch.Live() || touch.Value <= 0 {
+		return 0, false
+	}
+	price := touch.Value
+	limitOK := func(limit float64) bool {
+		if o.buy() {
+			return price <= limit
+		}
+		return price >= limit
+	}
+	switch strings.ToUpper(o.req.OrderType) {
+	case "MKT":
+		return price, true
+	case "LMT":
+		return price, limitOK(o.req.Price)
+	case "STP":
+		return price, o.trigger(q, o.req.Price)
+	case "STOP_LIMIT":
+		return price, o.trigger(q, o.req.AuxPrice) && limitOK(o.req.Price)
+	}
+	return 0, false
+}
+
+// trigger reports whether o's stop price has been reached, now or before.
+func (o *paperOrder) trigger(q Quote, stop float64) bool {
+	if o.triggered {
+		return true
+	}
+	last := q.Last
+	if !last.Live() {
+		return false
+	}
+	if o.buy() {
+		o.triggered = last.Value >= stop
+	} else {
+		o.triggered = last.Value <= stop
+	}
+	return o.triggered
+}
+
+// fill executes qty of o at price.
+func (p *PaperBroker) fill(o *paperOrder, qty, price float64) {
+	now := p.now()
+	o.avgPrice = (o.avgPrice*o.filled + price*qty) / (o.filled + qty)
+	o.filled += qty
+	o.lastFill = now
+	if o.remaining() <= 0 {
+		o.state = OrderStateFilled
+	}
+	signed := qty
+	side := "B"
+	if !o.buy() {
+		signed, side = -qty, "S"
+	}
+	key := paperPositionKey{o.account, o.conid}
+	pos, ok := p.positions[key]
+	if !ok {
+		pos = &paperPosition{}
+		p.positions[key] = pos
+	}
+	pos.add(signed, price)
+	commission := math.Max(qty*p.opts.CommissionPerShare, p.opts.MinCommission)
+	p.trades = append(p.trades, Trade{
+		ExecutionID:      "paper." + o.id + "." + strconv.Itoa(len(p.trades)+1),
+		Symbol:           o.req.Ticker,
+		Side:             side,
+		OrderDescription: fmt.Sprintf("%s %s %s", paperSideName(o), formatAmount(qty), o.req.OrderType),
+		TradeTime:        now.UTC().Format(tradeTimeLayout),
+		TradeTimeR:       now.UnixMilli(),
+		Size:             qty,
+		Price:            formatAmount(price),
+		OrderRef:         o.req.COID,
+		Exchange:         "PAPER",
+		Commission:       formatAmount(commission),
+		NetAmount:        qty * price,
+		Account:          o.account,
+		AccountCode:      o.account,
+		ContractID:       int64(o.conid),
+		ContractIDEx:     o.req.ConidEx,
+		OrderID:          float64(o.numericID()),
+	})
+	p.afterFill(o, qty)
+}
+
+// afterFill updates the orders linked to o after qty of it fills: the rest of
+// a one-cancels-all group is reduced by qty, and cancelled once o has filled
+// completely or nothing of it is left, and a parent's complete fill releases
+// its children.
+func (p *PaperBroker) afterFill(o *paperOrder, qty float64) {
+	for _, other := range p.orders {
+		switch {
+		case other == o:
+		case o.group != "" && other.group == o.group && other.state.IsWorking():
+			other.req.Quantity = math.Max(other.req.Quantity-qty, other.filled)
+			if o.state == OrderStateFilled || other.remaining() <= 0 {
+				p.cancel(other)
+			}
+		case other.parent == o && o.state == OrderStateFilled && other.state == OrderStatePreSubmitted:
+			other.state = OrderStateSubmitted
+		}
+	}
+}
+
+func (o *paperOrder) numericID() int64 {
+	id, _ := strconv.ParseInt(o.id, 10, 64)
+	return id
+}
+
+func paperSideName(o *paperOrder) string {
+	if o.buy() {
+		return "Bought"
+	}
+	return "Sold"
+}
+
+// OrderStatus returns the state of an order placed with the broker.
+func (p *PaperBroker) OrderStatus(ctx context.Context, orderID string) (OrderStatus, error) {
+	if orderID == "" {
+		return OrderStatus{}, fmt.Errorf("ibclientportal: OrderStatus: no order ID given")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.byID[orderID]
+	if !ok {
+		return OrderStatus{}, fmt.Errorf("ibclientportal: OrderStatus: order %s not found", orderID)
+	}
+	return o.status(), nil
+}
+
+func (o *paperOrder) status() OrderStatus {
+	side := "B"
+	if !o.buy() {
+		side = "S"
+	}
+	return OrderStatus{
+		OrderID:      OrderID(o.id),
+		Account:      o.account,
+		Conid:        int64(o.conid),
+		ConidEx:      o.req.ConidEx,
+		Symbol:       o.req.Ticker,
+		Side:         side,
+		OrderType:    o.req.OrderType,
+		TIF:          o.req.TIF,
+		Status:       string(o.state),
+		TotalSize:    o.req.Quantity,
+		Filled:       o.filled,
+		Remaining:    math.Max(o.remaining(), 0),
+		AvgPrice:     o.avgPrice,
+		SizeAndFills: o.sizeAndFills(),
+		OrderTime:    o.placed.UTC().Truncate(time.Second),
+		NotEditable:  !o.state.IsWorking(),
+		CannotCancel: !o.state.IsWorking(),
+	}
+}
+
+// sizeAndFills formats the order's progress as the gateway does: "filled/size"
+// while it is partly filled, and the size alone otherwise.
+func (o *paperOrder) sizeAndFills() string {
+	size := formatAmount(o.req.Quantity)
+	if o.filled > 0 && o.remaining() > 0 {
+		return formatAmount(o.filled) + "/" + size
+	}
+	return size
+}
+
+// WaitForOrder is OrdersService.WaitForOrder for the paper broker. It is
+// woken by the broker's own changes rather than polling.
+func (p *PaperBroker) WaitForOrder(ctx context.Context, orderID string, until WaitUntil) (OrderStatus, error) {
+	var last OrderStatus
+	for {
+		p.mu.Lock()
+		o, ok := p.byID[orderID]
+		if ok {
+			last = o.status()
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		if !ok {
+			return last, fmt.Errorf("ibclientportal: WaitForOrder: order %s not found", orderID)
+		}
+		if until.reached(last) {
+			return last, nil
+		}
+		if last.State().IsTerminal() {
+			return last, fmt.Errorf("ibclientportal: WaitForOrder: order %s is %s, and will not be %s", orderID, last.Status, until)
+		}
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// notify wakes WaitForOrder calls. p.mu must be held.
+func (p *PaperBroker) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// ListOrders returns the broker's orders, oldest first. The "filters" query
+// parameter selects orders by status, as it does for the gateway, e.g.
+// "filled,cancelled". Orders from every account are listed.
+func (p *PaperBroker) ListOrders(ctx context.Context, query url.Values) (OrdersResponse, error) {
+	var filters []OrderState
+	for _, f := range strings.Split(query.Get("filters"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			filters = append(filters, ParseOrderState(f))
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	resp := OrdersResponse{Orders: []Order{}, Snapshot: true}
+	for _, o := range p.orders {
+		if len(filters) > 0 && !slices.Contains(filters, o.state) {
+			continue
+		}
+		resp.Orders = append(resp.Orders, o.order())
+	}
+	return resp, nil
+}
+
+func (o *paperOrder) order() Order {
+	ord := Order{
+		Account:           o.account,
+		AccountID:         o.account,
+		ConIDEx:           o.req.ConidEx,
+		ContractID:        int64(o.conid),
+		OrderID:           o.numericID(),
+		SizeAndFills:      o.sizeAndFills(),
+		Ticker:            o.req.Ticker,
+		ListingExchange:   o.req.ListingExchange,
+		RemainingQuantity: math.Max(o.remaining(), 0),
+		FilledQuantity:    o.filled,
+		TotalSize:         o.req.Quantity,
+		Status:            string(o.state),
+		OrigOrderType:     o.req.OrderType,
+		OrderType:         o.req.OrderType,
+		OrderRef:          o.req.COID,
+		TimeInForce:       o.req.TIF,
+		Side:              strings.ToUpper(o.req.Side),
+	}
+	if o.filled > 0 {
+		ord.AvgPrice = formatAmount(o.avgPrice)
+		ord.LastExecutionTime = o.lastFill.UTC().Format("060102150405")
+		ord.LastExecutionTimeR = o.lastFill.UnixMilli()
+	}
+	return ord
+}
+
+// ListTrades returns every execution, oldest first. The query is ignored.
+func (p *PaperBroker) ListTrades(ctx context.Context, query url.Values) ([]Trade, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.trades), nil
+}
+
+type paperPositionKey struct {
+	account string
+	conid   int
+}
+
+type paperPosition struct {
+	qty      float64
+	avg      float64
+	realized float64
+}
+
+// add applies an execution of signed quantity qty at price.
+func (pos *paperPosition) add(qty, price float64) {
+	if pos.qty == 0 || (pos.qty > 0) == (qty > 0) {
+		pos.avg = (pos.avg*math.Abs(pos.qty) + price*math.Abs(qty)) / (math.Abs(pos.qty) + math.Abs(qty))
+		pos.qty += qty
+		return
+	}
+	closing := math.Min(math.Abs(qty), math.Abs(pos.qty))
+	if pos.qty > 0 {
+		pos.realized += closing * (price - pos.avg)
+	} else {
+		pos.realized += closing * (pos.avg - price)
+	}
+	flipped := math.Abs(qty) > math.Abs(pos.qty)
+	pos.qty += qty
+	switch {
+	case pos.qty == 0:
+		pos.avg = 0
+	case flipped:
+		pos.avg = price
+	}
+}
+
+// Positions returns the account's positions, including closed ones with
+// realized P&L, valued at the latest quote. It never returns an error; the
+// signature is PositionSource's, so that a RiskGuard can check orders sent to
+// the broker against them.
+func (p *PaperBroker) Positions(ctx context.Context, accountID string) ([]Position, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var positions []Position
+	for key, pos := range p.positions {
+		if key.account != accountID {
+			continue
+		}
+		position := Position{
+			Position:    pos.qty,
+			ContractID:  int64(key.conid),
+			AvgCost:     pos.avg,
+			AvgPrice:    pos.avg,
+			RealizedPnL: pos.realized,
+		}
+		if price, ok := referencePrice(p.quotes[key.conid]); ok {
+			position.MarketPrice = price
+			position.MarketValue = pos.qty * price
+			position.UnrealizedPnL = pos.qty * (price - pos.avg)
+		}
+		positions = append(positions, position)
+	}
+	slices.SortFunc(positions, func(a, b Position) int {
+		return cmp.Compare(a.ContractID, b.ContractID)
+	})
+	return positions, nil
+}
+
+// LatestQuote returns the latest quote the broker has for the contract, from
+// its QuoteBook or the last ApplyQuote, so that a RiskGuard in front of the
+// broker prices orders from the same quotes they fill against. A contract
+// with no quote returns a Quote with no prices.
+func (p *PaperBroker) LatestQuote(ctx context.Context, conid int) (Quote, error) {
+	if p.book != nil {
+		if st, ok := p.book.Get(conid); ok {
+			return st.Quote(), nil
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if q, ok := p.quotes[conid]; ok {
+		return q, nil
+	}
+	return Quote{Conid: conid}, nil
+}
+
+// checkPaperOrder rejects orders the broker cannot simulate.
+func checkPaperOrder(o OrderRequest) error {
+	if orderConid(o) == 0 {
+		return fmt.Errorf("no conid given")
+	}
+	if !strings.EqualFold(o.Side, "BUY") && !strings.EqualFold(o.Side, "SELL") {
+		return fmt.Errorf("side %q is not BUY or SELL", o.Side)
+	}
+	if o.Quantity <= 0 {
+		return fmt.Errorf("quantity %s is not positive; the paper broker does not support CashQty", formatAmount(o.Quantity))
+	}
+	switch strings.ToUpper(o.OrderType) {
+	case "MKT":
+	case "LMT", "STP":
+		if o.Price <= 0 {
+			return fmt.Errorf("%s order has no price", o.OrderType)
+		}
+	case "STOP_LIMIT":
+		if o.Price <= 0 || o.AuxPrice <= 0 {
+			return fmt.Errorf("STOP_LIMIT order needs a limit price and a stop price")
+		}
+	default:
+		return fmt.Errorf("order type %q is not supported by the paper broker", o.OrderType)
+	}
+	return nil
+}
+
+// paperError returns an error placement, as the gateway does when it rejects
+// an order.
+func paperError(msg string) ([]OrderPlacement, error) {
+	return []OrderPlacement{{Error: msg}}, fmt.Errorf("ibclientportal: paper broker: %s", msg)
+}
@@ -0,0 +1,338 @@
+package ibclientportal
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func paperQuote(conid int, bid, ask, last, size float64) Quote {
+	return Quote{
+		Conid:   conid,
+		Bid:     livePrice(bid),
+		Ask:     livePrice(ask),
+		Last:    livePrice(last),
+		BidSize: Number{Value: size, Valid: true},
+		AskSize: Number{Value: size, Valid: true},
+	}
+}
+
+func TestPaperBrokerLifecycle(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewPaperBroker(nil, PaperOptions{
+		Questions: []PaperQuestion{{
+			MessageID: "o163",
+			Message:   "The following order exceeds the price percentage limit",
+			When:      func(o OrderRequest) bool { return o.OrderType == "MKT" },
+		}},
+		CommissionPerShare: 0.005,
+		MinCommission:      1,
+	})
+	var backend OrderBackend = broker
+
+	// An unknown question is left unanswered, as with the gateway.
+	order := []OrderRequest{{Conid: 265598, Ticker: "AAPL", COID: "buy-1", OrderType: "MKT", Side: "BUY", TIF: "DAY", Quantity: 300}}
+	_, err := backend.PlaceAndConfirm(ctx, "DU1", order, ConfirmPolicy{})
+	var unknown *UnknownQuestionError
+	if !errors.As(err, &unknown) || unknown.MessageIDs[0] != "o163" {
+		t.Fatalf("expected an unknown question error for o163, got %v", err)
+	}
+	res, err := backend.PlaceAndConfirm(ctx, "DU1", order, ConfirmPolicy{Accept: []string{"o163"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Placed) != 1 || len(res.Transcript) != 1 || res.Placed[0].LocalOrderID != "buy-1" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	id := string(res.Placed[0].OrderID)
+
+	// 100 shares are offered on each quote, so the order fills in three
+	// parts.
+	broker.ApplyQuote(paperQuote(265598, 189.9, 190, 190, 100))
+	st, err := backend.OrderStatus(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State() != OrderStateSubmitted || st.Filled != 100 || st.Remaining != 200 || st.SizeAndFills != "100/300" {
+		t.Errorf("after one quote: %+v", st)
+	}
+	done := make(chan OrderStatus, 1)
+	go func() {
+		st, err := backend.WaitForOrder(ctx, id, UntilFilled)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- st
+	}()
+	broker.ApplyQuote(paperQuote(265598, 190, 190.1, 190.1, 100))
+	broker.ApplyQuote(paperQuote(265598, 190.1, 190.2, 190.2, 100))
+	select {
+	case st = <-done:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the fill")
+	}
+	if st.Filled != 300 || st.AvgPrice < 190.09 || st.AvgPrice > 190.11 {
+		t.Errorf("final status %+v", st)
+	}
+
+	trades, err := backend.ListTrades(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 3 {
+		t.Fatalf("got %d trades, want 3", len(trades))
+	}
+	if price, _ := trades[1].FillPrice(); price != 190.1 || trades[1].Side != "B" || trades[1].Size != 100 {
+		t.Errorf("unexpected trade %+v", trades[1])
+	}
+	if c, _ := trades[0].CommissionAmount(); c != 1 {
+		t.Errorf("commission = %v, want the minimum of 1", c)
+	}
+	pos, _ := broker.Positions(ctx, "DU1")
+	if len(pos) != 1 || pos[0].Position != 300 || pos[0].MarketPrice != 190.2 {
+		t.Errorf("positions = %+v", pos)
+	}
+
+	// A limit sell below the market fills at the bid; the cancelled one
+	// does not.
+	placed, err := backend.PlaceOrders(ctx, "DU1", []OrderRequest{{Conid: 265598, OrderType: "LMT", Side: "SELL", Quantity: 100, Price: 195}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limitID := string(placed[0].OrderID)
+	if _, err := backend.ModifyOrder(ctx, "DU1", limitID, OrderRequest{Conid: 265598, OrderType: "LMT", Side: "SELL", Quantity: 100, Price: 189}); err != nil {
+		t.Fatal(err)
+	}
+	placed, err = backend.PlaceOrders(ctx, "DU1", []OrderRequest{{Conid: 265598, OrderType: "LMT", Side: "SELL", Quantity: 100, Price: 180}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelID := string(placed[0].OrderID)
+	if _, err := backend.CancelOrder(ctx, "DU1", cancelID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.CancelOrder(ctx, "DU1", cancelID); err == nil {
+		t.Error("expected an error cancelling a cancelled order")
+	}
+	broker.ApplyQuote(paperQuote(265598, 190, 190.1, 190, 500))
+	if st, _ := backend.OrderStatus(ctx, limitID); st.State() != OrderStateFilled || st.AvgPrice != 190 {
+		t.Errorf("modified limit order: %+v", st)
+	}
+	// Sold at 190 against an average cost of 190.1.
+	if pos, _ := broker.Positions(ctx, "DU1"); pos[0].Position != 200 || pos[0].RealizedPnL > -9.99 || pos[0].RealizedPnL < -10.01 {
+		t.Errorf("positions after selling = %+v", pos)
+	}
+
+	resp, err := backend.ListOrders(ctx, url.Values{"filters": {"cancelled"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Orders) != 1 || resp.Orders[0].State() != OrderStateCancelled {
+		t.Errorf("cancelled orders = %+v", resp.Orders)
+	}
+	resp, _ = backend.ListOrders(ctx, nil)
+	if len(resp.Orders) != 3 {
+		t.Errorf("listed %d orders, want 3", len(resp.Orders))
+	}
+}
+
+func TestPaperBrokerBracket(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewPaperBroker(nil, PaperOptions{})
+	orders, err := NewBracket(OrderRequest{
+		Conid: 265598, COID: "entry", OrderType: "LMT", Side: "BUY", TIF: "GTC", Quantity: 10, Price: 190,
+	}, 200, 185)
+	if err != nil {
+		t.Fatal(err)
+	}
+	placed, err := broker.PlaceOrders(ctx, "DU1", orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed[0].OrderStatus != "Submitted" || placed[1].OrderStatus != "PreSubmitted" {
+		t.Fatalf("unexpected placements %+v", placed)
+	}
+	// The stop-loss's stop price is reached, but it only works once the
+	// entry has filled.
+	broker.ApplyQuote(paperQuote(265598, 184, 184.1, 184, 100))
+	entry, _ := broker.OrderStatus(ctx, string(placed[0].OrderID))
+	if entry.State() != OrderStateFilled {
+		t.Fatalf("entry = %+v", entry)
+	}
+	if st, _ := broker.OrderStatus(ctx, string(placed[2].OrderID)); st.Filled != 0 {
+		t.Errorf("stop-loss filled on the quote that filled its parent: %+v", st)
+	}
+	broker.ApplyQuote(paperQuote(265598, 183.9, 184, 184, 100))
+	sl, _ := broker.OrderStatus(ctx, string(placed[2].OrderID))
+	tp, _ := broker.OrderStatus(ctx, string(placed[1].OrderID))
+	if sl.State() != OrderStateFilled || sl.AvgPrice != 183.9 {
+		t.Errorf("stop-loss = %+v", sl)
+	}
+	if tp.State() != OrderStateCancelled {
+		t.Errorf("take-profit = %+v, want it cancelled by the stop-loss", tp)
+	}
+	if pos, _ := broker.Positions(ctx, "DU1"); pos[0].Position != 0 || pos[0].RealizedPnL >= 0 {
+		t.Errorf("positions = %+v", pos)
+	}
+}
+
+// A partial fill of one exit reduces the other rather than cancelling it.
+func TestPaperBrokerBracketPartialExit(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewPaperBroker(nil, PaperOptions{})
+	orders, err := NewBracket(OrderRequest{
+		Conid: 265598, COID: "entry", OrderType: "MKT", Side: "BUY", TIF: "DAY", Quantity: 10,
+	}, 200, 185)
+	if err != nil {
+		t.Fatal(err)
+	}
+	placed, err := broker.PlaceOrders(ctx, "DU1", orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker.ApplyQuote(paperQuote(265598, 190, 190.1, 190, 100))
+	// Only 4 shares are bid when the stop triggers.
+	broker.ApplyQuote(paperQuote(265598, 184, 184.1, 184, 4))
+	sl, _ := broker.OrderStatus(ctx, string(placed[2].OrderID))
+	tp, _ := broker.OrderStatus(ctx, string(placed[1].OrderID))
+	if sl.State() != OrderStateSubmitted || sl.Filled != 4 {
+		t.Errorf("stop-loss = %+v, want 4 filled", sl)
+	}
+	if tp.State() != OrderStateSubmitted || tp.TotalSize != 6 || tp.Remaining != 6 {
+		t.Errorf("take-profit = %+v, want it reduced to 6", tp)
+	}
+	broker.ApplyQuote(paperQuote(265598, 183.9, 184, 184, 100))
+	sl, _ = broker.OrderStatus(ctx, string(placed[2].OrderID))
+	tp, _ = broker.OrderStatus(ctx, string(placed[1].OrderID))
+	if sl.State() != OrderStateFilled || tp.State() != OrderStateCancelled {
+		t.Errorf("after the stop-loss filled: stop-loss %+v, take-profit %+v", sl, tp)
+	}
+	if pos, _ := broker.Positions(ctx, "DU1"); pos[0].Position != 0 {
+		t.Errorf("positions = %+v", pos)
+	}
+}
+
+func TestPaperBrokerQuoteBook(t *testing.T) {
+	updates := make(chan MarketDataUpdate)
+	book := NewQuoteBook(&chanSource{ch: updates})
+	broker := NewPaperBroker(book, PaperOptions{})
+	defer broker.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	placed, err := broker.PlaceOrders(ctx, "DU1", []OrderRequest{{Conid: 265598, OrderType: "LMT", Side: "BUY", Quantity: 10, Price: 189}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates <- testUpdate(265598, `{"31":"190.00","84":"189.99","86":"190.01"}`)
+	updates <- testUpdate(265598, `{"84":"188.50","86":"188.70"}`)
+	st, err := broker.WaitForOrder(ctx, string(placed[0].OrderID), UntilFilled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.AvgPrice != 188.7 {
+		t.Errorf("filled at %v, want the ask of 188.70", st.AvgPrice)
+	}
+	close(updates)
+}
+
+// A market order placed on a contract the book already holds waits for the
+// next update rather than filling at the price it was placed against.
+func TestPaperBrokerQuoteBookStale(t *testing.T) {
+	updates := make(chan MarketDataUpdate)
+	book := NewQuoteBook(&chanSource{ch: updates})
+	broker := NewPaperBroker(book, PaperOptions{})
+	defer broker.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updates <- testUpdate(265598, `{"31":"190.00","84":"189.99","86":"190.01"}`)
+	for {
+		if _, ok := book.Get(265598); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	placed, err := broker.PlaceOrders(ctx, "DU1", []OrderRequest{{Conid: 265598, OrderType: "MKT", Side: "BUY", Quantity: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Let the broker see the book's state before it changes.
+	for {
+		broker.mu.Lock()
+		_, seen := broker.quotes[265598]
+		broker.mu.Unlock()
+		if seen {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	updates <- testUpdate(265598, `{"84":"195.00","86":"195.10"}`)
+	st, err := broker.WaitForOrder(ctx, string(placed[0].OrderID), UntilFilled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.AvgPrice != 195.1 {
+		t.Errorf("filled at %v, want the new ask of 195.10", st.AvgPrice)
+	}
+	close(updates)
+}
+
+func TestPaperBrokerRejects(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewPaperBroker(nil, PaperOptions{})
+	for _, o := range []OrderRequest{
+		{Conid: 1, OrderType: "TRAIL", Side: "BUY", Quantity: 1},
+		{Conid: 1, OrderType: "LMT", Side: "BUY", Quantity: 1},
+		{Conid: 1, OrderType: "MKT", Side: "BUY", CashQty: 1000},
+		{OrderType: "MKT", Side: "BUY", Quantity: 1},
+	} {
+		placed, err := broker.PlaceOrders(ctx, "DU1", []OrderRequest{o})
+		if err == nil || len(placed) != 1 || placed[0].Error == "" {
+			t.Errorf("PlaceOrders(%+v) = %+v, %v; want an error placement", o, placed, err)
+		}
+	}
+	ok := []OrderRequest{{Conid: 1, COID: "a", OrderType: "MKT", Side: "BUY", Quantity: 1}}
+	if _, err := broker.PlaceOrders(ctx, "DU1", ok); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broker.PlaceOrders(ctx, "DU1", ok); err == nil {
+		t.Error("expected an error reusing a COID")
+	}
+}
+
+// A RiskGuard in front of the broker prices orders from the broker's quotes
+// and checks them against its positions.
+func TestPaperBrokerRiskGuard(t *testing.T) {
+	ctx := testContext(t)
+	broker := NewPaperBroker(nil, PaperOptions{})
+	var backend OrderBackend = NewRiskGuard(broker, nil, RiskLimits{MaxPosition: 15, MaxNotional: 2500})
+	broker.ApplyQuote(paperQuote(265598, 189.9, 190, 190, 100))
+
+	order := func(qty float64) []OrderRequest {
+		return []OrderRequest{{Conid: 265598, OrderType: "MKT", Side: "BUY", Quantity: qty}}
+	}
+	res, err := backend.PlaceAndConfirm(ctx, "DU1", order(10), ConfirmPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker.ApplyQuote(paperQuote(265598, 189.9, 190, 190, 100))
+	if st, _ := backend.OrderStatus(ctx, string(res.Placed[0].OrderID)); st.State() != OrderStateFilled {
+		t.Fatalf("order = %+v", st)
+	}
+	_, err = backend.PlaceAndConfirm(ctx, "DU1", order(14), ConfirmPolicy{})
+	var rerr *RiskError
+	if !errors.As(err, &rerr) || !rerr.Has(RuleMaxPosition) || !rerr.Has(RuleMaxNotional) {
+		t.Fatalf("expected position and notional violations, got %v", err)
+	}
+	if resp, _ := backend.ListOrders(ctx, nil); len(resp.Orders) != 1 {
+		t.Errorf("listed %d orders, want only the first", len(resp.Orders))
+	}
+
+	margin := NewRiskGuard(broker, nil, RiskLimits{CheckMargin: true})
+	if _, err := margin.PlaceOrders(ctx, "DU1", order(1)); err == nil {
+		t.Error("expected an error checking margin without the gateway")
+	}
+}
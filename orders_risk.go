@@ -0,0 +1,625 @@
+package ibclientportal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RiskLimits are the pre-trade limits a RiskGuard enforces. A zero limit is
+// not checked.
+type RiskLimits struct {
+	// MaxNotional is the most a single order may be worth: its quantity
+	// times its limit or stop price, or times the quoted price for an order
+	// without one. A CashQty order is worth its CashQty.
+	MaxNotional float64
+	// MaxPosition is the largest position, long or short, in any one
+	// contract once the orders have filled. PositionLimits overrides it for
+	// particular conids. A CashQty order counts as its cash quantity over the
+	// quoted price, and is blocked if there is no price.
+	MaxPosition    float64
+	PositionLimits map[int]float64
+	// MaxAccountExposure is the largest gross market value of the account's
+	// positions, long plus short, once the orders have filled.
+	MaxAccountExposure float64
+	// PriceCollar is the furthest an order's price may be from the quoted
+	// price, as a fraction of it: 0.05 allows 5% either way.
+	PriceCollar float64
+	// MaxOrdersPerMinute is how many orders, counting modifications, may be
+	// sent in any 60 seconds.
+	MaxOrdersPerMinute int
+	// RestrictedSymbols and RestrictedConids may not be traded. Symbols are
+	// matched, ignoring case, against OrderRequest.Ticker and the symbol the
+	// QuoteSource gives for the conid, which is looked up when the order has
+	// no Ticker. An order whose symbol cannot be found is blocked.
+	RestrictedSymbols []string
+	RestrictedConids  []int
+	// CheckMargin previews the orders with WhatIf and blocks them if the
+	// equity left over the initial margin requirement afterwards would be
+	// below MinExcessMargin.
+	CheckMargin     bool
+	MinExcessMargin float64
+}
+
+// RiskRule identifies one of the limits in RiskLimits.
+type RiskRule int
+
+const (
+	// RuleMaxNotional is RiskLimits.MaxNotional.
+	RuleMaxNotional RiskRule = iota
+	// RuleMaxPosition is RiskLimits.MaxPosition or PositionLimits.
+	RuleMaxPosition
+	// RuleMaxAccountExposure is RiskLimits.MaxAccountExposure.
+	RuleMaxAccountExposure
+	// RulePriceCollar is RiskLimits.PriceCollar.
+	RulePriceCollar
+	// RuleMaxOrdersPerMinute is RiskLimits.MaxOrdersPerMinute.
+	RuleMaxOrdersPerMinute
+	// RuleRestricted is RiskLimits.RestrictedSymbols and RestrictedConids.
+	RuleRestricted
+	// RuleMargin is RiskLimits.CheckMargin.
+	RuleMargin
+)
+
+func (r RiskRule) String() string {
+	switch r {
+	case RuleMaxNotional:
+		return "max notional"
+	case RuleMaxPosition:
+		return "max position"
+	case RuleMaxAccountExposure:
+		return "max account exposure"
+	case RulePriceCollar:
+		return "price collar"
+	case RuleMaxOrdersPerMinute:
+		return "max orders per minute"
+	case RuleRestricted:
+		return "restricted"
+	case RuleMargin:
+		return "margin"
+	default:
+		return fmt.Sprintf("RiskRule(%d)", int(r))
+	}
+}
+
+// RiskViolation is one limit an order would breach.
+type RiskViolation struct {
+	Rule RiskRule
+	// Order is the index of the offending order in the request, or -1 when
+	// the violation is the request's as a whole, as for the account's
+	// exposure or margin.
+	Order int
+	// Conid is the contract concerned, or 0 for the account as a whole.
+	Conid   int
+	Message string
+}
+
+func (v RiskViolation) String() string {
+	return v.Rule.String() + ": " + v.Message
+}
+
+// RiskError is returned by a RiskGuard when orders breach its limits. Nothing
+// was sent to the gateway.
+type RiskError struct {
+	// Violations lists every limit breached, not only the first.
+	Violations []RiskViolation
+}
+
+func (e *RiskError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return "ibclientportal: orders breach risk limits: " + strings.Join(msgs, "; ")
+}
+
+// Has reports whether any violation is of the given rule.
+func (e *RiskError) Has(rule RiskRule) bool {
+	for _, v := range e.Violations {
+		if v.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// QuoteSource supplies the quotes a RiskGuard values orders and sets its
+// price collar with. *MarketDataService takes a snapshot; *QuoteBook reads
+// the latest streamed quote.
+type QuoteSource interface {
+	LatestQuote(ctx context.Context, conid int) (Quote, error)
+}
+
+// riskQuoteFields are the fields a RiskGuard prices orders from.
+var riskQuoteFields = []string{FieldLastPrice, FieldBidPrice, FieldAskPrice, FieldMark, FieldSymbol}
+
+// LatestQuote takes a snapshot of the contract's prices with SnapshotUntil.
+// A contract that never reports a price returns a Quote with none, not an
+// error.
+func (m *MarketDataService) LatestQuote(ctx context.Context, conid int) (Quote, error) {
+	res, err := m.SnapshotUntil(ctx, []int{conid}, riskQuoteFields, SnapshotPolicy{})
+	if err != nil {
+		return Quote{Conid: conid}, err
+	}
+	if len(res.Snapshots) == 0 {
+		return Quote{Conid: conid}, nil
+	}
+	return res.Snapshots[0].Quote(), nil
+}
+
+// LatestQuote returns the contract's latest quote from the book. A contract
+// the stream has not reported returns a Quote with no prices.
+func (b *QuoteBook) LatestQuote(ctx context.Context, conid int) (Quote, error) {
+	st, ok := b.Get(conid)
+	if !ok {
+		return Quote{Conid: conid}, nil
+	}
+	return st.Quote(), nil
+}
+
+// referencePrice returns the price a quote says a contract is trading at: the
+// last price if it is live, otherwise the midpoint of the bid and ask.
+func referencePrice(q Quote) (float64, bool) {
+	if q.Last.Live() && q.Last.Value > 0 {
+		return q.Last.Value, true
+	}
+	if q.Bid.Live() && q.Ask.Live() && q.Bid.Value > 0 && q.Ask.Value >= q.Bid.Value {
+		return (q.Bid.Value + q.Ask.Value) / 2, true
+	}
+	return 0, false
+}
+
+// PositionSource supplies the positions a RiskGuard checks position and
+// exposure limits against. *PaperBroker implements it with its simulated
+// positions.
+type PositionSource interface {
+	Positions(ctx context.Context, accountID string) ([]Position, error)
+}
+
+// portfolioPositions is a PositionSource reading the gateway's positions.
+type portfolioPositions struct {
+	portfolio *PortfolioService
+}
+
+func (p portfolioPositions) Positions(ctx context.Context, accountID string) ([]Position, error) {
+	return p.portfolio.ListPositions(ctx, accountID, nil)
+}
+
+// RiskGuard checks orders against RiskLimits before passing them to an
+// OrderBackend. Every limit is checked before anything is sent, and an order
+// that breaches any of them is not sent at all; the *RiskError lists each
+// breach. RiskGuard is itself an OrderBackend: orders are checked on their way
+// to PlaceOrders, ModifyOrder and PlaceAndConfirm, and the other methods pass
+// straight through. It is safe for concurrent use.
+//
+// With an *OrdersService, positions come from PortfolioService.ListPositions,
+// which IB updates with a short delay, so two orders sent in quick succession
+// are each checked against the position before either filled. Exit orders
+// attached to a parent with ParentID are left out of the position checks,
+// since they only close what the parent opens.
+type RiskGuard struct {
+	orders    OrderBackend
+	quotes    QuoteSource
+	positions PositionSource
+	limits    RiskLimits
+	now       func() time.Time
+
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+var _ OrderBackend = (*RiskGuard)(nil)
+
+// NewRiskGuard returns a RiskGuard sending orders through orders and pricing
+// them from quotes. If quotes is nil, orders supplies them if it is a
+// QuoteSource, as *PaperBroker is; an *OrdersService takes snapshots with its
+// client. Positions come from orders if it is a PositionSource, and from the
+// client's PortfolioService for an *OrdersService. CheckMargin needs an
+// *OrdersService, for its WhatIf preview.
+func NewRiskGuard(orders OrderBackend, quotes QuoteSource, limits RiskLimits) *RiskGuard {
+	g := &RiskGuard{orders: orders, quotes: quotes, limits: limits, now: time.Now}
+	if o, ok := orders.(*OrdersService); ok {
+		g.positions = portfolioPositions{o.client.Portfolio}
+		if g.quotes == nil {
+			g.quotes = o.client.MarketData
+		}
+	}
+	if ps, ok := orders.(PositionSource); ok {
+		g.positions = ps
+	}
+	if qs, ok := orders.(QuoteSource); ok && g.quotes == nil {
+		g.quotes = qs
+	}
+	return g
+}
+
+// PlaceOrders checks the orders against the guard's limits and, if none is
+// breached, places them with the backend.
+func (g *RiskGuard) PlaceOrders(ctx context.Context, accountID string, orders []OrderRequest) ([]OrderPlacement, error) {
+	if err := g.check(ctx, accountID, orders, true); err != nil {
+		return nil, err
+	}
+	return g.orders.PlaceOrders(ctx, accountID, orders)
+}
+
+// PlaceAndConfirm checks the orders against the guard's limits and, if none
+// is breached, places them and answers IB's questions with the backend's
+// PlaceAndConfirm.
+func (g *RiskGuard) PlaceAndConfirm(ctx context.Context, accountID string, orders []OrderRequest, policy ConfirmPolicy) (ConfirmResult, error) {
+	if err := g.check(ctx, accountID, orders, true); err != nil {
+		return ConfirmResult{}, err
+	}
+	return g.orders.PlaceAndConfirm(ctx, accountID, orders, policy)
+}
+
+// ModifyOrder checks the order's new terms against the guard's limits and, if
+// none is breached, sends them with the backend. The new terms are checked as
+// if none of the order had filled yet.
+func (g *RiskGuard) ModifyOrder(ctx context.Context, accountID, orderID string, order OrderRequest) ([]OrderPlacement, error) {
+	if orderID == "" {
+		return nil, fmt.Errorf("ibclientportal: ModifyOrder: no order ID given")
+	}
+	if err := g.check(ctx, accountID, []OrderRequest{order}, true); err != nil {
+		return nil, err
+	}
+	return g.orders.ModifyOrder(ctx, accountID, orderID, order)
+}
+
+// ConfirmOrder answers a question with the backend. The order it is about
+// was checked when it was placed.
+func (g *RiskGuard) ConfirmOrder(ctx context.Context, replyID string, confirmed bool) ([]OrderPlacement, error) {
+	return g.orders.ConfirmOrder(ctx, replyID, confirmed)
+}
+
+// CancelOrder cancels an order with the backend.
+func (g *RiskGuard) CancelOrder(ctx context.Context, accountID, orderID string) (CancelOrderResponse, error) {
+	return g.orders.CancelOrder(ctx, accountID, orderID)
+}
+
+// OrderStatus returns an order's status from the backend.
+func (g *RiskGuard) OrderStatus(ctx context.Context, orderID string) (OrderStatus, error) {
+	return g.orders.OrderStatus(ctx, orderID)
+}
+
+// WaitForOrder waits for an order with the backend.
+func (g *RiskGuard) WaitForOrder(ctx context.Context, orderID string, until WaitUntil) (OrderStatus, error) {
+	return g.orders.WaitForOrder(ctx, orderID, until)
+}
+
+// ListOrders lists orders from the backend.
+func (g *RiskGuard) ListOrders(ctx context.Context, query url.Values) (OrdersResponse, error) {
+	return g.orders.ListOrders(ctx, query)
+}
+
+// ListTrades lists trades from the backend.
+func (g *RiskGuard) ListTrades(ctx context.Context, query url.Values) ([]Trade, error) {
+	return g.orders.ListTrades(ctx, query)
+}
+
+// Check reports whether the orders would pass the guard's limits, without
+// sending them or counting them against MaxOrdersPerMinute. It returns a
+// *RiskError if any limit is breached.
+func (g *RiskGuard) Check(ctx context.Context, accountID string, orders []OrderRequest) error {
+	return g.check(ctx, accountID, orders, false)
+}
+
+// riskOrder is an order under check, with what the guard has found out
+// about it.
+type riskOrder struct {
+	OrderRequest
+	index int
+	conid int
+	quote Quote
+	// price is what one unit of the contract is valued at; priced is false
+	// if there is nothing to value it at.
+	price  float64
+	priced bool
+}
+
+// check runs every check and, when send is set and nothing is breached,
+// counts the orders as sent.
+func (g *RiskGuard) check(ctx context.Context, accountID string, orders []OrderRequest, send bool) error {
+	if accountID == "" {
+		return fmt.Errorf("ibclientportal: RiskGuard: no account ID given")
+	}
+	if len(orders) == 0 {
+		return fmt.Errorf("ibclientportal: RiskGuard: no orders given")
+	}
+	lim := g.limits
+	var violations []RiskViolation
+	add := func(rule RiskRule, index, conid int, format string, args ...any) {
+		violations = append(violations, RiskViolation{Rule: rule, Order: index, Conid: conid, Message: fmt.Sprintf(format, args...)})
+	}
+
+	ros := make([]riskOrder, len(orders))
+	// A position limit needs a price too, to turn a cash quantity into
+	// shares.
+	needQuote := lim.MaxNotional > 0 || lim.MaxAccountExposure > 0 || lim.PriceCollar > 0 ||
+		lim.MaxPosition > 0 || len(lim.PositionLimits) > 0
+	for i, o := range orders {
+		ro := riskOrder{OrderRequest: o, index: i, conid: orderConid(o)}
+		if ro.conid == 0 {
+			return fmt.Errorf("ibclientportal: RiskGuard: order %d has no conid", i)
+		}
+		// The restricted list is by symbol, and the conid is what identifies
+		// the contract, so an order without a Ticker has its symbol looked up.
+		if needQuote || (len(lim.RestrictedSymbols) > 0 && o.Ticker == "") {
+			if g.quotes == nil {
+				return errors.New("ibclientportal: RiskGuard: no quote source to price orders with")
+			}
+			q, err := g.quotes.LatestQuote(ctx, ro.conid)
+			if err != nil {
+				return fmt.Errorf("ibclientportal: RiskGuard: quoting %d: %w", ro.conid, err)
+			}
+			ro.quote = q
+		}
+		ro.price, ro.priced = orderPrice(o, ro.quote)
+		ros[i] = ro
+	}
+
+	for _, ro := range ros {
+		switch isRestricted, known := restricted(lim, ro); {
+		case isRestricted:
+			add(RuleRestricted, ro.index, ro.conid, "order %d: %s is restricted", ro.index, contractName(ro))
+		case !known:
+			add(RuleRestricted, ro.index, ro.conid, "order %d: no symbol for conid %d to check against the restricted list", ro.index, ro.conid)
+		}
+		if lim.MaxNotional > 0 {
+			if n, ok := ro.notional(); !ok {
+				add(RuleMaxNotional, ro.index, ro.conid, "order %d: no price to value %s at", ro.index, contractName(ro))
+			} else if n > lim.MaxNotional {
+				add(RuleMaxNotional, ro.index, ro.conid, "order %d: %s is worth %s, over the limit of %s", ro.index, contractName(ro), formatAmount(n), formatAmount(lim.MaxNotional))
+			}
+		}
+		if lim.PriceCollar > 0 && ro.Price > 0 {
+			ref, ok := referencePrice(ro.quote)
+			if !ok {
+				add(RulePriceCollar, ro.index, ro.conid, "order %d: no live quote for %s", ro.index, contractName(ro))
+			} else if dev := math.Abs(ro.Price-ref) / ref; dev > lim.PriceCollar {
+				add(RulePriceCollar, ro.index, ro.conid, "order %d: price %s is %.2f%% from the quote of %s, over the limit of %.2f%%", ro.index, formatAmount(ro.Price), dev*100, formatAmount(ref), lim.PriceCollar*100)
+			}
+		}
+	}
+
+	if lim.MaxPosition > 0 || len(lim.PositionLimits) > 0 || lim.MaxAccountExposure > 0 {
+		if g.positions == nil {
+			return errors.New("ibclientportal: RiskGuard: no position source to check position limits with")
+		}
+		positions, err := g.positions.Positions(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("ibclientportal: RiskGuard: listing positions: %w", err)
+		}
+		violations = append(violations, checkPositions(lim, ros, positions)...)
+	}
+
+	if lim.CheckMargin {
+		o, ok := g.orders.(*OrdersService)
+		if !ok {
+			return errors.New("ibclientportal: RiskGuard: CheckMargin needs an *OrdersService to preview orders with")
+		}
+		resp, err := o.WhatIf(ctx, accountID, orders)
+		if err != nil {
+			return fmt.Errorf("ibclientportal: RiskGuard: %w", err)
+		}
+		impact, err := resp.MarginImpact()
+		if err != nil {
+			return fmt.Errorf("ibclientportal: RiskGuard: %w", err)
+		}
+		if excess := impact.ExcessAfter(); excess < lim.MinExcessMargin {
+			add(RuleMargin, -1, 0, "equity over initial margin would be %s, under the minimum of %s", formatAmount(excess), formatAmount(lim.MinExcessMargin))
+		}
+	}
+
+	// The rate is checked last, under the lock, so that an accepted request
+	// is counted before another can slip in.
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if lim.MaxOrdersPerMinute > 0 {
+		now := g.now()
+		cutoff := now.Add(-time.Minute)
+		recent := g.sent[:0]
+		for _, t := range g.sent {
+			if t.After(cutoff) {
+				recent = append(recent, t)
+			}
+		}
+		g.sent = recent
+		if n := len(recent) + len(orders); n > lim.MaxOrdersPerMinute {
+			add(RuleMaxOrdersPerMinute, -1, 0, "%d orders in the last minute, over the limit of %d", n, lim.MaxOrdersPerMinute)
+		} else if send && len(violations) == 0 {
+			for range orders {
+				g.sent = append(g.sent, now)
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return &RiskError{Violations: violations}
+	}
+	return nil
+}
+
+// checkPositions checks the positions the orders could leave the account
+// with. Buys and sells in the same contract are not netted against each
+// other: either side might fill without the other.
+func checkPositions(lim RiskLimits, ros []riskOrder, positions []Position) []RiskViolation {
+	var violations []RiskViolation
+	current := make(map[int]Position)
+	for _, p := range positions {
+		current[int(p.ContractID)] = p
+	}
+	type exposure struct {
+		buys, sells float64
+		price       float64
+		priced      bool
+		first       riskOrder
+	}
+	byConid := make(map[int]*exposure)
+	var conids []int
+	for _, ro := range ros {
+		if ro.ParentID != "" {
+			continue
+		}
+		e, ok := byConid[ro.conid]
+		if !ok {
+			e = &exposure{first: ro}
+			byConid[ro.conid] = e
+			conids = append(conids, ro.conid)
+		}
+		qty := ro.Quantity
+		if qty == 0 && ro.CashQty > 0 {
+			if ro.priced && ro.price > 0 {
+				qty = ro.CashQty / ro.price
+			} else if positionLimit(lim, ro.conid) > 0 {
+				violations = append(violations, RiskViolation{
+					Rule:    RuleMaxPosition,
+					Order:   ro.index,
+					Conid:   ro.conid,
+					Message: fmt.Sprintf("order %d: no price to value %s at", ro.index, contractName(ro)),
+				})
+			}
+		}
+		if strings.EqualFold(ro.Side, "SELL") {
+			e.sells += qty
+		} else {
+			e.buys += qty
+		}
+		if ro.priced && ro.price > e.price {
+			e.price, e.priced = ro.price, true
+		}
+	}
+
+	exposureKnown := true
+	gross := 0.0
+	for conid, p := range current {
+		if _, ok := byConid[conid]; !ok {
+			gross += math.Abs(p.MarketValue)
+		}
+	}
+	for _, conid := range conids {
+		e := byConid[conid]
+		held := current[conid].Position
+		worst := math.Max(math.Abs(held+e.buys), math.Abs(held-e.sells))
+		if limit := positionLimit(lim, conid); limit > 0 && worst > limit {
+			violations = append(violations, RiskViolation{
+				Rule:    RuleMaxPosition,
+				Order:   e.first.index,
+				Conid:   conid,
+				Message: fmt.Sprintf("%s position could reach %s, over the limit of %s", contractName(e.first), formatAmount(worst), formatAmount(limit)),
+			})
+		}
+		price := e.price
+		if !e.priced {
+			// Fall back to the position's own valuation.
+			if p, ok := current[conid]; ok && p.MarketPrice > 0 {
+				price = p.MarketPrice
+			} else {
+				exposureKnown = false
+			}
+		}
+		gross += worst * price
+	}
+	if lim.MaxAccountExposure > 0 {
+		switch {
+		case !exposureKnown:
+			violations = append(violations, RiskViolation{Rule: RuleMaxAccountExposure, Order: -1, Message: "no price to value the orders at"})
+		case gross > lim.MaxAccountExposure:
+			violations = append(violations, RiskViolation{
+				Rule:    RuleMaxAccountExposure,
+				Order:   -1,
+				Message: fmt.Sprintf("gross exposure could reach %s, over the limit of %s", formatAmount(gross), formatAmount(lim.MaxAccountExposure)),
+			})
+		}
+	}
+	return violations
+}
+
+// positionLimit returns the position limit for conid, or 0 for none.
+func positionLimit(lim RiskLimits, conid int) float64 {
+	if l, ok := lim.PositionLimits[conid]; ok {
+		return l
+	}
+	return lim.MaxPosition
+}
+
+// notional returns what the order is worth.
+func (ro riskOrder) notional() (float64, bool) {
+	if ro.Quantity == 0 && ro.CashQty > 0 {
+		return ro.CashQty, true
+	}
+	if !ro.priced {
+		return 0, false
+	}
+	return math.Abs(ro.Quantity) * ro.price, true
+}
+
+// orderPrice returns the price to value an order at: its own limit or stop
+// price if it has one, or else the side of the quote it would trade against.
+func orderPrice(o OrderRequest, q Quote) (float64, bool) {
+	if o.Price > 0 {
+		return o.Price, true
+	}
+	if o.AuxPrice > 0 {
+		return o.AuxPrice, true
+	}
+	side := q.Ask
+	if strings.EqualFold(o.Side, "SELL") {
+		side = q.Bid
+	}
+	if side.Live() && side.Value > 0 {
+		return side.Value, true
+	}
+	return referencePrice(q)
+}
+
+// orderConid returns the order's conid, from Conid or ConidEx.
+func orderConid(o OrderRequest) int {
+	if o.Conid != 0 {
+		return o.Conid
+	}
+	id, _, _ := strings.Cut(o.ConidEx, "@")
+	n, _ := strconv.Atoi(id)
+	return n
+}
+
+// restricted reports whether the order's contract is on the restricted
+// lists. known is false if there are restricted symbols but the order's
+// symbol could not be found, so the answer is not to be trusted.
+func restricted(lim RiskLimits, ro riskOrder) (isRestricted, known bool) {
+	if slices.Contains(lim.RestrictedConids, ro.conid) {
+		return true, true
+	}
+	if len(lim.RestrictedSymbols) == 0 {
+		return false, true
+	}
+	symbols := []string{ro.Ticker, ro.quote.Symbol}
+	for _, s := range lim.RestrictedSymbols {
+		for _, sym := range symbols {
+			if sym != "" && strings.EqualFold(s, sym) {
+				return true, true
+			}
+		}
+	}
+	return false, ro.Ticker != "" || ro.quote.Symbol != ""
+}
+
+// contractName names the order's contract for a violation message.
+func contractName(ro riskOrder) string {
+	if ro.Ticker != "" {
+		return ro.Ticker
+	}
+	if ro.quote.Symbol != "" {
+		return ro.quote.Symbol
+	}
+	return strconv.Itoa(ro.conid)
+}
+
+func formatAmount(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,201 @@
+package ibclientportal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeQuotes map[int]Quote
+
+func (f fakeQuotes) LatestQuote(ctx context.Context, conid int) (Quote, error) {
+	return f[conid], nil
+}
+
+func livePrice(v float64) Price {
+	return Price{Value: v, Valid: true}
+}
+
+// riskServer answers positions, WhatIf and order placement, counting the
+// orders that reach it.
+func riskServer(t *testing.T, positions, whatIf string) (*Client, *atomic.Int32, func()) {
+	t.Helper()
+	var placed atomic.Int32
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/api/portfolio2/U1/positions":
+			w.Write([]byte(positions))
+		case "/v1/api/iserver/account/U1/orders/whatif":
+			w.Write([]byte(whatIf))
+		case "/v1/api/iserver/account/U1/orders", "/v1/api/iserver/account/U1/order/42":
+			placed.Add(1)
+			w.Write([]byte(`[{"order_id":"1","order_status":"Submitted"}]`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	})
+	return client, &placed, server.Close
+}
+
+func TestRiskGuardListsEveryViolation(t *testing.T) {
+	client, placed, done := riskServer(t,
+		`[{"conid":"265598","position":80,"marketPrice":190,"marketValue":15200},{"conid":"8314","position":-10,"marketValue":-1500}]`,
+		`{"initial":{"change":"2000","after":"9000"},"maintenance":{"change":"1800","after":"8000"},"equity":{"after":"10000"}}`)
+	defer done()
+	quotes := fakeQuotes{265598: {Conid: 265598, Symbol: "AAPL", Last: livePrice(190)}}
+	guard := NewRiskGuard(client.Orders, quotes, RiskLimits{
+		MaxNotional:        10000,
+		MaxPosition:        100,
+		MaxAccountExposure: 20000,
+		PriceCollar:        0.05,
+		RestrictedSymbols:  []string{"aapl"},
+		CheckMargin:        true,
+		MinExcessMargin:    5000,
+	})
+	_, err := guard.PlaceOrders(testContext(t), "U1", []OrderRequest{{
+		Conid: 265598, OrderType: "LMT", Side: "BUY", Quantity: 60, Price: 210,
+	}})
+	var rerr *RiskError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected a *RiskError, got %v", err)
+	}
+	for _, rule := range []RiskRule{RuleRestricted, RuleMaxNotional, RulePriceCollar, RuleMaxPosition, RuleMaxAccountExposure, RuleMargin} {
+		if !rerr.Has(rule) {
+			t.Errorf("expected a %s violation in %v", rule, err)
+		}
+	}
+	if rerr.Has(RuleMaxOrdersPerMinute) {
+		t.Errorf("unexpected rate violation in %v", err)
+	}
+	if !strings.Contains(err.Error(), "AAPL position could reach 140, over the limit of 100") {
+		t.Errorf("unexpected error text %q", err)
+	}
+	if n := placed.Load(); n != 0 {
+		t.Errorf("%d requests reached the order endpoint, want 0", n)
+	}
+}
+
+func TestRiskGuardPasses(t *testing.T) {
+	client, placed, done := riskServer(t,
+		`[{"conid":"265598","position":80,"marketPrice":190,"marketValue":15200}]`, "")
+	defer done()
+	quotes := fakeQuotes{265598: {Conid: 265598, Bid: livePrice(189.9), Ask: livePrice(190.1)}}
+	guard := NewRiskGuard(client.Orders, quotes, RiskLimits{
+		MaxNotional: 10000,
+		MaxPosition: 100,
+		PriceCollar: 0.05,
+	})
+	// A market sell is valued at the bid, and a bracket's exits do not count
+	// towards the position.
+	orders := []OrderRequest{
+		{Conid: 265598, COID: "e", OrderType: "MKT", Side: "SELL", Quantity: 50},
+		{Conid: 265598, ParentID: "e", OrderType: "LMT", Side: "BUY", Quantity: 50, Price: 185},
+	}
+	if _, err := guard.PlaceOrders(testContext(t), "U1", orders); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := guard.ModifyOrder(testContext(t), "U1", "42", OrderRequest{Conid: 265598, OrderType: "LMT", Side: "BUY", Quantity: 10, Price: 191}); err != nil {
+		t.Fatal(err)
+	}
+	if n := placed.Load(); n != 2 {
+		t.Errorf("%d requests reached the gateway, want 2", n)
+	}
+
+	// With no quote, neither the collar nor the notional can be checked, so
+	// the order is blocked.
+	err := guard.Check(testContext(t), "U1", []OrderRequest{{Conid: 8314, OrderType: "MKT", Side: "BUY", Quantity: 1}})
+	var rerr *RiskError
+	if !errors.As(err, &rerr) || !rerr.Has(RuleMaxNotional) {
+		t.Errorf("expected a notional violation for an unquoted order, got %v", err)
+	}
+}
+
+func TestRiskGuardOrdersPerMinute(t *testing.T) {
+	client, placed, done := riskServer(t, "[]", "")
+	defer done()
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	guard := NewRiskGuard(client.Orders, fakeQuotes{}, RiskLimits{MaxOrdersPerMinute: 2})
+	guard.now = func() time.Time { return now }
+	order := []OrderRequest{{Conid: 265598, OrderType: "LMT", Side: "BUY", Quantity: 1, Price: 190}}
+	// Check does not count towards the limit.
+	for i := 0; i < 3; i++ {
+		if err := guard.Check(testContext(t), "U1", order); err != nil {
+			t.Fatalf("Check %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := guard.PlaceOrders(testContext(t), "U1", order); err != nil {
+			t.Fatalf("order %d: %v", i, err)
+		}
+	}
+	now = now.Add(30 * time.Second)
+	_, err := guard.PlaceOrders(testContext(t), "U1", order)
+	var rerr *RiskError
+	if !errors.As(err, &rerr) || !rerr.Has(RuleMaxOrdersPerMinute) {
+		t.Fatalf("expected a rate violation, got %v", err)
+	}
+	now = now.Add(31 * time.Second)
+	if _, err := guard.PlaceOrders(testContext(t), "U1", order); err != nil {
+		t.Fatalf("after a minute: %v", err)
+	}
+	if n := placed.Load(); n != 3 {
+		t.Errorf("%d orders reached the gateway, want 3", n)
+	}
+}
+
+// With only a restricted list configured, an order given by conid alone is
+// still checked, by the symbol its quote gives.
+func TestRiskGuardRestrictedByConid(t *testing.T) {
+	client, placed, done := riskServer(t, "[]", "")
+	defer done()
+	quotes := fakeQuotes{265598: {Conid: 265598, Symbol: "AAPL"}, 272093: {Conid: 272093, Symbol: "MSFT"}}
+	guard := NewRiskGuard(client.Orders, quotes, RiskLimits{RestrictedSymbols: []string{"aapl"}})
+	order := func(conid int) []OrderRequest {
+		return []OrderRequest{{Conid: conid, OrderType: "MKT", Side: "BUY", Quantity: 1}}
+	}
+	var rerr *RiskError
+	if _, err := guard.PlaceOrders(testContext(t), "U1", order(265598)); !errors.As(err, &rerr) || !rerr.Has(RuleRestricted) {
+		t.Errorf("expected a restricted violation for AAPL by conid, got %v", err)
+	}
+	// No symbol can be found for 8314, so it cannot be cleared.
+	if _, err := guard.PlaceOrders(testContext(t), "U1", order(8314)); !errors.As(err, &rerr) || !rerr.Has(RuleRestricted) {
+		t.Errorf("expected a restricted violation for an unknown symbol, got %v", err)
+	}
+	if _, err := guard.PlaceOrders(testContext(t), "U1", order(272093)); err != nil {
+		t.Errorf("MSFT: %v", err)
+	}
+	if n := placed.Load(); n != 1 {
+		t.Errorf("%d orders reached the gateway, want 1", n)
+	}
+}
+
+// A cash-quantity order is valued from its quote for the position limit, even
+// when no other limit needs a quote, and blocked if there is none.
+func TestRiskGuardCashQtyPosition(t *testing.T) {
+	client, placed, done := riskServer(t, "[]", "")
+	defer done()
+	quotes := fakeQuotes{265598: {Conid: 265598, Bid: livePrice(189.9), Ask: livePrice(190.1)}}
+	guard := NewRiskGuard(client.Orders, quotes, RiskLimits{MaxPosition: 100})
+	order := func(conid int, cash float64) []OrderRequest {
+		return []OrderRequest{{Conid: conid, OrderType: "MKT", Side: "BUY", CashQty: cash}}
+	}
+	var rerr *RiskError
+	if _, err := guard.PlaceOrders(testContext(t), "U1", order(265598, 100000)); !errors.As(err, &rerr) || !rerr.Has(RuleMaxPosition) {
+		t.Errorf("expected a position violation for $100,000 of AAPL, got %v", err)
+	}
+	if _, err := guard.PlaceOrders(testContext(t), "U1", order(8314, 100)); !errors.As(err, &rerr) || !rerr.Has(RuleMaxPosition) {
+		t.Errorf("expected a position violation for an unpriced cash order, got %v", err)
+	}
+	if _, err := guard.PlaceOrders(testContext(t), "U1", order(265598, 1900)); err != nil {
+		t.Errorf("$1,900 of AAPL: %v", err)
+	}
+	if n := placed.Load(); n != 1 {
+		t.Errorf("%d orders reached the gateway, want 1", n)
+	}
+}
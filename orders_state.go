@@ -0,0 +1,277 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OrderState is an order's status in IB's vocabulary, as reported in
+// Order.Status and OrderStatus.Status.
+type OrderState string
+
+const (
+	// OrderStateAPIPending: the order has not yet been sent to IB.
+	OrderStateAPIPending OrderState = "ApiPending"
+	// OrderStatePendingSubmit: the order has been sent but IB has not yet
+	// acknowledged it.
+	OrderStatePendingSubmit OrderState = "PendingSubmit"
+	// OrderStatePreSubmitted: IB holds the order until its conditions are
+	// met, e.g. a stop price or the market opening.
+	OrderStatePreSubmitted OrderState = "PreSubmitted"
+	// OrderStateSubmitted: the order is working at the exchange.
+	OrderStateSubmitted OrderState = "Submitted"
+	// OrderStateWarnState: the order is working, with a warning attached.
+	OrderStateWarnState OrderState = "WarnState"
+	// OrderStatePendingCancel: a cancel has been requested but not
+	// confirmed. The order may still fill.
+	OrderStatePendingCancel OrderState = "PendingCancel"
+	// OrderStateFilled: the order is completely filled.
+	OrderStateFilled OrderState = "Filled"
+	// OrderStateCancelled: the order was cancelled, possibly after a partial
+	// fill.
+	OrderStateCancelled OrderState = "Cancelled"
+	// OrderStateAPICancelled: the order was cancelled before IB acknowledged
+	// it.
+	OrderStateAPICancelled OrderState = "ApiCancelled"
+	// OrderStateInactive: the order was rejected, or IB stopped working it.
+	OrderStateInactive OrderState = "Inactive"
+)
+
+var orderStates = []OrderState{
+	OrderStateAPIPending, OrderStatePendingSubmit, OrderStatePreSubmitted,
+	OrderStateSubmitted, OrderStateWarnState, OrderStatePendingCancel,
+	OrderStateFilled, OrderStateCancelled, OrderStateAPICancelled,
+	OrderStateInactive,
+}
+
+// ParseOrderState returns the OrderState for status, matching IB's names
+// without regard to case, since the websocket and REST endpoints do not agree
+// on it. An unrecognised status is returned unchanged.
+func ParseOrderState(status string) OrderState {
+	status = strings.TrimSpace(status)
+	for _, s := range orderStates {
+		if strings.EqualFold(status, string(s)) {
+			return s
+		}
+	}
+	return OrderState(status)
+}
+
+// IsTerminal reports whether an order in this state will not change again:
+// it is filled, cancelled or inactive.
+func (s OrderState) IsTerminal() bool {
+	switch s {
+	case OrderStateFilled, OrderStateCancelled, OrderStateAPICancelled, OrderStateInactive:
+		return true
+	}
+	return false
+}
+
+// IsWorking reports whether an order in this state is live and may still
+// fill, including while a cancel is pending.
+func (s OrderState) IsWorking() bool {
+	switch s {
+	case OrderStateAPIPending, OrderStatePendingSubmit, OrderStatePreSubmitted,
+		OrderStateSubmitted, OrderStateWarnState, OrderStatePendingCancel:
+		return true
+	}
+	return false
+}
+
+// State returns the order's status as an OrderState.
+func (o Order) State() OrderState {
+	return ParseOrderState(o.Status)
+}
+
+// AveragePrice returns the order's average fill price. The second return
+// value is false before the first fill, when IB sends no price.
+func (o Order) AveragePrice() (float64, bool) {
+	return numberOK(o.AvgPrice)
+}
+
+// Fills parses SizeAndFills, which IB shows as "filled/size" while the order
+// is partly filled and as the size alone once nothing is left to fill. The
+// third return value is false if SizeAndFills is empty or not in either form.
+func (o Order) Fills() (filled, size float64, ok bool) {
+	a, b, partly := strings.Cut(o.SizeAndFills, "/")
+	size, ok = numberOK(b)
+	if !partly {
+		size, ok = numberOK(a)
+		return size, size, ok
+	}
+	filled, okFilled := numberOK(a)
+	return filled, size, ok && okFilled
+}
+
+// UnmarshalJSON decodes an order, accepting numbers given as strings and
+// strings given as numbers.
+func (o *Order) UnmarshalJSON(data []byte) error {
+	type plain Order
+	raw := struct {
+		*plain
+		ContractID         flexInt    `json:"conid"`
+		OrderID            flexInt    `json:"orderId"`
+		SizeAndFills       flexString `json:"sizeAndFills"`
+		RemainingQuantity  flexFloat  `json:"remainingQuantity"`
+		FilledQuantity     flexFloat  `json:"filledQuantity"`
+		TotalSize          flexFloat  `json:"totalSize"`
+		AvgPrice           flexString `json:"avgPrice"`
+		LastExecutionTimeR flexInt    `json:"lastExecutionTime_r"`
+	}{plain: (*plain)(o)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	o.ContractID = int64(raw.ContractID)
+	o.OrderID = int64(raw.OrderID)
+	o.SizeAndFills = string(raw.SizeAndFills)
+	o.RemainingQuantity = float64(raw.RemainingQuantity)
+	o.FilledQuantity = float64(raw.FilledQuantity)
+	o.TotalSize = float64(raw.TotalSize)
+	o.AvgPrice = string(raw.AvgPrice)
+	o.LastExecutionTimeR = int64(raw.LastExecutionTimeR)
+	return nil
+}
+
+// FillPrice returns the execution price. The second return value is false if
+// the price is missing or not a number.
+func (t Trade) FillPrice() (float64, bool) {
+	return numberOK(t.Price)
+}
+
+// CommissionAmount returns the commission charged for the execution. The
+// second return value is false if IB has not reported it.
+func (t Trade) CommissionAmount() (float64, bool) {
+	return numberOK(t.Commission)
+}
+
+// tradeTimeLayout is the layout of Trade.TradeTime, which is in UTC.
+const tradeTimeLayout = "20060102-15:04:05"
+
+// Time returns when the trade executed, from TradeTime or, failing that,
+// TradeTimeR. The second return value is false if neither is set.
+func (t Trade) Time() (time.Time, bool) {
+	if tt, err := time.Parse(tradeTimeLayout, t.TradeTime); err == nil {
+		return tt, true
+	}
+	if t.TradeTimeR > 0 {
+		return time.UnixMilli(t.TradeTimeR).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// UnmarshalJSON decodes a trade, accepting numbers given as strings and
+// strings given as numbers.
+func (t *Trade) UnmarshalJSON(data []byte) error {
+	type plain Trade
+	raw := struct {
+		*plain
+		TradeTimeR flexInt    `json:"trade_time_r"`
+		Size       flexFloat  `json:"size"`
+		Price      flexString `json:"price"`
+		Commission flexString `json:"commission"`
+		NetAmount  flexFloat  `json:"net_amount"`
+		ContractID flexInt    `json:"conid"`
+		OrderID    flexFloat  `json:"order_id"`
+	}{plain: (*plain)(t)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	t.TradeTimeR = int64(raw.TradeTimeR)
+	t.Size = float64(raw.Size)
+	t.Price = string(raw.Price)
+	t.Commission = string(raw.Commission)
+	t.NetAmount = float64(raw.NetAmount)
+	t.ContractID = int64(raw.ContractID)
+	t.OrderID = float64(raw.OrderID)
+	return nil
+}
+
+// numberOK is ParseNumber for the gateway's display strings, reporting false
+// instead of an error for an empty or unparseable string.
+func numberOK(s string) (float64, bool) {
+	if strings.TrimSpace(s) == "" {
+		return 0, false
+	}
+	v, err := ParseNumber(s)
+	return v, err == nil
+}
+
+// flexFloat is a number the gateway may send as a JSON number or as a string
+// holding one. An empty string or null decodes as 0.
+type flexFloat float64
+
+func (f *flexFloat) UnmarshalJSON(data []byte) error {
+	s, err := unquoteFlex(data)
+	if err != nil {
+		return err
+	}
+	if s == "" {
+		*f = 0
+		return nil
+	}
+	v, ok := numberOK(s)
+	if !ok {
+		return fmt.Errorf("ibclientportal: parsing number %s", data)
+	}
+	*f = flexFloat(v)
+	return nil
+}
+
+// flexInt is flexFloat for integers, such as conids and millisecond
+// timestamps.
+type flexInt int64
+
+func (n *flexInt) UnmarshalJSON(data []byte) error {
+	s, err := unquoteFlex(data)
+	if err != nil {
+		return err
+	}
+	if s == "" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		// Integers sometimes arrive with a fractional part, e.g. "5.0".
+		f, ok := numberOK(s)
+		if !ok {
+			return fmt.Errorf("ibclientportal: parsing integer %s: %w", data, err)
+		}
+		v = int64(f)
+	}
+	*n = flexInt(v)
+	return nil
+}
+
+// flexString is a string the gateway may send as a JSON number instead, such
+// as a price. A number is kept exactly as written.
+type flexString string
+
+func (s *flexString) UnmarshalJSON(data []byte) error {
+	v, err := unquoteFlex(data)
+	*s = flexString(v)
+	return err
+}
+
+// unquoteFlex returns a JSON string's contents or a scalar's text, trimmed,
+// and "" for null.
+func unquoteFlex(data []byte) (string, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "null" {
+		return "", nil
+	}
+	switch {
+	case strings.HasPrefix(trimmed, `"`):
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(s), nil
+	case strings.HasPrefix(trimmed, "{"), strings.HasPrefix(trimmed, "["):
+		return "", fmt.Errorf("ibclientportal: expected a string or number, got %s", trimmed)
+	}
+	return trimmed, nil
+}
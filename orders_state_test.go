@@ -0,0 +1,111 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestOrderState(t *testing.T) {
+	tests := []struct {
+		status            string
+		want              OrderState
+		terminal, working bool
+	}{
+		{"Submitted", OrderStateSubmitted, false, true},
+		{"PreSubmitted", OrderStatePreSubmitted, false, true},
+		{"PendingCancel", OrderStatePendingCancel, false, true},
+		{"filled", OrderStateFilled, true, false},
+		{"Cancelled", OrderStateCancelled, true, false},
+		{"ApiCancelled", OrderStateAPICancelled, true, false},
+		{"Inactive", OrderStateInactive, true, false},
+		{"Mystery", "Mystery", false, false},
+	}
+	for _, tt := range tests {
+		got := Order{Status: tt.status}.State()
+		if got != tt.want || got.IsTerminal() != tt.terminal || got.IsWorking() != tt.working {
+			t.Errorf("%q: got %q (terminal %t, working %t), want %q (%t, %t)",
+				tt.status, got, got.IsTerminal(), got.IsWorking(), tt.want, tt.terminal, tt.working)
+		}
+	}
+}
+
+func TestOrderDecodeTolerant(t *testing.T) {
+	var orders []Order
+	data := `[
+		{"orderId":1533204928,"conid":265598,"status":"Submitted","avgPrice":"101.25","sizeAndFills":"4/10","totalSize":10.0,"filledQuantity":4.0},
+		{"orderId":"1533204929","conid":"265598","status":"Filled","avgPrice":101.5,"sizeAndFills":10,"totalSize":"10.0","remainingQuantity":"0.0"},
+		{"orderId":1533204930,"status":"Submitted","avgPrice":"","sizeAndFills":"0/1,000"}
+	]`
+	if err := json.Unmarshal([]byte(data), &orders); err != nil {
+		t.Fatal(err)
+	}
+	for i, o := range orders[:2] {
+		if o.OrderID != 1533204928+int64(i) || o.ContractID != 265598 || o.TotalSize != 10 {
+			t.Errorf("order %d: unexpected %+v", i, o)
+		}
+	}
+	if p, ok := orders[0].AveragePrice(); !ok || p != 101.25 {
+		t.Errorf("AveragePrice() = %v, %v", p, ok)
+	}
+	if p, ok := orders[1].AveragePrice(); !ok || p != 101.5 {
+		t.Errorf("numeric AveragePrice() = %v, %v", p, ok)
+	}
+	if _, ok := orders[2].AveragePrice(); ok {
+		t.Error("expected no average price before a fill")
+	}
+	fills := []struct{ filled, size float64 }{{4, 10}, {10, 10}, {0, 1000}}
+	for i, want := range fills {
+		filled, size, ok := orders[i].Fills()
+		if !ok || filled != want.filled || size != want.size {
+			t.Errorf("order %d: Fills() = %v, %v, %v; want %v, %v", i, filled, size, ok, want.filled, want.size)
+		}
+	}
+	if _, _, ok := (Order{}).Fills(); ok {
+		t.Error("expected Fills to fail without SizeAndFills")
+	}
+}
+
+func TestTradeDecodeTolerant(t *testing.T) {
+	var trades []Trade
+	data := `[
+		{"execution_id":"a","price":"192.26","commission":"1.02","trade_time":"20231211-18:00:49","size":5,"order_id":1533204928,"conid":265598},
+		{"execution_id":"b","price":192.27,"commission":"","trade_time_r":1702317650000,"size":"5.0","order_id":"1533204929","net_amount":"961.35"}
+	]`
+	if err := json.Unmarshal([]byte(data), &trades); err != nil {
+		t.Fatal(err)
+	}
+	a, b := trades[0], trades[1]
+	if p, ok := a.FillPrice(); !ok || p != 192.26 {
+		t.Errorf("FillPrice() = %v, %v", p, ok)
+	}
+	if p, ok := b.FillPrice(); !ok || p != 192.27 {
+		t.Errorf("numeric FillPrice() = %v, %v", p, ok)
+	}
+	if c, ok := a.CommissionAmount(); !ok || c != 1.02 {
+		t.Errorf("CommissionAmount() = %v, %v", c, ok)
+	}
+	if _, ok := b.CommissionAmount(); ok {
+		t.Error("expected no commission when IB has not reported it")
+	}
+	if tt, ok := a.Time(); !ok || !tt.Equal(time.Date(2023, 12, 11, 18, 0, 49, 0, time.UTC)) {
+		t.Errorf("Time() = %v, %v", tt, ok)
+	}
+	if tt, ok := b.Time(); !ok || !tt.Equal(time.Date(2023, 12, 11, 18, 0, 50, 0, time.UTC)) {
+		t.Errorf("Time() from trade_time_r = %v, %v", tt, ok)
+	}
+	if b.Size != 5 || b.OrderID != 1533204929 || b.NetAmount != 961.35 {
+		t.Errorf("unexpected trade %+v", b)
+	}
+	// Abbreviated sizes are read as ParseNumber reads them.
+	var big Trade
+	if err := json.Unmarshal([]byte(`{"size":"1.5K","price":"1,234.5"}`), &big); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := big.FillPrice(); big.Size != 1500 || !ok || p != 1234.5 {
+		t.Errorf("abbreviated trade: size %v, price %v, %v", big.Size, p, ok)
+	}
+	if err := json.Unmarshal([]byte(`{"price":{"amount":1}}`), &Trade{}); err == nil {
+		t.Error("expected an error for an object where a price belongs")
+	}
+}
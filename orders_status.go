@@ -0,0 +1,190 @@
+package ibclientportal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// OrderStatus is the state of a single order, from OrdersService.OrderStatus.
+//
+// The gateway sends quantities and prices as strings ("5.0", "192.26"), and
+// not always the same way from one version to the next, so they are decoded
+// from either a string or a number.
+type OrderStatus struct {
+	OrderID   OrderID `json:"order_id"`
+	Account   string  `json:"account"`
+	Conid     int64   `json:"conid"`
+	ConidEx   string  `json:"conidex"`
+	Symbol    string  `json:"symbol"`
+	SecType   string  `json:"sec_type"`
+	Currency  string  `json:"currency"`
+	Exchange  string  `json:"listing_exchange"`
+	Company   string  `json:"company_name"`
+	Side      string  `json:"side"` // "B" or "S"
+	OrderType string  `json:"order_type"`
+	TIF       string  `json:"tif"`
+	// Status is the order's state, e.g. "Submitted", "Filled" or
+	// "Cancelled"; State returns it as an OrderState.
+	Status            string `json:"order_status"`
+	StatusDescription string `json:"order_status_description"`
+	CCPStatus         string `json:"order_ccp_status"`
+	Description       string `json:"order_description"`
+	// TotalSize is the order's quantity, Filled how much of it has executed
+	// and Remaining how much is still working.
+	TotalSize float64 `json:"total_size"`
+	Filled    float64 `json:"cum_fill"`
+	Remaining float64 `json:"size"`
+	// AvgPrice is the average fill price, or 0 before the first fill.
+	AvgPrice     float64 `json:"average_price"`
+	SizeAndFills string  `json:"size_and_fills"`
+	// OrderTime is when the order was placed, in UTC.
+	OrderTime    time.Time `json:"-"`
+	NotEditable  bool      `json:"order_not_editable"`
+	CannotCancel bool      `json:"cannot_cancel_order"`
+}
+
+// UnmarshalJSON decodes the gateway's order status, parsing the quantities,
+// prices and order time it sends as strings.
+func (s *OrderStatus) UnmarshalJSON(data []byte) error {
+	type plain OrderStatus
+	raw := struct {
+		*plain
+		TotalSize flexFloat `json:"total_size"`
+		Filled    flexFloat `json:"cum_fill"`
+		Remaining flexFloat `json:"size"`
+		AvgPrice  flexFloat `json:"average_price"`
+		OrderTime string    `json:"order_time"`
+	}{plain: (*plain)(s)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	s.TotalSize = float64(raw.TotalSize)
+	s.Filled = float64(raw.Filled)
+	s.Remaining = float64(raw.Remaining)
+	s.AvgPrice = float64(raw.AvgPrice)
+	s.OrderTime = time.Time{}
+	if raw.OrderTime != "" {
+		t, err := time.Parse("060102150405", raw.OrderTime)
+		if err != nil {
+			return fmt.Errorf("ibclientportal: parsing order time %q: %w", raw.OrderTime, err)
+		}
+		s.OrderTime = t
+	}
+	return nil
+}
+
+// State returns the order's status as an OrderState.
+func (s OrderStatus) State() OrderState {
+	return ParseOrderState(s.Status)
+}
+
+// OrderStatus returns the state of one order placed in the current brokerage
+// session. Unlike ListOrders it is not limited to one call every five seconds,
+// so it is the way to follow a single order. IB answers with an error (HTTP
+// 503) for orders from an earlier session.
+func (o *OrdersService) OrderStatus(ctx context.Context, orderID string) (OrderStatus, error) {
+	var val OrderStatus
+	if orderID == "" {
+		return val, fmt.Errorf("ibclientportal: OrderStatus: no order ID given")
+	}
+	path := "/iserver/account/order/status/" + url.PathEscape(orderID)
+	err := o.client.ListResource(ctx, path, nil, &val)
+	return val, err
+}
+
+// WaitUntil is the state WaitForOrder waits for.
+type WaitUntil int
+
+const (
+	// UntilTerminal waits until the order is filled, cancelled or inactive.
+	UntilTerminal WaitUntil = iota
+	// UntilFilled waits until the order is completely filled.
+	UntilFilled
+	// UntilCancelled waits until the order is cancelled, by the caller or by
+	// IB.
+	UntilCancelled
+)
+
+func (u WaitUntil) String() string {
+	switch u {
+	case UntilTerminal:
+		return "terminal"
+	case UntilFilled:
+		return "filled"
+	case UntilCancelled:
+		return "cancelled"
+	default:
+		return fmt.Sprintf("WaitUntil(%d)", int(u))
+	}
+}
+
+// reached reports whether st satisfies u.
+func (u WaitUntil) reached(st OrderStatus) bool {
+	switch state := st.State(); u {
+	case UntilFilled:
+		return state == OrderStateFilled
+	case UntilCancelled:
+		return state == OrderStateCancelled || state == OrderStateAPICancelled
+	default:
+		return state.IsTerminal()
+	}
+}
+
+const (
+	// defaultOrderPollInterval is how often WaitForOrder polls the status
+	// endpoint when it has nothing else to go on.
+	defaultOrderPollInterval = time.Second
+	// streamOrderPollInterval is how often (*Stream).WaitForOrder polls as a
+	// backstop to the order events that wake it.
+	streamOrderPollInterval = 15 * time.Second
+)
+
+// WaitForOrder waits until the order reaches the state until asks for, by
+// polling OrderStatus every second, and returns its final status, including
+// the filled quantity and average price. On an error, including the context
+// ending, it returns the last status it saw. If the order ends in a different
+// state — cancelled while waiting for a fill, say — it returns that status
+// with an error. When a Stream is open, (*Stream).WaitForOrder finds out
+// sooner and polls far less.
+//
+// CancelOrder followed by WaitForOrder(ctx, orderID, UntilCancelled) is the
+// way to confirm a cancellation took effect.
+func (o *OrdersService) WaitForOrder(ctx context.Context, orderID string, until WaitUntil) (OrderStatus, error) {
+	interval := o.pollInterval
+	if interval <= 0 {
+		interval = defaultOrderPollInterval
+	}
+	return o.waitForOrder(ctx, orderID, until, interval, nil)
+}
+
+// waitForOrder polls the order's status every interval, and whenever wake
+// fires, until until is reached or the order stops changing.
+func (o *OrdersService) waitForOrder(ctx context.Context, orderID string, until WaitUntil, interval time.Duration, wake <-chan struct{}) (OrderStatus, error) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var last OrderStatus
+	for {
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case <-timer.C:
+		case <-wake:
+			timer.Stop()
+		}
+		st, err := o.OrderStatus(ctx, orderID)
+		if err != nil {
+			return last, err
+		}
+		last = st
+		if until.reached(st) {
+			return st, nil
+		}
+		if st.State().IsTerminal() {
+			return st, fmt.Errorf("ibclientportal: WaitForOrder: order %s is %s, and will not be %s", orderID, st.Status, until)
+		}
+		timer.Reset(interval)
+	}
+}
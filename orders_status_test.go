@@ -0,0 +1,229 @@
+package ibclientportal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOrderStatusDecode(t *testing.T) {
+	var st OrderStatus
+	data := `{"order_id":1799796559,"conid":265598,"symbol":"AAPL","side":"S","size":"0.0","total_size":"5.0",` +
+		`"account":"U1234567","order_type":"MARKET","cum_fill":"5.0","order_status":"Filled",` +
+		`"order_status_description":"Order Filled","tif":"DAY","average_price":"192.26","order_time":"231211180049",` +
+		`"cannot_cancel_order":true}`
+	if err := json.Unmarshal([]byte(data), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.OrderID != "1799796559" || st.Status != "Filled" || st.Conid != 265598 || !st.CannotCancel {
+		t.Errorf("unexpected status %+v", st)
+	}
+	if st.TotalSize != 5 || st.Filled != 5 || st.Remaining != 0 || st.AvgPrice != 192.26 {
+		t.Errorf("quantities = %v/%v/%v @ %v", st.TotalSize, st.Filled, st.Remaining, st.AvgPrice)
+	}
+	if want := time.Date(2023, 12, 11, 18, 0, 49, 0, time.UTC); !st.OrderTime.Equal(want) {
+		t.Errorf("OrderTime = %v, want %v", st.OrderTime, want)
+	}
+
+	// Numbers as numbers, and an empty price before any fill.
+	if err := json.Unmarshal([]byte(`{"order_id":"1","total_size":10,"cum_fill":2.5,"average_price":""}`), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.TotalSize != 10 || st.Filled != 2.5 || st.AvgPrice != 0 || !st.OrderTime.IsZero() {
+		t.Errorf("unexpected status %+v", st)
+	}
+	if err := json.Unmarshal([]byte(`{"cum_fill":"lots"}`), &st); err == nil {
+		t.Error("expected an error for a non-numeric quantity")
+	}
+}
+
+func TestWaitForOrder(t *testing.T) {
+	var polls atomic.Int32
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/api/iserver/account/order/status/1533204928" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch polls.Add(1) {
+		case 1, 2:
+			w.Write([]byte(`{"order_id":1533204928,"order_status":"Submitted","total_size":"10.0","cum_fill":"4.0","size":"6.0"}`))
+		default:
+			w.Write([]byte(`{"order_id":1533204928,"order_status":"Filled","total_size":"10.0","cum_fill":"10.0","size":"0.0","average_price":"101.5"}`))
+		}
+	})
+	defer server.Close()
+	client.Orders.pollInterval = 10 * time.Millisecond
+
+	st, err := client.Orders.WaitForOrder(testContext(t), "1533204928", UntilFilled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Status != "Filled" || st.Filled != 10 || st.AvgPrice != 101.5 {
+		t.Errorf("unexpected final status %+v", st)
+	}
+	if n := polls.Load(); n != 3 {
+		t.Errorf("polled %d times, want 3", n)
+	}
+}
+
+func TestWaitForOrderWrongEnd(t *testing.T) {
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order_id":1,"order_status":"Cancelled","total_size":"10.0","cum_fill":"3.0"}`))
+	})
+	defer server.Close()
+
+	st, err := client.Orders.WaitForOrder(testContext(t), "1", UntilFilled)
+	if err == nil || !strings.Contains(err.Error(), "Cancelled") {
+		t.Errorf("expected an error saying the order was cancelled, got %v", err)
+	}
+	if st.Status != "Cancelled" || st.Filled != 3 {
+		t.Errorf("expected the final status alongside the error, got %+v", st)
+	}
+	if _, err := client.Orders.WaitForOrder(testContext(t), "1", UntilCancelled); err != nil {
+		t.Errorf("UntilCancelled: %v", err)
+	}
+}
+
+// The stream version looks the order up when an order event names it, rather
+// than waiting for its slow backstop poll.
+func TestStreamWaitForOrder(t *testing.T) {
+	t.Parallel()
+	var filled atomic.Bool
+	received := make(chan string, 16)
+	events := make(chan string, 1)
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/iserver/account/order/status/1533204928", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if filled.Load() {
+			w.Write([]byte(`{"order_id":1533204928,"order_status":"Filled","cum_fill":"10.0","average_price":"101.5"}`))
+			return
+		}
+		w.Write([]byte(`{"order_id":1533204928,"order_status":"Submitted"}`))
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		go func() {
+			for frame := range events {
+				conn.WriteMessage(websocket.TextMessage, []byte(frame))
+			}
+		}()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg := string(data); msg != "tic" {
+				received <- msg
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := New(srv.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	type result struct {
+		st  OrderStatus
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		st, err := stream.WaitForOrder(ctx, "1533204928", UntilTerminal)
+		done <- result{st, err}
+	}()
+	select {
+	case msg := <-received:
+		if msg != "sor+{}" {
+			t.Fatalf("gateway received %q, want sor+{}", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the order subscription")
+	}
+	filled.Store(true)
+	events <- `{"topic":"sor","args":[{"orderId":1533204928,"status":"Filled"}]}`
+	select {
+	case r := <-done:
+		if r.err != nil || r.st.Status != "Filled" || r.st.AvgPrice != 101.5 {
+			t.Errorf("WaitForOrder = %+v, %v", r.st, r.err)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for WaitForOrder to see the order event")
+	}
+	select {
+	case msg := <-received:
+		if msg != "uor+{}" {
+			t.Errorf("gateway received %q, want uor+{}", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the order unsubscription")
+	}
+}
+
+// Once the stream has ended no order events come, so WaitForOrder polls.
+func TestStreamWaitForOrderEnded(t *testing.T) {
+	t.Parallel()
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/iserver/account/order/status/1533204928", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order_id":1533204928,"order_status":"Filled","cum_fill":"10.0"}`))
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := New(srv.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	for range stream.Updates() {
+	}
+	if _, err := stream.SubscribeOrders(); err == nil {
+		t.Error("expected an error subscribing to orders on an ended stream")
+	}
+	st, err := stream.WaitForOrder(ctx, "1533204928", UntilTerminal)
+	if err != nil || st.Status != "Filled" {
+		t.Errorf("WaitForOrder = %+v, %v", st, err)
+	}
+}
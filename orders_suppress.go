@@ -0,0 +1,203 @@
+package ibclientportal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// questionSuppression remembers the order questions suppressed in this
+// session, so they can be suppressed again when the gateway starts a new
+// brokerage session: IB forgets them on logout, and the first sign of that is
+// otherwise a question the caller thought it had dealt with.
+type questionSuppression struct {
+	mu  sync.Mutex
+	ids []string
+	// authenticated and session are the brokerage session as last observed
+	// by Tickle or AuthStatus.
+	authenticated bool
+	session       string
+	// stale is set when the gateway may no longer have ids suppressed.
+	stale bool
+}
+
+// observe records the session state reported by the gateway and reports
+// whether the suppressions need re-sending. A session that was seen logged
+// out, or whose ID changed, has lost them.
+func (q *questionSuppression) observe(session string, authenticated bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case !authenticated:
+		q.stale = true
+	case !q.authenticated, session != "" && q.session != "" && session != q.session:
+		q.stale = true
+	}
+	q.authenticated = authenticated
+	if session != "" {
+		q.session = session
+	}
+	return q.stale && len(q.ids) > 0
+}
+
+// pending returns the IDs to re-send, or nil if the gateway is believed to
+// have them already.
+func (q *questionSuppression) pending() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.stale {
+		return nil
+	}
+	return slices.Clone(q.ids)
+}
+
+// applied records that the gateway suppressed ids. Suppressing questions
+// needs an authenticated session, so it is evidence of one.
+func (q *questionSuppression) applied(ids []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, id := range ids {
+		if !slices.Contains(q.ids, id) {
+			q.ids = append(q.ids, id)
+		}
+	}
+	q.authenticated = true
+	// Suppressions the gateway lost stay stale until they are all re-sent.
+	if q.stale && !containsAll(ids, q.ids) {
+		return
+	}
+	q.stale = false
+}
+
+// forget records that the gateway has lost some suppressions; seeing a
+// suppressed question asked again is proof of it.
+func (q *questionSuppression) forget() {
+	q.mu.Lock()
+	q.stale = true
+	q.mu.Unlock()
+}
+
+func (q *questionSuppression) reset() {
+	q.mu.Lock()
+	q.ids = nil
+	q.stale = false
+	q.mu.Unlock()
+}
+
+func (q *questionSuppression) list() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return slices.Clone(q.ids)
+}
+
+// SuppressQuestions tells IB not to ask the order questions with the given
+// message IDs (e.g. "o163", "o354") for the rest of the brokerage session: an
+// order that would raise one is transmitted as though it had been confirmed.
+//
+// The client remembers the suppressed IDs and sends them again when it sees
+// the gateway start a new brokerage session — from a Tickle or AuthStatus
+// response, or from a suppressed question being asked again — before the next
+// order is placed. SuppressedQuestions lists them.
+func (o *OrdersService) SuppressQuestions(ctx context.Context, messageIDs ...string) error {
+	if len(messageIDs) == 0 {
+		return fmt.Errorf("ibclientportal: SuppressQuestions: no message IDs given")
+	}
+	if err := o.suppress(ctx, messageIDs); err != nil {
+		return err
+	}
+	o.client.suppression.applied(messageIDs)
+	return nil
+}
+
+func (o *OrdersService) suppress(ctx context.Context, messageIDs []string) error {
+	body := struct {
+		MessageIDs []string `json:"messageIds"`
+	}{MessageIDs: messageIDs}
+	var val suppressionResponse
+	if err := o.client.UpdateResource(ctx, "/iserver/questions/suppress", body, &val); err != nil {
+		return err
+	}
+	if val.Error != "" {
+		return fmt.Errorf("ibclientportal: SuppressQuestions: %s", val.Error)
+	}
+	return nil
+}
+
+// ResetQuestionSuppression withdraws every suppression made in this brokerage
+// session, so that IB asks all order questions again.
+func (o *OrdersService) ResetQuestionSuppression(ctx context.Context) error {
+	var val suppressionResponse
+	if err := o.client.UpdateResource(ctx, "/iserver/questions/suppress/reset", nil, &val); err != nil {
+		return err
+	}
+	if val.Error != "" {
+		return fmt.Errorf("ibclientportal: ResetQuestionSuppression: %s", val.Error)
+	}
+	o.client.suppression.reset()
+	return nil
+}
+
+// SuppressedQuestions returns the message IDs suppressed with
+// SuppressQuestions since the last ResetQuestionSuppression.
+func (o *OrdersService) SuppressedQuestions() []string {
+	return o.client.suppression.list()
+}
+
+// suppressionResponse is the reply to both suppression endpoints. Status is
+// always "submitted".
+type suppressionResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// observeSession is called with the session state from Tickle and
+// AuthStatus, and re-sends the suppressions if the session is new.
+func (c *Client) observeSession(ctx context.Context, session string, authenticated bool) {
+	if c.suppression.observe(session, authenticated) && authenticated {
+		c.reapplySuppression(ctx)
+	}
+}
+
+// reapplySuppression re-sends the suppressed message IDs if the gateway may
+// have lost them. A failure leaves them marked for the next attempt; it is not
+// worth failing the caller's request over, since an unsuppressed question
+// still has to be confirmed before anything is transmitted.
+func (c *Client) reapplySuppression(ctx context.Context) {
+	ids := c.suppression.pending()
+	if len(ids) == 0 {
+		return
+	}
+	if err := c.Orders.suppress(ctx, ids); err == nil {
+		c.suppression.applied(ids)
+	}
+}
+
+// noteQuestions marks the suppressions as lost if the gateway asked one of
+// the suppressed questions anyway.
+func (c *Client) noteQuestions(placements []OrderPlacement) {
+	suppressed := c.suppression.list()
+	if len(suppressed) == 0 {
+		return
+	}
+	for _, p := range placements {
+		if !p.IsQuestion() || p.IsSuppressed {
+			continue
+		}
+		for _, id := range p.MessageIDs {
+			if slices.Contains(suppressed, id) {
+				c.suppression.forget()
+				return
+			}
+		}
+	}
+}
+
+func containsAll(set, want []string) bool {
+	for _, id := range want {
+		if !slices.Contains(set, id) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package ibclientportal
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestQuestionSuppression(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	session, authenticated := "s1", true
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/api/tickle":
+			auth := "false"
+			if authenticated {
+				auth = "true"
+			}
+			w.Write([]byte(`{"session":"` + session + `","iserver":{"authStatus":{"authenticated":` + auth + `}}}`))
+		case "/v1/api/iserver/questions/suppress", "/v1/api/iserver/questions/suppress/reset":
+			calls = append(calls, r.URL.Path[len("/v1/api/iserver/questions/"):]+" "+string(body))
+			w.Write([]byte(`{"status":"submitted"}`))
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	})
+	defer server.Close()
+	ctx := testContext(t)
+	tickle := func(s string, auth bool) {
+		t.Helper()
+		mu.Lock()
+		session, authenticated = s, auth
+		mu.Unlock()
+		if _, err := client.Tickle(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectCalls := func(want ...string) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if !slices.Equal(calls, want) {
+			t.Errorf("gateway received %q, want %q", calls, want)
+		}
+		calls = nil
+	}
+
+	tickle("s1", true)
+	if err := client.Orders.SuppressQuestions(ctx, "o163", "o354"); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(`suppress {"messageIds":["o163","o354"]}`)
+	if got := client.Orders.SuppressedQuestions(); !slices.Equal(got, []string{"o163", "o354"}) {
+		t.Errorf("SuppressedQuestions() = %v", got)
+	}
+
+	// The same session: nothing to do.
+	tickle("s1", true)
+	expectCalls()
+	// Logged out, then back in: the suppressions are sent again.
+	tickle("s1", false)
+	expectCalls()
+	tickle("s1", true)
+	expectCalls(`suppress {"messageIds":["o163","o354"]}`)
+	// A new session ID means a new brokerage session.
+	tickle("s2", true)
+	expectCalls(`suppress {"messageIds":["o163","o354"]}`)
+
+	if err := client.Orders.ResetQuestionSuppression(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls("suppress/reset ")
+	if got := client.Orders.SuppressedQuestions(); len(got) != 0 {
+		t.Errorf("SuppressedQuestions() = %v after a reset", got)
+	}
+	tickle("s3", true)
+	expectCalls()
+
+	if err := client.Orders.SuppressQuestions(ctx); err == nil {
+		t.Error("expected an error when no message IDs are given")
+	}
+}
+
+// A suppressed question asked again means the gateway has lost the
+// suppression, so it is re-sent before the next order.
+func TestQuestionSuppressionLost(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/api/iserver/questions/suppress":
+			w.Write([]byte(`{"status":"submitted"}`))
+		case "/v1/api/iserver/account/U1234567/orders":
+			w.Write([]byte(`[{"id":"r1","message":["price cap"],"isSuppressed":false,"messageIds":["o163"]}]`))
+		}
+	})
+	defer server.Close()
+	ctx := testContext(t)
+
+	if err := client.Orders.SuppressQuestions(ctx, "o163"); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := client.Orders.PlaceOrders(ctx, "U1234567", confirmOrder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"/v1/api/iserver/questions/suppress",
+		"/v1/api/iserver/account/U1234567/orders",
+		"/v1/api/iserver/questions/suppress",
+		"/v1/api/iserver/account/U1234567/orders",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(paths, want) {
+		t.Errorf("gateway received\n%q\nwant\n%q", paths, want)
+	}
+}
@@ -0,0 +1,191 @@
+package ibclientportal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Money is an amount parsed from one of IB's display strings.
+type Money struct {
+	Amount float64
+	// Currency is the ISO code given with the amount, e.g. "USD". It is empty
+	// when IB gives a bare number, as it does for the margin and equity
+	// figures in a WhatIfResponse, which are in the account's base currency.
+	Currency string
+}
+
+func (m Money) String() string {
+	s := strconv.FormatFloat(m.Amount, 'f', -1, 64)
+	if m.Currency == "" {
+		return s
+	}
+	return s + " " + m.Currency
+}
+
+// ParseMoney parses an amount formatted for display, such as
+// "1,977.60 USD (10 Shares)", "-1" or "$1,234.50": thousands separators,
+// currency symbols and a trailing parenthetical are ignored, and an ISO
+// currency code is kept. Stray quote characters, which the gateway has been
+// seen to append, are dropped.
+func ParseMoney(s string) (Money, error) {
+	m, err := parseMoney(s)
+	if err != nil {
+		return m, fmt.Errorf("ibclientportal: %w", err)
+	}
+	return m, nil
+}
+
+// parseMoney is ParseMoney with an error for the caller to prefix.
+func parseMoney(s string) (Money, error) {
+	text := s
+	if i := strings.IndexByte(text, '('); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.ReplaceAll(text, `"`, "")
+	var m Money
+	seen := false
+	for _, f := range strings.Fields(text) {
+		if isCurrencyCode(f) {
+			m.Currency = f
+			continue
+		}
+		num := strings.Map(func(r rune) rune {
+			if r == ',' || unicode.Is(unicode.Sc, r) {
+				return -1
+			}
+			return r
+		}, f)
+		if num == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(num, 64)
+		if err != nil || seen {
+			return Money{}, fmt.Errorf("parsing amount %q", s)
+		}
+		m.Amount, seen = v, true
+	}
+	if !seen {
+		return Money{}, fmt.Errorf("parsing amount %q: no number", s)
+	}
+	return m, nil
+}
+
+func isCurrencyCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// WhatIfAmountValues is a WhatIfAmount with each figure parsed.
+type WhatIfAmountValues struct {
+	Amount     Money
+	Commission Money
+	Total      Money
+}
+
+// Parse parses the order's cost, commission and total.
+func (a WhatIfAmount) Parse() (WhatIfAmountValues, error) {
+	var v WhatIfAmountValues
+	err := parseMoneyFields(
+		moneyField{"amount", a.Amount, &v.Amount},
+		moneyField{"commission", a.Commission, &v.Commission},
+		moneyField{"total", a.Total, &v.Total},
+	)
+	return v, err
+}
+
+// WhatIfChangeValues is a WhatIfChange with each figure parsed.
+type WhatIfChangeValues struct {
+	Current Money
+	Change  Money
+	After   Money
+}
+
+// Parse parses the before, change and after figures.
+func (c WhatIfChange) Parse() (WhatIfChangeValues, error) {
+	var v WhatIfChangeValues
+	err := parseMoneyFields(
+		moneyField{"current", c.Current, &v.Current},
+		moneyField{"change", c.Change, &v.Change},
+		moneyField{"after", c.After, &v.After},
+	)
+	return v, err
+}
+
+type moneyField struct {
+	name string
+	text string
+	dst  *Money
+}
+
+// parseMoneyFields parses each field into its destination, stopping at the
+// first that fails.
+func parseMoneyFields(fields ...moneyField) error {
+	for _, f := range fields {
+		m, err := parseMoney(f.text)
+		if err != nil {
+			return fmt.Errorf("ibclientportal: WhatIf %s: %w", f.name, err)
+		}
+		*f.dst = m
+	}
+	return nil
+}
+
+// MarginImpact summarises what an order does to the account's margin, in the
+// account's base currency, from a WhatIfResponse.
+type MarginImpact struct {
+	// InitialChange and MaintenanceChange are the increase in the initial
+	// and maintenance margin requirements; negative when the order reduces
+	// risk.
+	InitialChange     float64
+	MaintenanceChange float64
+	// InitialAfter and MaintenanceAfter are the requirements after the
+	// order.
+	InitialAfter     float64
+	MaintenanceAfter float64
+	// EquityAfter is the equity with loan value after the order.
+	EquityAfter float64
+	// Commission is IB's commission estimate, which carries its own
+	// currency. It is zero if the preview gave none.
+	Commission Money
+}
+
+// ExcessAfter returns the equity with loan value left over the initial margin
+// requirement after the order. A negative value means IB would reject the
+// order for insufficient margin.
+func (m MarginImpact) ExcessAfter() float64 {
+	return m.EquityAfter - m.InitialAfter
+}
+
+// MarginImpact parses the margin and equity figures of the preview.
+func (r WhatIfResponse) MarginImpact() (MarginImpact, error) {
+	var m MarginImpact
+	var initialChange, initialAfter, maintChange, maintAfter, equityAfter Money
+	err := parseMoneyFields(
+		moneyField{"initial margin change", r.Initial.Change, &initialChange},
+		moneyField{"initial margin after", r.Initial.After, &initialAfter},
+		moneyField{"maintenance margin change", r.Maintenance.Change, &maintChange},
+		moneyField{"maintenance margin after", r.Maintenance.After, &maintAfter},
+		moneyField{"equity after", r.Equity.After, &equityAfter},
+	)
+	if err != nil {
+		return m, err
+	}
+	m.InitialChange = initialChange.Amount
+	m.InitialAfter = initialAfter.Amount
+	m.MaintenanceChange = maintChange.Amount
+	m.MaintenanceAfter = maintAfter.Amount
+	m.EquityAfter = equityAfter.Amount
+	if r.Amount.Commission != "" {
+		err = parseMoneyFields(moneyField{"commission", r.Amount.Commission, &m.Commission})
+	}
+	return m, err
+}
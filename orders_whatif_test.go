@@ -0,0 +1,76 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{"1,977.60 USD (10 Shares)", Money{1977.60, "USD"}},
+		{"1 USD", Money{1, "USD"}},
+		{"123,456", Money{123456, ""}},
+		{"-1", Money{-1, ""}},
+		{`590""`, Money{590, ""}},
+		{"$1,234.50", Money{1234.50, ""}},
+		{"EUR -12.5", Money{-12.5, "EUR"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "USD", "1 2", "abc"} {
+		if _, err := ParseMoney(in); err == nil {
+			t.Errorf("ParseMoney(%q): expected an error", in)
+		}
+	}
+}
+
+func TestWhatIfMarginImpact(t *testing.T) {
+	var resp WhatIfResponse
+	data := `{"amount":{"amount":"1,977.60 USD (10 Shares)","commission":"1 USD","total":"1,978.60 USD"},` +
+		`"equity":{"current":"123,456","change":"-1","after":"123,455"},` +
+		`"initial":{"current":"1000","change":"652","after":"1652"},` +
+		`"maintenance":{"current":"900","change":"590\"\"","after":"1490"},` +
+		`"position":{"current":"20","change":"10","after":"30"},"warn":"","error":null}`
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		t.Fatal(err)
+	}
+	amount, err := resp.Amount.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount.Total != (Money{1978.60, "USD"}) || amount.Commission != (Money{1, "USD"}) {
+		t.Errorf("unexpected amount %+v", amount)
+	}
+	pos, err := resp.Position.Parse()
+	if err != nil || pos.After.Amount != 30 {
+		t.Errorf("position = %+v, %v", pos, err)
+	}
+	m, err := resp.MarginImpact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MarginImpact{
+		InitialChange: 652, InitialAfter: 1652,
+		MaintenanceChange: 590, MaintenanceAfter: 1490,
+		EquityAfter: 123455, Commission: Money{1, "USD"},
+	}
+	if m != want {
+		t.Errorf("MarginImpact() = %+v, want %+v", m, want)
+	}
+	if got := m.ExcessAfter(); got != 121803 {
+		t.Errorf("ExcessAfter() = %v, want 121803", got)
+	}
+
+	resp.Initial.After = "n/a"
+	if _, err := resp.MarginImpact(); err == nil || !strings.Contains(err.Error(), "initial margin after") {
+		t.Errorf("expected an error naming the field, got %v", err)
+	}
+}
@@ -0,0 +1,332 @@
+// Package proxy fronts a Client Portal Gateway with an HTTP server that every
+// local tool can share.
+//
+// The gateway's rate limits are per session, not per process, so several
+// programs that each throttle themselves can still trip them together. A
+// Server applies one RateLimiter to all of them, caches responses that do not
+// change from one call to the next (contract details, security definitions,
+// the portfolio account lists) for a while, makes account switches wait for requests in
+// flight under the old account, and logs every request. Any HTTP client works
+// through it, including ibclientportal.New(proxyURL). Websocket upgrades
+// (/v1/api/ws) are passed through untouched.
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/ibclientportal"
+)
+
+// apiPrefix is the path prefix of the gateway's REST API. Rule paths, like
+// ibclientportal's RateLimitRules, are relative to it.
+const apiPrefix = "/v1/api"
+
+// CacheRule says how long to cache successful responses to requests whose
+// method is Method and whose path, relative to /v1/api, starts with
+// PathPrefix. The longest matching prefix wins.
+type CacheRule struct {
+	Method     string
+	PathPrefix string
+	TTL        time.Duration
+}
+
+// DefaultCacheRules returns rules for the endpoints whose responses are safe
+// to reuse: contract and security definitions, which change rarely, and the
+// portfolio account lists, which change only when accounts are switched.
+//
+// GET /iserver/accounts is deliberately left out. Each brokerage session must
+// call it before market data works, so a cached answer after a re-login
+// would keep the gateway from seeing the call. Add a rule for it only if
+// every session is sure to have called it directly.
+func DefaultCacheRules() []CacheRule {
+	return []CacheRule{
+		{Method: "GET", PathPrefix: "/iserver/contract/", TTL: time.Hour},
+		{Method: "GET", PathPrefix: "/iserver/secdef/search", TTL: time.Hour},
+		{Method: "POST", PathPrefix: "/iserver/secdef/search", TTL: time.Hour},
+		{Method: "GET", PathPrefix: "/iserver/secdef/info", TTL: time.Hour},
+		{Method: "GET", PathPrefix: "/iserver/secdef/strikes", TTL: 15 * time.Minute},
+		{Method: "GET", PathPrefix: "/trsrv/", TTL: 24 * time.Hour},
+		{Method: "GET", PathPrefix: "/portfolio/accounts", TTL: 5 * time.Minute},
+		{Method: "GET", PathPrefix: "/portfolio/subaccounts", TTL: 5 * time.Minute},
+	}
+}
+
+// Options configures NewServer.
+type Options struct {
+	// Upstream is the gateway's base URL, such as https://localhost:5000. It
+	// is required.
+	Upstream *url.URL
+	// Transport sends requests to the gateway; nil means a clone of
+	// http.DefaultTransport. Set its TLSClientConfig to accept the gateway's
+	// self-signed certificate.
+	Transport http.RoundTripper
+	// RateLimiter is shared by every request; nil means the gateway's
+	// documented limits (ibclientportal.DefaultRateLimitRules).
+	RateLimiter *ibclientportal.RateLimiter
+	// CacheRules lists the cacheable endpoints; nil means DefaultCacheRules.
+	// Pass an empty, non-nil slice to cache nothing.
+	CacheRules []CacheRule
+	// Logger receives a line per request; nil means slog.Default.
+	Logger *slog.Logger
+}
+
+// Server is an http.Handler that forwards requests to the gateway.
+type Server struct {
+	proxy   *httputil.ReverseProxy
+	limiter *ibclientportal.RateLimiter
+	rules   []CacheRule
+	logger  *slog.Logger
+	now     func() time.Time
+
+	// switching is held for writing while an account switch is in flight and
+	// for reading by every other request, so a switch waits for requests
+	// under the old account and holds back new ones until it is done.
+	switching sync.RWMutex
+
+	mu      sync.Mutex
+	cache   map[string]*cacheEntry
+	account string // the last account switched to, for per-account limits
+}
+
+type cacheEntry struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// NewServer returns a Server forwarding to opts.Upstream.
+func NewServer(opts Options) *Server {
+	transport := opts.Transport
+	if transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	limiter := opts.RateLimiter
+	if limiter == nil {
+		limiter = ibclientportal.NewRateLimiter(ibclientportal.DefaultRateLimitRules(), ibclientportal.DefaultGlobalRateLimitInterval)
+	}
+	rules := opts.CacheRules
+	if rules == nil {
+		rules = DefaultCacheRules()
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	upstream := opts.Upstream
+	return &Server{
+		proxy: &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(upstream)
+				r.Out.Host = upstream.Host
+			},
+			Transport: transport,
+		},
+		limiter: limiter,
+		rules:   rules,
+		logger:  logger,
+		now:     time.Now,
+		cache:   make(map[string]*cacheEntry),
+	}
+}
+
+// Purge empties the cache.
+func (s *Server) Purge() {
+	s.mu.Lock()
+	clear(s.cache)
+	s.mu.Unlock()
+}
+
+// ServeHTTP forwards r to the gateway, after waiting for the rate limiter,
+// or answers it from the cache.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := s.now()
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	if path == "/ws" {
+		// A websocket lasts as long as the stream; it is neither rate
+		// limited nor allowed to hold up account switches.
+		s.proxy.ServeHTTP(w, r)
+		s.log(r, http.StatusSwitchingProtocols, start, 0, "")
+		return
+	}
+	switching := r.Method == "POST" && path == "/iserver/account"
+	if switching {
+		s.switching.Lock()
+		defer s.switching.Unlock()
+	} else {
+		s.switching.RLock()
+		defer s.switching.RUnlock()
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	ttl := s.ttl(r.Method, path)
+	key := r.Method + " " + r.URL.RequestURI() + " " + string(body)
+	if ttl > 0 {
+		if e := s.cached(key); e != nil {
+			writeEntry(w, e)
+			s.log(r, e.status, start, 0, "hit")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	account := s.account
+	s.mu.Unlock()
+	release, err := s.limiter.Wait(r.Context(), r.Method, path, account)
+	if err != nil {
+		http.Error(w, "waiting for the rate limiter: "+err.Error(), http.StatusServiceUnavailable)
+		s.log(r, http.StatusServiceUnavailable, start, s.now().Sub(start), "")
+		return
+	}
+	if release != nil {
+		defer release()
+	}
+	waited := s.now().Sub(start)
+
+	if ttl <= 0 && !switching {
+		rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		s.proxy.ServeHTTP(rw, r)
+		s.log(r, rw.status, start, waited, "")
+		return
+	}
+	rec := &recorder{header: make(http.Header), status: http.StatusOK}
+	s.proxy.ServeHTTP(rec, r)
+	e := &cacheEntry{
+		status:  rec.status,
+		header:  rec.header,
+		body:    rec.body.Bytes(),
+		expires: s.now().Add(ttl),
+	}
+	writeEntry(w, e)
+	cache := "miss"
+	if switching {
+		cache = ""
+		if e.status == http.StatusOK {
+			s.switched(body)
+		}
+	} else if e.status == http.StatusOK {
+		// The gateway sets a fresh session cookie on each response; it is
+		// not for replaying to other clients.
+		stored := *e
+		stored.header = e.header.Clone()
+		stored.header.Del("Set-Cookie")
+		s.mu.Lock()
+		s.cache[key] = &stored
+		s.mu.Unlock()
+	}
+	s.log(r, e.status, start, waited, cache)
+}
+
+// switched records a successful account switch: the cached account lists
+// name the old account as selected, so everything cached is dropped.
+func (s *Server) switched(body []byte) {
+	var req struct {
+		AccountID string `json:"acctId"`
+	}
+	json.Unmarshal(body, &req)
+	s.mu.Lock()
+	clear(s.cache)
+	if req.AccountID != "" {
+		s.account = req.AccountID
+	}
+	s.mu.Unlock()
+}
+
+// ttl returns how long to cache the response to a request, or 0 not to.
+func (s *Server) ttl(method, path string) time.Duration {
+	best := -1
+	var ttl time.Duration
+	for _, rule := range s.rules {
+		if !strings.EqualFold(rule.Method, method) || !strings.HasPrefix(path, rule.PathPrefix) {
+			continue
+		}
+		if len(rule.PathPrefix) > best {
+			best, ttl = len(rule.PathPrefix), rule.TTL
+		}
+	}
+	return ttl
+}
+
+func (s *Server) cached(key string) *cacheEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.cache[key]
+	if !ok {
+		return nil
+	}
+	if !s.now().Before(e.expires) {
+		delete(s.cache, key)
+		return nil
+	}
+	return e
+}
+
+func writeEntry(w http.ResponseWriter, e *cacheEntry) {
+	maps.Copy(w.Header(), e.header)
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+func (s *Server) log(r *http.Request, status int, start time.Time, waited time.Duration, cache string) {
+	attrs := []any{
+		"method", r.Method,
+		"path", r.URL.RequestURI(),
+		"status", status,
+		"duration", s.now().Sub(start),
+	}
+	if waited > time.Millisecond {
+		attrs = append(attrs, "rate_limited", waited)
+	}
+	if cache != "" {
+		attrs = append(attrs, "cache", cache)
+	}
+	s.logger.Info("request", attrs...)
+}
+
+// statusWriter records the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController, and so the websocket upgrade in
+// httputil.ReverseProxy, reach the underlying ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recorder buffers a response so it can be cached before it is written.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header         { return r.header }
+func (r *recorder) Write(p []byte) (int, error) { return r.body.Write(p) }
+func (r *recorder) WriteHeader(code int)        { r.status = code }
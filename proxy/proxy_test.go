@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/ibclientportal"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		http.SetCookie(w, &http.Cookie{Name: "x-sess-uuid", Value: "abc"})
+		switch r.URL.Path {
+		case "/v1/api/iserver/account":
+			w.Write([]byte(`{"set":true,"acctId":"U2"}`))
+		case "/v1/api/iserver/accounts":
+			w.Write([]byte(`{"accounts":["U1","U2"],"selectedAccount":"U1"}`))
+		case "/v1/api/trsrv/stocks":
+			w.Write([]byte(`{"AAPL":[{"name":"APPLE INC","contracts":[{"conid":265598,"exchange":"NASDAQ","isUS":true}]}]}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	server := NewServer(Options{
+		Upstream: u,
+		RateLimiter: ibclientportal.NewRateLimiter([]ibclientportal.RateLimitRule{
+			{Method: "POST", PathPrefix: "/tickle", MinInterval: 100 * time.Millisecond},
+		}, 0),
+		// /iserver/accounts is cached here, though not by default, to
+		// check that a switch empties the cache.
+		CacheRules: append(DefaultCacheRules(), CacheRule{Method: "GET", PathPrefix: "/iserver/accounts", TTL: time.Minute}),
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	local := httptest.NewServer(server)
+	defer local.Close()
+	client := ibclientportal.New(local.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	count := func(key string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[key]
+	}
+
+	for range 3 {
+		stocks, err := client.Contracts.Stocks(ctx, url.Values{"symbols": {"AAPL"}})
+		if err != nil {
+			t.Fatalf("Stocks: %v", err)
+		}
+		if len(stocks["AAPL"]) != 1 {
+			t.Fatalf("unexpected stocks response: %+v", stocks)
+		}
+	}
+	if n := count("GET /v1/api/trsrv/stocks"); n != 1 {
+		t.Errorf("upstream saw %d stock lookups, want 1", n)
+	}
+
+	// Rate-limited endpoints are spaced out across all callers.
+	start := time.Now()
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for range 3 {
+		wg.Go(func() {
+			if _, err := client.Tickle(ctx, nil); err != nil {
+				failed.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if failed.Load() != 0 {
+		t.Fatal("tickle failed through the proxy")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("three tickles took %v, want at least 200ms", elapsed)
+	}
+
+	// An account switch empties the cache.
+	if _, err := client.Orders.ListTradableAccounts(ctx); err != nil {
+		t.Fatalf("ListTradableAccounts: %v", err)
+	}
+	if _, err := client.Orders.ListTradableAccounts(ctx); err != nil {
+		t.Fatalf("ListTradableAccounts: %v", err)
+	}
+	if n := count("GET /v1/api/iserver/accounts"); n != 1 {
+		t.Errorf("upstream saw %d account lookups, want 1", n)
+	}
+	if _, err := client.Orders.SwitchAccount(ctx, "U2"); err != nil {
+		t.Fatalf("SwitchAccount: %v", err)
+	}
+	if _, err := client.Orders.ListTradableAccounts(ctx); err != nil {
+		t.Fatalf("ListTradableAccounts: %v", err)
+	}
+	if n := count("GET /v1/api/iserver/accounts"); n != 2 {
+		t.Errorf("upstream saw %d account lookups after a switch, want 2", n)
+	}
+	if server.account != "U2" {
+		t.Errorf("account = %q, want U2", server.account)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	t.Parallel()
+	s := NewServer(Options{Upstream: &url.URL{Scheme: "http", Host: "example.invalid"}})
+	tests := []struct {
+		method, path string
+		want         time.Duration
+	}{
+		{"GET", "/iserver/contract/265598/info", time.Hour},
+		{"GET", "/iserver/secdef/strikes", 15 * time.Minute},
+		{"POST", "/iserver/secdef/search", time.Hour},
+		{"POST", "/iserver/account", 0},
+		{"GET", "/iserver/account/orders", 0},
+		{"GET", "/iserver/accounts", 0},
+	}
+	for _, tt := range tests {
+		if got := s.ttl(tt.method, tt.path); got != tt.want {
+			t.Errorf("ttl(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
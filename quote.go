@@ -0,0 +1,280 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Price is a decoded price field. IB marks some prices with a leading letter
+// rather than sending them bare; those markers are kept here as flags instead
+// of being discarded, because a previous close or a halted price is not a
+// live quote and should not be traded on as one.
+type Price struct {
+	// Value is the price with any marker removed.
+	Value float64
+	// Valid reports whether the field was present and parsed as a price.
+	Valid bool
+	// PreviousClose is set when IB prefixed the price with "C": the contract
+	// has not traded yet today and Value is the prior session's close.
+	PreviousClose bool
+	// Halted is set when IB prefixed the price with "H": trading in the
+	// contract is halted.
+	Halted bool
+	// Marker holds every marker character IB sent before the number,
+	// including ones without a documented meaning. It is empty for a bare
+	// price.
+	Marker string
+}
+
+// ParsePrice decodes a price as IB sends it: a decimal number, possibly with
+// thousands separators, preceded by zero or more marker letters such as "C"
+// (previous close) or "H" (halted).
+func ParsePrice(s string) (Price, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r >= '0' && r <= '9') || r == '-' || r == '+' || r == '.'
+	})
+	if i < 0 {
+		return Price{}, fmt.Errorf("ibclientportal: parsing price %q: no number", s)
+	}
+	marker := strings.TrimSpace(s[:i])
+	f, err := strconv.ParseFloat(strings.ReplaceAll(s[i:], ",", ""), 64)
+	if err != nil {
+		return Price{}, fmt.Errorf("ibclientportal: parsing price %q: %w", s, err)
+	}
+	return Price{
+		Value:         f,
+		Valid:         true,
+		PreviousClose: strings.Contains(marker, "C"),
+		Halted:        strings.Contains(marker, "H"),
+		Marker:        marker,
+	}, nil
+}
+
+// Live reports whether the price is a current quote: present, and not a
+// previous close or a halted price.
+func (p Price) Live() bool {
+	return p.Valid && !p.PreviousClose && !p.Halted
+}
+
+// Number is a decoded numeric field.
+type Number struct {
+	// Value is the number with any abbreviation expanded; a volume sent as
+	// "1.5M" has the Value 1500000.
+	Value float64
+	// Valid reports whether the field was present and parsed as a number.
+	Valid bool
+}
+
+// ParseNumber decodes a number as IB sends it: optionally with thousands
+// separators, a trailing "%", or a K, M or B suffix abbreviating thousands,
+// millions or billions. A percentage is returned in percent, so "1.5%" is 1.5.
+func ParseNumber(s string) (float64, error) {
+	t := strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	t = strings.TrimSuffix(t, "%")
+	mult := 1.0
+	if n := len(t); n > 0 {
+		switch t[n-1] {
+		case 'K', 'k':
+			mult = 1e3
+		case 'M', 'm':
+			mult = 1e6
+		case 'B', 'b':
+			mult = 1e9
+		}
+		if mult != 1 {
+			t = t[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(t, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ibclientportal: parsing number %q: %w", s, err)
+	}
+	return f * mult, nil
+}
+
+// Quote is a typed view of a contract's market-data fields, decoded from a
+// Snapshot or a MarketDataUpdate. A field the source did not carry is left
+// zero: an invalid Price or Number, or an empty string. Because streaming
+// updates are incremental, a Quote decoded from one update holds only the
+// fields that changed in it.
+type Quote struct {
+	Conid       int
+	Symbol      string
+	CompanyName string
+	Exchange    string
+
+	Last       Price
+	Bid        Price
+	Ask        Price
+	High       Price
+	Low        Price
+	Open       Price
+	Close      Price
+	PriorClose Price
+	Mark       Price
+	High52Week Price
+	Low52Week  Price
+
+	BidSize  Number
+	AskSize  Number
+	LastSize Number
+	// Volume is the day's volume. IB abbreviates it ("1.5M"); the value is
+	// expanded. VolumeLong carries the unabbreviated figure when requested.
+	Volume        Number
+	VolumeLong    Number
+	AverageVolume Number
+
+	Change        Number
+	ChangePercent Number
+
+	BidExchange  string
+	AskExchange  string
+	LastExchange string
+
+	ImpliedVolPercent    Number
+	HistoricalVolPercent Number
+	Delta                Number
+	Gamma                Number
+	Theta                Number
+	Vega                 Number
+	OptionOpenInterest   Number
+
+	DividendAmount       Number
+	DividendYieldPercent Number
+	ExDividendDate       string
+	MarketCap            Number
+	PE                   Number
+	EPS                  Number
+	Beta                 Number
+
+	Shortable       string
+	ShortableShares Number
+	FeeRate         Number
+
+	// Availability is the decoded market-data availability (field 6509).
+	Availability Availability
+	// ServerID is IB's market-data delivery marker (field 6119).
+	ServerID string
+}
+
+// Quote decodes the snapshot's fields into a typed Quote.
+func (s Snapshot) Quote() Quote {
+	return decodeQuote(s.Conid, s.Fields)
+}
+
+// Price returns the value of the given field code as a Price, keeping any
+// marker IB sent with it. The second return value reports whether the field
+// was present and parseable.
+func (s Snapshot) Price(field string) (Price, bool) {
+	p := fieldPrice(s.Fields, field)
+	return p, p.Valid
+}
+
+// Quote decodes the fields carried by this update into a typed Quote. Fields
+// that did not change in this update are left zero.
+func (u MarketDataUpdate) Quote() Quote {
+	q := decodeQuote(u.Conid, u.Fields)
+	if u.Availability.Known() {
+		// Carried forward by the Stream even when this update lacks 6509.
+		q.Availability = u.Availability
+	}
+	return q
+}
+
+// Price returns the value of the given field code as a Price, keeping any
+// marker IB sent with it. The second return value reports whether the field
+// was present in this update and parseable.
+func (u MarketDataUpdate) Price(field string) (Price, bool) {
+	p := fieldPrice(u.Fields, field)
+	return p, p.Valid
+}
+
+func fieldPrice(fields map[string]json.RawMessage, field string) Price {
+	s, ok := fieldString(fields, field)
+	if !ok {
+		return Price{}
+	}
+	p, err := ParsePrice(s)
+	if err != nil {
+		return Price{}
+	}
+	return p
+}
+
+func fieldNumber(fields map[string]json.RawMessage, field string) Number {
+	s, ok := fieldString(fields, field)
+	if !ok {
+		return Number{}
+	}
+	f, err := ParseNumber(s)
+	if err != nil {
+		return Number{}
+	}
+	return Number{Value: f, Valid: true}
+}
+
+func fieldText(fields map[string]json.RawMessage, field string) string {
+	s, _ := fieldString(fields, field)
+	return s
+}
+
+func decodeQuote(conid int, f map[string]json.RawMessage) Quote {
+	return Quote{
+		Conid:       conid,
+		Symbol:      fieldText(f, FieldSymbol),
+		CompanyName: fieldText(f, FieldCompanyName),
+		Exchange:    fieldText(f, FieldExchange),
+
+		Last:       fieldPrice(f, FieldLastPrice),
+		Bid:        fieldPrice(f, FieldBidPrice),
+		Ask:        fieldPrice(f, FieldAskPrice),
+		High:       fieldPrice(f, FieldHigh),
+		Low:        fieldPrice(f, FieldLow),
+		Open:       fieldPrice(f, FieldOpen),
+		Close:      fieldPrice(f, FieldClose),
+		PriorClose: fieldPrice(f, FieldPriorClose),
+		Mark:       fieldPrice(f, FieldMark),
+		High52Week: fieldPrice(f, Field52WeekHigh),
+		Low52Week:  fieldPrice(f, Field52WeekLow),
+
+		BidSize:       fieldNumber(f, FieldBidSize),
+		AskSize:       fieldNumber(f, FieldAskSize),
+		LastSize:      fieldNumber(f, FieldLastSize),
+		Volume:        fieldNumber(f, FieldVolume),
+		VolumeLong:    fieldNumber(f, FieldVolumeLong),
+		AverageVolume: fieldNumber(f, FieldAverageVolume),
+
+		Change:        fieldNumber(f, FieldChange),
+		ChangePercent: fieldNumber(f, FieldChangePercent),
+
+		BidExchange:  fieldText(f, FieldBidExchange),
+		AskExchange:  fieldText(f, FieldAskExchange),
+		LastExchange: fieldText(f, FieldLastExchange),
+
+		ImpliedVolPercent:    fieldNumber(f, FieldImpliedVolPercent),
+		HistoricalVolPercent: fieldNumber(f, FieldHistoricalVolPercent),
+		Delta:                fieldNumber(f, FieldDelta),
+		Gamma:                fieldNumber(f, FieldGamma),
+		Theta:                fieldNumber(f, FieldTheta),
+		Vega:                 fieldNumber(f, FieldVega),
+		OptionOpenInterest:   fieldNumber(f, FieldOptionOpenInterest),
+
+		DividendAmount:       fieldNumber(f, FieldDividendAmount),
+		DividendYieldPercent: fieldNumber(f, FieldDividendYieldPercent),
+		ExDividendDate:       fieldText(f, FieldExDividendDate),
+		MarketCap:            fieldNumber(f, FieldMarketCap),
+		PE:                   fieldNumber(f, FieldPE),
+		EPS:                  fieldNumber(f, FieldEPS),
+		Beta:                 fieldNumber(f, FieldBeta),
+
+		Shortable:       fieldText(f, FieldShortable),
+		ShortableShares: fieldNumber(f, FieldShortableShares),
+		FeeRate:         fieldNumber(f, FieldFeeRate),
+
+		Availability: ParseAvailability(fieldText(f, FieldAvailability)),
+		ServerID:     fieldText(f, FieldServerID),
+	}
+}
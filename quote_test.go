@@ -0,0 +1,113 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		in   string
+		want Price
+	}{
+		{"123.45", Price{Value: 123.45, Valid: true}},
+		{"C123.45", Price{Value: 123.45, Valid: true, PreviousClose: true, Marker: "C"}},
+		{"H99.10", Price{Value: 99.10, Valid: true, Halted: true, Marker: "H"}},
+		{"1,234.50", Price{Value: 1234.50, Valid: true}},
+		{"-0.25", Price{Value: -0.25, Valid: true}},
+	}
+	for _, c := range cases {
+		got, err := ParsePrice(c.in)
+		if err != nil {
+			t.Errorf("ParsePrice(%q): %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("ParsePrice(%q) = %#v, want %#v", c.in, got, c.want)
+		}
+	}
+	if _, err := ParsePrice("N/A"); err == nil {
+		t.Error("expected an error for a value with no number")
+	}
+	if p, _ := ParsePrice("C1.00"); p.Live() {
+		t.Error("a previous close must not report as live")
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	t.Parallel()
+	cases := map[string]float64{
+		"1500":    1500,
+		"1.5K":    1500,
+		"2.25M":   2250000,
+		"1.2B":    1.2e9,
+		"1,234":   1234,
+		"-0.87%":  -0.87,
+		" 12.5 ":  12.5,
+		"0.00032": 0.00032,
+	}
+	for in, want := range cases {
+		got, err := ParseNumber(in)
+		if err != nil {
+			t.Errorf("ParseNumber(%q): %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseNumber(%q) = %v, want %v", in, got, want)
+		}
+	}
+	if _, err := ParseNumber("abc"); err == nil {
+		t.Error("expected an error for a non-number")
+	}
+}
+
+func TestQuoteDecoding(t *testing.T) {
+	t.Parallel()
+	raw := []byte(`{"55":"AAPL","31":"C189.50","84":"189.40","86":189.6,"88":"3","85":"12",` +
+		`"87":"1.5M","7762":1523456,"83":"-0.87%","7308":"0.512","6509":"RpB","6119":"q1"}`)
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, q := range map[string]Quote{
+		"snapshot": Snapshot{Conid: 265598, Fields: fields}.Quote(),
+		"update":   MarketDataUpdate{Conid: 265598, Fields: fields}.Quote(),
+	} {
+		if q.Conid != 265598 || q.Symbol != "AAPL" {
+			t.Errorf("%s: unexpected identity %d %q", name, q.Conid, q.Symbol)
+		}
+		if !q.Last.Valid || q.Last.Value != 189.50 || !q.Last.PreviousClose || q.Last.Live() {
+			t.Errorf("%s: expected a previous-close last price, got %#v", name, q.Last)
+		}
+		if !q.Bid.Live() || q.Bid.Value != 189.40 {
+			t.Errorf("%s: unexpected bid %#v", name, q.Bid)
+		}
+		if !q.Ask.Live() || q.Ask.Value != 189.6 {
+			t.Errorf("%s: unexpected ask %#v", name, q.Ask)
+		}
+		if q.Volume != (Number{Value: 1.5e6, Valid: true}) || q.VolumeLong.Value != 1523456 {
+			t.Errorf("%s: unexpected volume %#v / %#v", name, q.Volume, q.VolumeLong)
+		}
+		if q.ChangePercent.Value != -0.87 || q.Delta.Value != 0.512 {
+			t.Errorf("%s: unexpected change %% %v or delta %v", name, q.ChangePercent, q.Delta)
+		}
+		if q.Availability.Raw != "RpB" || !q.Availability.IsRealtime() || q.ServerID != "q1" {
+			t.Errorf("%s: unexpected availability %q or server ID %q", name, q.Availability, q.ServerID)
+		}
+		// Absent fields stay invalid rather than reading as zero.
+		if q.High.Valid || q.Gamma.Valid {
+			t.Errorf("%s: absent fields decoded as present: %#v %#v", name, q.High, q.Gamma)
+		}
+	}
+
+	snap := Snapshot{Conid: 265598, Fields: fields}
+	if p, ok := snap.Price(FieldLastPrice); !ok || p.Marker != "C" {
+		t.Errorf("Price(last) = %#v, %t; want the C marker kept", p, ok)
+	}
+	// Float keeps stripping the marker for existing callers.
+	if f, ok := snap.Float(FieldLastPrice); !ok || f != 189.50 {
+		t.Errorf("Float(last) = %v, %t", f, ok)
+	}
+}
@@ -0,0 +1,257 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"maps"
+	"sync"
+	"time"
+)
+
+// QuoteState is the full latest-value state of one contract in a QuoteBook:
+// every field the stream has reported for it, each with the time it last
+// changed.
+type QuoteState struct {
+	// Conid is the contract identifier.
+	Conid int
+	// Fields maps IB numeric field codes to their latest raw JSON values.
+	Fields map[string]json.RawMessage
+	// FieldUpdated maps each field code in Fields to when it last changed.
+	FieldUpdated map[string]time.Time
+	// Availability is the contract's latest market-data availability.
+	Availability Availability
+	// Updated is when any field last changed.
+	Updated time.Time
+}
+
+// String returns the latest value of the given field code as a string. The
+// second return value reports whether the field has been reported.
+func (q QuoteState) String(field string) (string, bool) {
+	return fieldString(q.Fields, field)
+}
+
+// Float returns the latest value of the given field code as a float64. The
+// second return value reports whether the field has been reported and is
+// parseable.
+func (q QuoteState) Float(field string) (float64, bool) {
+	return fieldFloat(q.Fields, field)
+}
+
+// Price returns the latest value of the given field code as a Price. The
+// second return value reports whether the field has been reported and is
+// parseable.
+func (q QuoteState) Price(field string) (Price, bool) {
+	p := fieldPrice(q.Fields, field)
+	return p, p.Valid
+}
+
+// Quote decodes the state into a typed Quote.
+func (q QuoteState) Quote() Quote {
+	quote := decodeQuote(q.Conid, q.Fields)
+	if q.Availability.Known() {
+		quote.Availability = q.Availability
+	}
+	return quote
+}
+
+// Age returns how long ago the given field last changed, or false if it has
+// not been reported.
+func (q QuoteState) Age(field string, now time.Time) (time.Duration, bool) {
+	t, ok := q.FieldUpdated[field]
+	if !ok {
+		return 0, false
+	}
+	return now.Sub(t), true
+}
+
+func (q *QuoteState) clone() QuoteState {
+	return QuoteState{
+		Conid:        q.Conid,
+		Fields:       maps.Clone(q.Fields),
+		FieldUpdated: maps.Clone(q.FieldUpdated),
+		Availability: q.Availability,
+		Updated:      q.Updated,
+	}
+}
+
+// QuoteBook keeps the latest value of every market-data field for every
+// contract on a Stream, merging the stream's incremental updates so callers
+// do not have to. It is safe for concurrent use.
+//
+// A QuoteBook consumes the stream's Updates channel; do not also read it
+// directly. Subscribe and unsubscribe on the Stream as usual. Any other
+// UpdateSource, such as a Replay of a recording, works the same way.
+type QuoteBook struct {
+	src UpdateSource
+	now func() time.Time
+
+	mu       sync.RWMutex
+	states   map[int]*QuoteState
+	watchers map[int]map[chan QuoteState]struct{}
+	closed   bool
+
+	done chan struct{}
+}
+
+// NewQuoteBook returns a QuoteBook fed by src's updates. It runs until the
+// source ends, at which point Done is closed and every Watch channel is
+// closed. Fields are timestamped with each update's Received time, or the
+// time the book applied it if that is not set.
+func NewQuoteBook(src UpdateSource) *QuoteBook {
+	b := &QuoteBook{
+		src:      src,
+		now:      time.Now,
+		states:   make(map[int]*QuoteState),
+		watchers: make(map[int]map[chan QuoteState]struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *QuoteBook) run() {
+	defer b.finish()
+	for u := range b.src.Updates() {
+		b.apply(u)
+	}
+}
+
+// apply merges an update into its contract's state and notifies watchers.
+func (b *QuoteBook) apply(u MarketDataUpdate) {
+	now := u.Received
+	if now.IsZero() {
+		now = b.now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := b.states[u.Conid]
+	if st == nil {
+		st = &QuoteState{
+			Conid:        u.Conid,
+			Fields:       make(map[string]json.RawMessage, len(u.Fields)),
+			FieldUpdated: make(map[string]time.Time, len(u.Fields)),
+		}
+		b.states[u.Conid] = st
+	}
+	for k, v := range u.Fields {
+		st.Fields[k] = v
+		st.FieldUpdated[k] = now
+	}
+	if u.Availability.Known() {
+		st.Availability = u.Availability
+	}
+	st.Updated = now
+
+	if ws := b.watchers[u.Conid]; len(ws) > 0 {
+		snap := st.clone()
+		for ch := range ws {
+			offerLatest(ch, snap)
+		}
+	}
+}
+
+// offerLatest sends v on ch, a channel with a buffer of one, replacing any
+// value the receiver has not yet taken. Only the QuoteBook's own goroutine
+// sends, so the slot freed by the drain cannot be taken by another sender.
+func offerLatest[T any](ch chan T, v T) {
+	select {
+	case ch <- v:
+		return
+	default:
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- v
+}
+
+func (b *QuoteBook) finish() {
+	b.mu.Lock()
+	b.closed = true
+	for conid, ws := range b.watchers {
+		for ch := range ws {
+			close(ch)
+		}
+		delete(b.watchers, conid)
+	}
+	b.mu.Unlock()
+	close(b.done)
+}
+
+// Get returns the latest state of a contract. The second return value is
+// false if the stream has not delivered an update for it. The returned state
+// is a copy, safe to keep and modify.
+func (b *QuoteBook) Get(conid int) (QuoteState, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	st, ok := b.states[conid]
+	if !ok {
+		return QuoteState{}, false
+	}
+	return st.clone(), true
+}
+
+// Conids returns the contracts the book holds state for.
+func (b *QuoteBook) Conids() []int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	conids := make([]int, 0, len(b.states))
+	for conid := range b.states {
+		conids = append(conids, conid)
+	}
+	return conids
+}
+
+// Watch returns a channel that receives a contract's full state each time it
+// changes, starting with the current state if there is one. The channel is
+// conflated: a slow reader is never blocked on and never sees a backlog, only
+// the most recent state. Call the returned function to stop watching; it
+// closes the channel. The channel is also closed when the stream ends.
+func (b *QuoteBook) Watch(conid int) (<-chan QuoteState, func()) {
+	ch := make(chan QuoteState, 1)
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if st, ok := b.states[conid]; ok {
+		ch <- st.clone()
+	}
+	ws := b.watchers[conid]
+	if ws == nil {
+		ws = make(map[chan QuoteState]struct{})
+		b.watchers[conid] = ws
+	}
+	ws[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if _, ok := b.watchers[conid][ch]; !ok {
+				return // already closed by finish
+			}
+			delete(b.watchers[conid], ch)
+			if len(b.watchers[conid]) == 0 {
+				delete(b.watchers, conid)
+			}
+			close(ch)
+		})
+	}
+	return ch, stop
+}
+
+// Forget drops a contract's state, for example after unsubscribing from it.
+func (b *QuoteBook) Forget(conid int) {
+	b.mu.Lock()
+	delete(b.states, conid)
+	b.mu.Unlock()
+}
+
+// Done is closed when the stream ends and the book stops updating.
+func (b *QuoteBook) Done() <-chan struct{} {
+	return b.done
+}
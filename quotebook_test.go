@@ -0,0 +1,97 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testUpdate(conid int, fields string) MarketDataUpdate {
+	var f map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(fields), &f); err != nil {
+		panic(err)
+	}
+	return MarketDataUpdate{Conid: conid, Fields: f}
+}
+
+func TestQuoteBook(t *testing.T) {
+	t.Parallel()
+
+	updates := make(chan MarketDataUpdate)
+	b := NewQuoteBook(&Stream{updates: &updateSink{ch: updates}})
+	var mu sync.Mutex
+	now := time.Date(2026, 10, 19, 14, 30, 0, 0, time.UTC)
+	// Set before the first update, which is what the book's goroutine waits
+	// on.
+	b.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+
+	if _, ok := b.Get(265598); ok {
+		t.Fatal("expected no state before any update")
+	}
+	watch, stop := b.Watch(265598)
+
+	updates <- testUpdate(265598, `{"31":"190.00","84":"189.99","86":"190.01","6509":"RpB"}`)
+	advance(time.Second)
+	u := testUpdate(265598, `{"31":"190.05"}`)
+	u.Availability = ParseAvailability("RpB")
+	updates <- u
+	updates <- testUpdate(8314, `{"31":"C12.00"}`)
+
+	// The watcher never blocked the book, and sees only the latest state.
+	st := <-watch
+	if last, _ := st.Float(FieldLastPrice); last != 190.05 {
+		t.Errorf("watched last = %v, want the conflated 190.05", last)
+	}
+	stop()
+	if _, ok := <-watch; ok {
+		t.Error("expected the watch channel to be closed by stop")
+	}
+
+	st, ok := b.Get(265598)
+	if !ok {
+		t.Fatal("expected state for 265598")
+	}
+	if bid, _ := st.Float(FieldBidPrice); bid != 189.99 {
+		t.Errorf("bid = %v, want 189.99 carried from the first update", bid)
+	}
+	if age, ok := st.Age(FieldBidPrice, now); !ok || age != time.Second {
+		t.Errorf("bid age = %v, %v; want 1s", age, ok)
+	}
+	if age, ok := st.Age(FieldLastPrice, now); !ok || age != 0 {
+		t.Errorf("last age = %v, %v; want 0", age, ok)
+	}
+	if q := st.Quote(); !q.Availability.IsRealtime() || q.Ask.Value != 190.01 {
+		t.Errorf("unexpected quote: %+v", q)
+	}
+
+	// Get returns a copy.
+	st.Fields[FieldBidPrice] = json.RawMessage(`"0"`)
+	if again, _ := b.Get(265598); string(again.Fields[FieldBidPrice]) != `"189.99"` {
+		t.Error("modifying a returned state changed the book")
+	}
+
+	other, _ := b.Get(8314)
+	if p, ok := other.Price(FieldLastPrice); !ok || !p.PreviousClose {
+		t.Errorf("8314 last = %+v, %v; want a previous close", p, ok)
+	}
+
+	late, _ := b.Watch(8314)
+	if st := <-late; st.Conid != 8314 {
+		t.Errorf("expected Watch to start with the current state, got %+v", st)
+	}
+	close(updates)
+	<-b.Done()
+	if _, ok := <-late; ok {
+		t.Error("expected watch channels to close when the stream ends")
+	}
+}
@@ -0,0 +1,279 @@
+package ibclientportal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRegulatorySnapshotCost is the fee IB charges per regulatory snapshot
+// of a US-listed stock, in USD. NewRegulatorySnapshotBudget uses it when no
+// cost is given; check IB's market-data pricing for other instruments.
+const DefaultRegulatorySnapshotCost = 0.01
+
+// RegulatorySnapshotBudget caps how many regulatory snapshots may be requested
+// per day, and what they are estimated to cost. Each call to
+// RegulatorySnapshot is billed to the account, so the budget is mandatory, and
+// the day's usage is written to a file so that a restarted process does not
+// start again from zero.
+//
+// Create one with NewRegulatorySnapshotBudget. A budget is safe for concurrent
+// use by one process; two processes sharing a file may each spend up to the
+// cap.
+type RegulatorySnapshotBudget struct {
+	path           string
+	maxCallsPerDay int
+	costPerCall    float64
+	maxSpendPerDay float64
+	location       *time.Location
+	now            func() time.Time
+
+	mu sync.Mutex
+}
+
+// RegulatorySnapshotUsage is a day's recorded use of a
+// RegulatorySnapshotBudget. It is also the format of the budget file.
+type RegulatorySnapshotUsage struct {
+	// Day is the date the usage applies to, as YYYY-MM-DD.
+	Day string `json:"day"`
+	// Calls is the number of snapshots requested that day.
+	Calls int `json:"calls"`
+	// Spend is their estimated cost, in USD.
+	Spend float64 `json:"spend"`
+}
+
+// NewRegulatorySnapshotBudget returns a budget allowing maxCallsPerDay
+// regulatory snapshots per day, each estimated to cost costPerCall USD (or
+// DefaultRegulatorySnapshotCost if costPerCall is 0). Usage is persisted to the
+// file at path. A missing file counts as no usage and is written when the
+// first call is recorded; an existing file is read now so a corrupt one is
+// reported before any money is spent. Days are counted in the local time
+// zone; see SetLocation.
+func NewRegulatorySnapshotBudget(path string, maxCallsPerDay int, costPerCall float64) (*RegulatorySnapshotBudget, error) {
+	if path == "" {
+		return nil, errors.New("ibclientportal: regulatory snapshot budget: no file path given")
+	}
+	if maxCallsPerDay <= 0 {
+		return nil, errors.New("ibclientportal: regulatory snapshot budget: the daily call cap must be positive")
+	}
+	if costPerCall < 0 {
+		return nil, errors.New("ibclientportal: regulatory snapshot budget: the cost per call must not be negative")
+	}
+	if costPerCall == 0 {
+		costPerCall = DefaultRegulatorySnapshotCost
+	}
+	b := &RegulatorySnapshotBudget{
+		path:           path,
+		maxCallsPerDay: maxCallsPerDay,
+		costPerCall:    costPerCall,
+		location:       time.Local,
+		now:            time.Now,
+	}
+	if _, err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SetMaxSpendPerDay additionally caps the day's estimated spend, in USD. Zero
+// removes the cap, leaving only the call count.
+func (b *RegulatorySnapshotBudget) SetMaxSpendPerDay(usd float64) {
+	b.mu.Lock()
+	b.maxSpendPerDay = usd
+	b.mu.Unlock()
+}
+
+// SetLocation sets the time zone whose midnight starts a new budget day.
+func (b *RegulatorySnapshotBudget) SetLocation(loc *time.Location) {
+	b.mu.Lock()
+	b.location = loc
+	b.mu.Unlock()
+}
+
+// Usage returns today's recorded usage.
+func (b *RegulatorySnapshotBudget) Usage() (RegulatorySnapshotUsage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.load()
+}
+
+// RegulatorySnapshotBudgetError is returned by RegulatorySnapshot, before any
+// request is made, when the call would exceed the budget.
+type RegulatorySnapshotBudgetError struct {
+	// Usage is the day's usage so far.
+	Usage RegulatorySnapshotUsage
+	// MaxCallsPerDay is the budget's call cap.
+	MaxCallsPerDay int
+	// MaxSpendPerDay is the budget's spend cap, or 0 if it has none.
+	MaxSpendPerDay float64
+}
+
+func (e *RegulatorySnapshotBudgetError) Error() string {
+	if e.MaxSpendPerDay > 0 {
+		return fmt.Sprintf("ibclientportal: regulatory snapshot budget exhausted for %s: %d of %d calls, $%.2f of $%.2f spent",
+			e.Usage.Day, e.Usage.Calls, e.MaxCallsPerDay, e.Usage.Spend, e.MaxSpendPerDay)
+	}
+	return fmt.Sprintf("ibclientportal: regulatory snapshot budget exhausted for %s: %d of %d calls, $%.2f spent",
+		e.Usage.Day, e.Usage.Calls, e.MaxCallsPerDay, e.Usage.Spend)
+}
+
+// reserve records one call against the budget, or returns a
+// *RegulatorySnapshotBudgetError if there is no room for it. The call is
+// recorded before the request is sent, so a crash mid-request still counts.
+func (b *RegulatorySnapshotBudget) reserve() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	usage, err := b.load()
+	if err != nil {
+		return err
+	}
+	if usage.Calls+1 > b.maxCallsPerDay ||
+		(b.maxSpendPerDay > 0 && usage.Spend+b.costPerCall > b.maxSpendPerDay+1e-9) {
+		return &RegulatorySnapshotBudgetError{
+			Usage:          usage,
+			MaxCallsPerDay: b.maxCallsPerDay,
+			MaxSpendPerDay: b.maxSpendPerDay,
+		}
+	}
+	usage.Calls++
+	usage.Spend += b.costPerCall
+	return b.save(usage)
+}
+
+func (b *RegulatorySnapshotBudget) today() string {
+	return b.now().In(b.location).Format(time.DateOnly)
+}
+
+// load reads the budget file, returning zero usage for today if the file does
+// not exist or records an earlier day. b.mu must be held.
+func (b *RegulatorySnapshotBudget) load() (RegulatorySnapshotUsage, error) {
+	today := b.today()
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return RegulatorySnapshotUsage{Day: today}, nil
+	}
+	if err != nil {
+		return RegulatorySnapshotUsage{}, fmt.Errorf("ibclientportal: reading regulatory snapshot budget: %w", err)
+	}
+	var usage RegulatorySnapshotUsage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return RegulatorySnapshotUsage{}, fmt.Errorf("ibclientportal: parsing regulatory snapshot budget %s: %w", b.path, err)
+	}
+	if usage.Day != today {
+		return RegulatorySnapshotUsage{Day: today}, nil
+	}
+	return usage, nil
+}
+
+// save writes usage to the budget file, via a rename so that a crash cannot
+// leave a half-written file behind. b.mu must be held.
+func (b *RegulatorySnapshotBudget) save(usage RegulatorySnapshotUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("ibclientportal: writing regulatory snapshot budget: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ibclientportal: writing regulatory snapshot budget: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ibclientportal: writing regulatory snapshot budget: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), b.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ibclientportal: writing regulatory snapshot budget: %w", err)
+	}
+	return nil
+}
+
+// RegulatorySnapshotResponse is the response from /md/regsnapshot. Like a
+// Snapshot it carries raw field values keyed by IB's numeric field codes; read
+// them with String or Float, or decode them with Quote.
+type RegulatorySnapshotResponse struct {
+	// Conid is the contract identifier.
+	Conid int
+	// ConidEx is the contract ID and routing destination.
+	ConidEx string
+	// HasDelayed reports whether delayed data is available for the contract.
+	HasDelayed bool
+	// Fields maps IB numeric field codes to their raw JSON values: last, bid
+	// and ask prices and sizes, and the exchanges quoting them.
+	Fields map[string]json.RawMessage
+}
+
+// String returns the value of the given field code as a string. The second
+// return value reports whether the field was present.
+func (r RegulatorySnapshotResponse) String(field string) (string, bool) {
+	return fieldString(r.Fields, field)
+}
+
+// Float returns the value of the given field code as a float64. The second
+// return value reports whether the field was present and parseable.
+func (r RegulatorySnapshotResponse) Float(field string) (float64, bool) {
+	return fieldFloat(r.Fields, field)
+}
+
+// Quote decodes the response's fields into a typed Quote.
+func (r RegulatorySnapshotResponse) Quote() Quote {
+	return decodeQuote(r.Conid, r.Fields)
+}
+
+// RegulatorySnapshot requests a regulatory snapshot for a contract: a
+// top-of-book quote that is available without a streaming market-data
+// subscription. IB charges the account for every call (see
+// DefaultRegulatorySnapshotCost), and regulatory snapshots are not available
+// to paper accounts.
+//
+// Because every call costs money, a budget is required. The call is recorded
+// against it before the request is sent, and once the day's cap is reached
+// RegulatorySnapshot returns a *RegulatorySnapshotBudgetError without calling
+// the gateway.
+func (m *MarketDataService) RegulatorySnapshot(ctx context.Context, budget *RegulatorySnapshotBudget, conid int) (RegulatorySnapshotResponse, error) {
+	var val RegulatorySnapshotResponse
+	if budget == nil {
+		return val, errors.New("ibclientportal: RegulatorySnapshot: a budget is required; see NewRegulatorySnapshotBudget")
+	}
+	if conid == 0 {
+		return val, errors.New("ibclientportal: RegulatorySnapshot: no conid given")
+	}
+	if err := budget.reserve(); err != nil {
+		return val, err
+	}
+	query := url.Values{"conid": []string{strconv.Itoa(conid)}}
+	var row map[string]json.RawMessage
+	if err := m.client.ListResource(ctx, "/md/regsnapshot", query, &row); err != nil {
+		return val, err
+	}
+	val.Fields = make(map[string]json.RawMessage, len(row))
+	for k, v := range row {
+		switch k {
+		case "conid":
+			_ = json.Unmarshal(v, &val.Conid)
+		case "conidEx":
+			_ = json.Unmarshal(v, &val.ConidEx)
+		case "HasDelayed":
+			_ = json.Unmarshal(v, &val.HasDelayed)
+		default:
+			// Keep the numeric field codes; drop IB's internal bookkeeping
+			// keys (sizeMinTick, BboExchange, BestBidExch and so on).
+			if _, err := strconv.Atoi(k); err == nil {
+				val.Fields[k] = v
+			}
+		}
+	}
+	return val, nil
+}
@@ -0,0 +1,138 @@
+package ibclientportal
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegulatorySnapshot(t *testing.T) {
+	var calls atomic.Int32
+	infoCh := make(chan requestInfo, 1)
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		infoCh <- requestInfo{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"conid":265598,"conidEx":"265598","sizeMinTick":0.0001,` +
+			`"BboExchange":"9c0001","HasDelayed":false,"84":"192.26","86":"192.27",` +
+			`"88":"3,100","85":"600","BestBidExch":1,"31":"192.27","7059":"100",` +
+			`"7068":"1","7057":"1","7058":"1"}`))
+	})
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "regsnapshot.json")
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	newBudget := func() *RegulatorySnapshotBudget {
+		t.Helper()
+		b, err := NewRegulatorySnapshotBudget(path, 2, 0)
+		if err != nil {
+			t.Fatalf("new budget: %v", err)
+		}
+		b.SetLocation(time.UTC)
+		b.now = func() time.Time { return now }
+		return b
+	}
+	budget := newBudget()
+
+	snap, err := client.MarketData.RegulatorySnapshot(testContext(t), budget, 265598)
+	if err != nil {
+		t.Fatalf("regulatory snapshot: %v", err)
+	}
+	info := <-infoCh
+	if info.method != http.MethodGet || info.path != "/v1/api/md/regsnapshot" || info.query != "conid=265598" {
+		t.Errorf("unexpected request: %+v", info)
+	}
+	if snap.Conid != 265598 || snap.ConidEx != "265598" || snap.HasDelayed {
+		t.Errorf("unexpected response: %+v", snap)
+	}
+	if _, ok := snap.Fields["BboExchange"]; ok {
+		t.Error("expected non-numeric keys to be dropped from Fields")
+	}
+	q := snap.Quote()
+	if q.Bid.Value != 192.26 || q.Ask.Value != 192.27 || q.BidSize.Value != 3100 {
+		t.Errorf("unexpected quote: %+v", q)
+	}
+
+	// A restarted process picks up where the last one left off.
+	budget = newBudget()
+	usage, err := budget.Usage()
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	if usage.Day != "2026-10-19" || usage.Calls != 1 || usage.Spend != DefaultRegulatorySnapshotCost {
+		t.Errorf("unexpected usage after restart: %+v", usage)
+	}
+	if _, err := client.MarketData.RegulatorySnapshot(testContext(t), budget, 265598); err != nil {
+		t.Fatalf("second regulatory snapshot: %v", err)
+	}
+	<-infoCh
+
+	_, err = client.MarketData.RegulatorySnapshot(testContext(t), budget, 265598)
+	var budgetErr *RegulatorySnapshotBudgetError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected a budget error, got %v", err)
+	}
+	if budgetErr.Usage.Calls != 2 || budgetErr.MaxCallsPerDay != 2 {
+		t.Errorf("unexpected budget error: %+v", budgetErr)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected the gateway to be called 2 times, got %d", n)
+	}
+
+	// The budget resets the next day.
+	now = now.Add(24 * time.Hour)
+	if _, err := client.MarketData.RegulatorySnapshot(testContext(t), budget, 265598); err != nil {
+		t.Fatalf("next-day regulatory snapshot: %v", err)
+	}
+	<-infoCh
+}
+
+func TestRegulatorySnapshotBudgetRequired(t *testing.T) {
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+	})
+	defer server.Close()
+
+	if _, err := client.MarketData.RegulatorySnapshot(testContext(t), nil, 265598); err == nil {
+		t.Error("expected an error without a budget")
+	}
+	if _, err := NewRegulatorySnapshotBudget(filepath.Join(t.TempDir(), "b.json"), 0, 0); err == nil {
+		t.Error("expected an error for a zero call cap")
+	}
+
+	path := filepath.Join(t.TempDir(), "b.json")
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRegulatorySnapshotBudget(path, 10, 0); err == nil {
+		t.Error("expected an error for a corrupt budget file")
+	}
+}
+
+func TestRegulatorySnapshotSpendCap(t *testing.T) {
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"conid":265598}`))
+	})
+	defer server.Close()
+
+	budget, err := NewRegulatorySnapshotBudget(filepath.Join(t.TempDir(), "b.json"), 100, 0.03)
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget.SetMaxSpendPerDay(0.06)
+	for i := 0; i < 2; i++ {
+		if _, err := client.MarketData.RegulatorySnapshot(testContext(t), budget, 265598); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	_, err = client.MarketData.RegulatorySnapshot(testContext(t), budget, 265598)
+	var budgetErr *RegulatorySnapshotBudgetError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected a budget error once spend reaches the cap, got %v", err)
+	}
+}
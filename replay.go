@@ -0,0 +1,290 @@
+package ibclientportal
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// UpdateSource is anything that delivers market-data updates the way a Stream
+// does: on a channel that is closed when the source ends, after which Err
+// reports why. *Stream, *Recorder and *Replay implement it, so code written
+// against an UpdateSource runs the same on live data, while recording it, and
+// against a recording.
+type UpdateSource interface {
+	// Updates returns the channel of updates. It is closed when the source
+	// ends.
+	Updates() <-chan MarketDataUpdate
+	// Err returns the error that ended the source, or nil if it ended
+	// cleanly. It is only meaningful after Updates is closed.
+	Err() error
+	// Close stops the source.
+	Close() error
+}
+
+// tickRecord is one line of a recording: an update and when it was received.
+type tickRecord struct {
+	Received     time.Time                  `json:"t"`
+	Conid        int                        `json:"conid"`
+	Topic        string                     `json:"topic,omitempty"`
+	Fields       map[string]json.RawMessage `json:"fields"`
+	Availability string                     `json:"avail,omitempty"`
+}
+
+// Recorder writes every update from an UpdateSource to a gzip-compressed JSON
+// Lines file, one update per line with the time it was received (Received, or
+// the time the Recorder read it if that is not set), and passes the updates on
+// unchanged on its own Updates channel. Read the recording back with NewReplay
+// or OpenReplay.
+//
+// A Recorder consumes its source's Updates channel, so read from the
+// Recorder instead; its channel must be drained even if only the recording is
+// wanted. When the source ends the Recorder flushes the file and then closes
+// Updates, so once Updates is closed the underlying writer can be closed.
+//
+// Only what the source's Updates channel carries is recorded. For a *Stream
+// that is the conids subscribed with SubscribeMarketData: a conid held only
+// through Subscribe handles is delivered on their channels, not on Updates,
+// and is missing from the recording.
+type Recorder struct {
+	src     UpdateSource
+	updates chan MarketDataUpdate
+	zw      *gzip.Writer
+	now     func() time.Time
+	done    chan struct{}
+	close   sync.Once
+
+	mu  sync.Mutex
+	err error // first write error
+	n   int
+}
+
+// NewRecorder starts recording src's updates to w. It does not close w.
+func NewRecorder(src UpdateSource, w io.Writer) *Recorder {
+	r := &Recorder{
+		src:     src,
+		updates: make(chan MarketDataUpdate),
+		zw:      gzip.NewWriter(w),
+		now:     time.Now,
+		done:    make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *Recorder) run() {
+	defer close(r.updates)
+	enc := json.NewEncoder(r.zw)
+	for u := range r.src.Updates() {
+		received := u.Received
+		if received.IsZero() {
+			received = r.now()
+		}
+		r.mu.Lock()
+		if r.err == nil {
+			err := enc.Encode(tickRecord{
+				Received:     received.UTC(),
+				Conid:        u.Conid,
+				Topic:        u.Topic,
+				Fields:       u.Fields,
+				Availability: u.Availability.Raw,
+			})
+			if err != nil {
+				r.err = err
+			} else {
+				r.n++
+			}
+		}
+		r.mu.Unlock()
+		// After Close nobody may be reading, and the source still has to be
+		// drained so that the recording can be finished.
+		select {
+		case r.updates <- u:
+		case <-r.done:
+		}
+	}
+	if err := r.zw.Close(); err != nil {
+		r.mu.Lock()
+		if r.err == nil {
+			r.err = err
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Updates returns the source's updates, delivered after each has been
+// written. It is closed once the source has ended and the recording has been
+// flushed.
+func (r *Recorder) Updates() <-chan MarketDataUpdate {
+	return r.updates
+}
+
+// Err returns the error that ended the source, or else the first error
+// writing the recording. A write error does not stop updates being passed on,
+// but nothing more is recorded after it.
+func (r *Recorder) Err() error {
+	if err := r.src.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Recorded returns the number of updates written so far.
+func (r *Recorder) Recorded() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n
+}
+
+// Close closes the source. Updates the source delivers after Close are still
+// recorded but may not be passed on, so Updates need not be read any further.
+// The recording is complete once Updates is closed.
+func (r *Recorder) Close() error {
+	r.close.Do(func() { close(r.done) })
+	return r.src.Close()
+}
+
+// ReplayOptions configures NewReplay and OpenReplay. The zero value replays
+// at the original pace.
+type ReplayOptions struct {
+	// Speed multiplies the original pace: 2 replays an hour of updates in
+	// half an hour. 0 means 1.
+	Speed float64
+	// Unpaced delivers updates as fast as they are read, ignoring the
+	// recorded timing. Speed is then ignored.
+	Unpaced bool
+}
+
+// Replay delivers the updates in a Recorder's recording as an UpdateSource,
+// with the gaps between them as recorded, scaled, or removed. Each update
+// carries the Received time and Availability it was recorded with, so code
+// that timestamps updates by Received sees the original times.
+type Replay struct {
+	opts    ReplayOptions
+	updates chan MarketDataUpdate
+	done    chan struct{}
+	close   sync.Once
+	closer  io.Closer
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewReplay starts replaying the recording read from r. It returns an error
+// if r does not start with a gzip header.
+func NewReplay(r io.Reader, opts ReplayOptions) (*Replay, error) {
+	return newReplay(r, opts, nil)
+}
+
+// newReplay is NewReplay, closing closer, if it is not nil, when the replay
+// ends. It is set before the replay starts, since run reads it.
+func newReplay(r io.Reader, opts ReplayOptions, closer io.Closer) (*Replay, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	p := &Replay{
+		opts:    opts,
+		updates: make(chan MarketDataUpdate),
+		done:    make(chan struct{}),
+		closer:  closer,
+	}
+	go p.run(zr)
+	return p, nil
+}
+
+// OpenReplay starts replaying the recording in the named file, which is closed
+// when the replay ends.
+func OpenReplay(path string, opts ReplayOptions) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newReplay(f, opts, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Replay) run(zr *gzip.Reader) {
+	defer close(p.updates)
+	if p.closer != nil {
+		defer p.closer.Close()
+	}
+	dec := json.NewDecoder(zr)
+	var first time.Time
+	var start time.Time
+	for {
+		var rec tickRecord
+		if err := dec.Decode(&rec); err != nil {
+			if !errors.Is(err, io.EOF) {
+				p.setErr(err)
+			}
+			return
+		}
+		if first.IsZero() {
+			first, start = rec.Received, time.Now()
+		} else if !p.opts.Unpaced {
+			offset := time.Duration(float64(rec.Received.Sub(first)) / p.opts.Speed)
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-t.C:
+				case <-p.done:
+					t.Stop()
+					return
+				}
+			}
+		}
+		u := MarketDataUpdate{
+			Conid:        rec.Conid,
+			Topic:        rec.Topic,
+			Received:     rec.Received,
+			Fields:       rec.Fields,
+			Availability: ParseAvailability(rec.Availability),
+		}
+		select {
+		case p.updates <- u:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Replay) setErr(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+}
+
+// Updates returns the channel on which the recorded updates are delivered. It
+// is closed at the end of the recording or after Close.
+func (p *Replay) Updates() <-chan MarketDataUpdate {
+	return p.updates
+}
+
+// Err returns the error that ended the replay early, such as a recording
+// truncated by a crash, or nil if it reached the end or was closed. Every
+// complete update before the error is delivered first.
+func (p *Replay) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Close stops the replay. Updates is closed soon after.
+func (p *Replay) Close() error {
+	p.close.Do(func() { close(p.done) })
+	return nil
+}
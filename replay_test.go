@@ -0,0 +1,233 @@
+package ibclientportal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	_ UpdateSource = (*Stream)(nil)
+	_ UpdateSource = (*Recorder)(nil)
+	_ UpdateSource = (*Replay)(nil)
+)
+
+// chanSource is an UpdateSource fed by a test.
+type chanSource struct {
+	ch  chan MarketDataUpdate
+	err error
+}
+
+func (c *chanSource) Updates() <-chan MarketDataUpdate { return c.ch }
+func (c *chanSource) Err() error                       { return c.err }
+func (c *chanSource) Close() error                     { return nil }
+
+func record(t *testing.T, updates ...MarketDataUpdate) []byte {
+	t.Helper()
+	src := &chanSource{ch: make(chan MarketDataUpdate)}
+	var buf bytes.Buffer
+	rec := NewRecorder(src, &buf)
+	go func() {
+		for _, u := range updates {
+			src.ch <- u
+		}
+		close(src.ch)
+	}()
+	n := 0
+	for u := range rec.Updates() {
+		if u.Conid != updates[n].Conid {
+			t.Errorf("passed on conid %d, want %d", u.Conid, updates[n].Conid)
+		}
+		n++
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	if rec.Recorded() != len(updates) {
+		t.Errorf("Recorded() = %d, want %d", rec.Recorded(), len(updates))
+	}
+	return buf.Bytes()
+}
+
+func TestRecordReplay(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2026, 10, 19, 13, 30, 0, 0, time.UTC)
+	a := testUpdate(265598, `{"31":"190.00","84":"189.99"}`)
+	a.Topic = "smd+265598"
+	a.Received = start
+	a.Availability = ParseAvailability("RpB")
+	b := testUpdate(8314, `{"31":12.5}`)
+	b.Received = start.Add(200 * time.Millisecond)
+	data := record(t, a, b)
+
+	p, err := NewReplay(bytes.NewReader(data), ReplayOptions{Speed: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	began := time.Now()
+	var got []MarketDataUpdate
+	for u := range p.Updates() {
+		got = append(got, u)
+	}
+	elapsed := time.Since(began)
+	if err := p.Err(); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("replayed %d updates, want 2", len(got))
+	}
+	if elapsed < 50*time.Millisecond {
+		t.Errorf("replay at 4x took %v, want at least 50ms", elapsed)
+	}
+	if got[0].Topic != "smd+265598" || !got[0].Received.Equal(start) {
+		t.Errorf("first update = %+v", got[0])
+	}
+	if got[0].Availability.Status != AvailabilityRealtime {
+		t.Errorf("availability = %v, want realtime", got[0].Availability)
+	}
+	if bid, _ := got[0].Float(FieldBidPrice); bid != 189.99 {
+		t.Errorf("bid = %v, want 189.99", bid)
+	}
+	if last, _ := got[1].Float(FieldLastPrice); last != 12.5 {
+		t.Errorf("last = %v, want 12.5", last)
+	}
+
+	// A QuoteBook over a replay timestamps fields with the recorded times.
+	p, err = NewReplay(bytes.NewReader(data), ReplayOptions{Unpaced: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := NewQuoteBook(p)
+	<-book.Done()
+	st, ok := book.Get(8314)
+	if !ok {
+		t.Fatal("expected state for 8314")
+	}
+	if !st.Updated.Equal(b.Received) {
+		t.Errorf("Updated = %v, want the recorded %v", st.Updated, b.Received)
+	}
+}
+
+func TestReplayTruncated(t *testing.T) {
+	t.Parallel()
+	data := record(t, testUpdate(1, `{"31":"1"}`), testUpdate(2, `{"31":"2"}`))
+	// Decompress, cut the second line short, and recompress, as if the
+	// recording process died mid-write.
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(raw[:len(raw)-5])
+	zw.Close()
+
+	p, err := NewReplay(&buf, ReplayOptions{Unpaced: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range p.Updates() {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("replayed %d updates, want the 1 complete one", n)
+	}
+	if err := p.Err(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Err() = %v, want unexpected EOF", err)
+	}
+
+	if _, err := NewReplay(bytes.NewReader([]byte("not gzip")), ReplayOptions{}); err == nil {
+		t.Error("expected an error for a file that is not gzip")
+	}
+}
+
+func TestOpenReplay(t *testing.T) {
+	t.Parallel()
+	data := record(t, testUpdate(1, `{"31":"1"}`), testUpdate(2, `{"31":"2"}`))
+	path := filepath.Join(t.TempDir(), "ticks.jsonl.gz")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := OpenReplay(path, ReplayOptions{Unpaced: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range p.Updates() {
+		n++
+	}
+	if n != 2 || p.Err() != nil {
+		t.Errorf("replayed %d updates, err %v; want 2, nil", n, p.Err())
+	}
+	if _, err := OpenReplay(filepath.Join(t.TempDir(), "missing"), ReplayOptions{}); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+type closeCounter struct{ n atomic.Int32 }
+
+func (c *closeCounter) Close() error {
+	c.n.Add(1)
+	return nil
+}
+
+func TestReplayClosesReader(t *testing.T) {
+	t.Parallel()
+	data := record(t, testUpdate(1, `{"31":"1"}`))
+	var c closeCounter
+	p, err := newReplay(bytes.NewReader(data), ReplayOptions{Unpaced: true}, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range p.Updates() {
+	}
+	if n := c.n.Load(); n != 1 {
+		t.Errorf("closed %d times, want 1", n)
+	}
+}
+
+// A Recorder whose reader has gone away after Close still finishes the
+// recording.
+func TestRecorderCloseWithoutReader(t *testing.T) {
+	t.Parallel()
+	src := &chanSource{ch: make(chan MarketDataUpdate)}
+	var buf bytes.Buffer
+	rec := NewRecorder(src, &buf)
+	src.ch <- testUpdate(1, `{"31":"1"}`)
+	<-rec.Updates()
+	rec.Close()
+	src.ch <- testUpdate(2, `{"31":"2"}`)
+	close(src.ch)
+	n := 0
+	for range rec.Updates() {
+		n++
+	}
+	if n != 0 {
+		t.Errorf("passed on %d updates after Close, want 0", n)
+	}
+	if rec.Recorded() != 2 {
+		t.Errorf("Recorded() = %d, want 2", rec.Recorded())
+	}
+	p, err := NewReplay(&buf, ReplayOptions{Unpaced: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = 0
+	for range p.Updates() {
+		n++
+	}
+	if n != 2 || p.Err() != nil {
+		t.Errorf("replayed %d updates, err %v; want 2, nil", n, p.Err())
+	}
+}
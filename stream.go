@@ -3,45 +3,20 @@ package ibclientportal
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-// Market data field codes used by the streaming websocket (and the REST
-// /iserver/marketdata/snapshot endpoint). These are passed in the fields
-// argument to (*Stream).SubscribeMarketData and appear as keys in the
-// MarketDataUpdate.Fields map. This is a commonly-used subset; any numeric
-// field code documented by IBKR may be requested as a raw string.
-//
-// https://www.interactivebrokers.com/campus/ibkr-api-page/cpapi-v1/#market-data-fields
-const (
-	FieldLastPrice     = "31"
-	FieldSymbol        = "55"
-	FieldText          = "58"
-	FieldHigh          = "70"
-	FieldLow           = "71"
-	FieldMarketValue   = "73"
-	FieldAvgPrice      = "74"
-	FieldUnrealizedPnL = "75"
-	FieldChange        = "82"
-	FieldChangePercent = "83"
-	FieldBidPrice      = "84"
-	FieldAskSize       = "85"
-	FieldAskPrice      = "86"
-	FieldVolume        = "87"
-	FieldBidSize       = "88"
-	FieldExchange      = "6004"
-	FieldConid         = "6008"
-	FieldCompanyName   = "7051"
-)
-
 const (
 	// defaultHeartbeatInterval is how often the Stream sends a "tic" keep-alive
 	// to the gateway. IBKR recommends periodic keep-alives to avoid the
@@ -62,6 +37,9 @@ const (
 	connectTimeout      = 45 * time.Second
 )
 
+// errStreamEnded is returned by subscriptions made after the Stream has ended.
+var errStreamEnded = errors.New("ibclientportal: streaming: the stream has ended")
+
 // wsDebugf writes a diagnostic line to stderr when IBCP_WS_DEBUG is set. It is
 // the single debug hook for the streaming code; it is silent by default.
 func wsDebugf(format string, args ...any) {
@@ -80,10 +58,18 @@ type MarketDataUpdate struct {
 	Conid int
 	// Topic is the raw websocket topic, e.g. "smd+265598".
 	Topic string
+	// Received is when the Stream read the message from the websocket.
+	Received time.Time
 	// Fields maps IB numeric field codes (see the Field* constants) to their
 	// raw JSON values. Values may be JSON strings or numbers depending on the
 	// field; use String or Float to read them without caring which.
 	Fields map[string]json.RawMessage
+	// Availability is the contract's market-data availability as of this
+	// update. The gateway sends field 6509 only when it changes, so the Stream
+	// carries the last reported value forward onto every update for the
+	// conid. Its Status is AvailabilityUnknown until the gateway reports it,
+	// which it does only when field 6509 is subscribed; see WatchAvailability.
+	Availability Availability
 }
 
 // String returns the value of the given field code as a string, stripping
@@ -94,8 +80,9 @@ func (u MarketDataUpdate) String(field string) (string, bool) {
 }
 
 // Float returns the value of the given field code as a float64. It handles
-// both JSON-number and JSON-string encodings. The second return value reports
-// whether the field was present and parseable as a number.
+// both JSON-number and JSON-string encodings. Price markers are stripped; use
+// Price to see them. The second return value reports whether the field was
+// present and parseable as a number.
 func (u MarketDataUpdate) Float(field string) (float64, bool) {
 	return fieldFloat(u.Fields, field)
 }
@@ -110,7 +97,13 @@ func (u MarketDataUpdate) Float(field string) (float64, bool) {
 type Stream struct {
 	client  *Client
 	ctx     context.Context // governs the stream lifetime, including reconnects
-	updates chan MarketDataUpdate
+	updates *updateSink
+
+	// bufferSize and backpressure configure the sinks of Updates and of each
+	// Subscription; dropped counts the updates they discarded or merged.
+	bufferSize   int
+	backpressure BackpressurePolicy
+	dropped      atomic.Uint64
 
 	// resubscribeInterval is how often active subscriptions are renewed; set
 	// from defaultResubscribeInterval in DialStream. It is fixed once the
@@ -124,6 +117,57 @@ type Stream struct {
 
 	subsMu sync.Mutex
 	subs   map[int][]string // conid -> requested fields, replayed on reconnect
+	// direct holds the fields requested by SubscribeMarketData and handles
+	// the Subscriptions open on each conid; subs is the union of the two.
+	direct  map[int][]string
+	handles map[int][]*Subscription
+	// watchdog is set by StartWatchdog, which also creates lastTick: when
+	// each subscribed conid last received an update.
+	watchdog *watchdog
+	lastTick map[int]time.Time
+	// avail is the last availability each subscribed conid reported.
+	avail map[int]Availability
+	// availEvents is created by WatchAvailability; while it is non-nil every
+	// subscription also requests the availability field.
+	availEvents  chan AvailabilityEvent
+	eventsClosed bool
+	// topics maps each subscribed non-market-data topic to the frame that
+	// subscribes to it, replayed on reconnect. Topics the gateway sends
+	// unprompted map to "".
+	topics        map[string]string
+	orders        topicFeed[Order]
+	trades        topicFeed[Trade]
+	pnl           topicFeed[PnLUpdate]
+	summary       topicFeed[AccountSummaryUpdate]
+	ledger        topicFeed[LedgerEntry]
+	notifications topicFeed[Notification]
+	bulletins     topicFeed[Bulletin]
+	accounts      topicFeed[TradableAccountsResponse]
+	authStatus    topicFeed[AuthStatusResponse]
+	// orderEvents is set while SubscribeOrders is in effect, and
+	// orderWaiters holds the WaitForOrder calls in progress; the "sor" topic
+	// stays subscribed while either wants it. orderTopicMu is held from
+	// deciding to subscribe or unsubscribe until the message is sent, so
+	// the gateway receives them in the order they were decided.
+	orderEvents  bool
+	orderWaiters map[*orderWaiter]struct{}
+	orderTopicMu sync.Mutex
+	// history tracks SubscribeHistory subscriptions by conid; they are
+	// replayed through topics.
+	history map[int]*historySub
+	// historyOrphans counts, by conid, the subscriptions SubscribeHistory
+	// replaced before their first frame, whose server IDs are still to be
+	// learned and released. historyReleased holds the server IDs released on
+	// this connection, whose late frames are ignored.
+	historyOrphans  map[int]int
+	historyReleased map[string]struct{}
+	bars            topicFeed[HistoryUpdate]
+	// depth holds each SubscribeBook book, keyed by its topic.
+	depth map[string]*orderBook
+	books topicFeed[OrderBook]
+	// state is the connection's current state, reported on states.
+	state  StreamStateEvent
+	states topicFeed[StreamStateEvent]
 
 	closeOnce sync.Once
 	done      chan struct{}
@@ -171,12 +215,30 @@ func (c *Client) wsURL() string {
 //
 // If SetInsecureSkipVerify was called on the client (typical for the default
 // self-signed localhost gateway), the websocket dial reuses that TLS setting.
+//
+// DialStream buffers 256 updates and, once the buffer is full, waits for the
+// consumer; see DialStreamWithOptions to change that.
 func (c *Client) DialStream(ctx context.Context) (*Stream, error) {
+	return c.DialStreamWithOptions(ctx, DialStreamOptions{})
+}
+
+// DialStreamWithOptions is DialStream with control over how market-data
+// updates are buffered for a slow consumer.
+func (c *Client) DialStreamWithOptions(ctx context.Context, opts DialStreamOptions) (*Stream, error) {
 	s := &Stream{
 		client:              c,
 		ctx:                 ctx,
-		updates:             make(chan MarketDataUpdate, 256),
+		bufferSize:          opts.BufferSize,
+		backpressure:        opts.Backpressure,
 		subs:                make(map[int][]string),
+		direct:              make(map[int][]string),
+		handles:             make(map[int][]*Subscription),
+		avail:               make(map[int]Availability),
+		topics:              make(map[string]string),
+		history:             make(map[int]*historySub),
+		historyOrphans:      make(map[int]int),
+		historyReleased:     make(map[string]struct{}),
+		depth:               make(map[string]*orderBook),
 		done:                make(chan struct{}),
 		resubscribeInterval: defaultResubscribeInterval,
 	}
@@ -185,7 +247,9 @@ func (c *Client) DialStream(ctx context.Context) (*Stream, error) {
 		return nil, err
 	}
 	s.setConn(conn)
+	s.state = StreamStateEvent{State: StateEstablished, Time: time.Now()}
 
+	s.updates = s.newSink()
 	go s.supervise()
 	go s.heartbeatLoop()
 	go s.renewLoop()
@@ -285,12 +349,18 @@ func awaitEstablished(ctx context.Context, conn *websocket.Conn) error {
 // capped exponential backoff and replays every active subscription. It runs for
 // the life of the Stream and closes the Updates channel when the stream ends.
 func (s *Stream) supervise() {
-	defer close(s.updates)
+	defer s.updates.close()
+	// Lines are released after eventsClosed is set, so that a subscription
+	// racing with the end of the stream either sees it and gives its line
+	// back or is recorded in time for releaseLines.
+	defer s.releaseLines()
+	defer s.closeEvents()
+	defer func() { s.setState(StreamStateEvent{State: StateClosed, Err: s.Err()}) }()
 
 	backoff := reconnectMinBackoff
 	for {
 		conn := s.currentConn()
-		s.readConn(conn)
+		readErr := s.readConn(conn)
 		conn.Close() // the connection has failed (or Close was called); free it.
 
 		select {
@@ -304,7 +374,10 @@ func (s *Stream) supervise() {
 		}
 
 		wsDebugf("connection lost; reconnecting")
-		for {
+		s.setState(StreamStateEvent{State: StateDisconnected, Err: readErr})
+		var lastErr error
+		for attempt := 1; ; attempt++ {
+			s.setState(StreamStateEvent{State: StateReconnecting, Attempt: attempt, Backoff: backoff, Err: lastErr})
 			if !s.wait(backoff) {
 				if err := s.ctx.Err(); err != nil {
 					s.setErr(err)
@@ -313,15 +386,22 @@ func (s *Stream) supervise() {
 			}
 			backoff = min(backoff*2, reconnectMaxBackoff)
 
+			s.setState(StreamStateEvent{State: StateConnecting, Attempt: attempt})
 			attemptCtx, cancel := context.WithTimeout(s.ctx, connectTimeout)
 			newConn, err := s.connect(attemptCtx)
 			cancel()
 			if err != nil {
 				wsDebugf("reconnect failed: %v", err)
+				lastErr = err
 				continue
 			}
 			s.setConn(newConn)
+			s.setState(StreamStateEvent{State: StateEstablished, Attempt: attempt})
 			s.resubscribeAll()
+			s.forgetHistoryServerIDs()
+			s.resetBooks()
+			s.replayTopics()
+			s.setState(StreamStateEvent{State: StateResubscribed, Attempt: attempt})
 			backoff = reconnectMinBackoff
 			wsDebugf("reconnected")
 			break
@@ -330,12 +410,12 @@ func (s *Stream) supervise() {
 }
 
 // readConn reads and dispatches frames from conn until it returns an error
-// (connection closed or failed).
-func (s *Stream) readConn(conn *websocket.Conn) {
+// (connection closed or failed), and returns that error.
+func (s *Stream) readConn(conn *websocket.Conn) error {
 	for {
 		_, data, err := conn.ReadMessage()
 		if err != nil {
-			return
+			return err
 		}
 		wsDebugf("recv: %s", data)
 		s.dispatch(data)
@@ -383,15 +463,34 @@ func (s *Stream) currentConn() *websocket.Conn {
 // prior call to /iserver/accounts. For derivative contracts, /iserver/secdef
 // search must have been called first. The first update for a contract may be a
 // partial snapshot; subsequent updates are incremental.
+//
+// If the client has a LineManager, the subscription takes a line from it, and
+// SubscribeMarketData returns a *LineBudgetError if none is free and none can
+// be evicted. It returns an error if the stream has ended.
+//
+// SubscribeMarketData and UnsubscribeMarketData manage a single subscription
+// per conid, delivered on Updates; calling it again for the same conid
+// replaces the fields. Components that share a Stream should use Subscribe
+// instead.
 func (s *Stream) SubscribeMarketData(conid int, fields ...string) error {
 	if len(fields) == 0 {
-		fields = []string{
-			FieldLastPrice, FieldBidPrice, FieldAskPrice,
-			FieldBidSize, FieldAskSize, FieldVolume,
+		fields = defaultMarketDataFields()
+	}
+	if lines := s.client.lineManager(); lines != nil {
+		if err := lines.acquire([]int{conid}, s); err != nil {
+			return err
 		}
 	}
 	s.subsMu.Lock()
-	s.subs[conid] = fields
+	if s.eventsClosed {
+		s.subsMu.Unlock()
+		if lines := s.client.lineManager(); lines != nil {
+			lines.releaseStream(conid, s)
+		}
+		return errStreamEnded
+	}
+	s.direct[conid] = fields
+	fields = s.mergeSubLocked(conid)
 	s.subsMu.Unlock()
 
 	if err := s.sendSubscribe(conid, fields); err != nil {
@@ -401,15 +500,88 @@ func (s *Stream) SubscribeMarketData(conid int, fields ...string) error {
 }
 
 // UnsubscribeMarketData stops streaming market data for the given conid and
-// removes it from the set replayed on reconnect.
+// removes it from the set replayed on reconnect. Subscriptions made with
+// Subscribe for the same conid are not affected; the gateway keeps sending
+// the fields they requested.
 func (s *Stream) UnsubscribeMarketData(conid int) error {
 	s.subsMu.Lock()
-	delete(s.subs, conid)
+	delete(s.direct, conid)
+	s.subsMu.Unlock()
+	return s.refreshSub(conid)
+}
+
+func defaultMarketDataFields() []string {
+	return []string{
+		FieldLastPrice, FieldBidPrice, FieldAskPrice,
+		FieldBidSize, FieldAskSize, FieldVolume,
+	}
+}
+
+// WatchAvailability returns a channel reporting each time a subscribed
+// contract's market-data availability is first reported or changes, for
+// example from realtime to delayed when the account's subscription lapses.
+// Use it to stop pricing off a contract whose data is no longer live.
+//
+// The first call switches the Stream to requesting the availability field
+// (6509) on every market-data subscription, re-sending the existing ones, so
+// that every MarketDataUpdate carries a known Availability. Later calls return
+// the same channel. Like Updates, the channel must be drained: the Stream
+// waits for an event to be received before delivering further updates. It is
+// closed when the stream ends.
+func (s *Stream) WatchAvailability() <-chan AvailabilityEvent {
+	s.subsMu.Lock()
+	ch := s.availEvents
+	created := ch == nil
+	if created {
+		ch = make(chan AvailabilityEvent, 64)
+		s.availEvents = ch
+		if s.eventsClosed {
+			close(ch)
+		}
+	}
 	s.subsMu.Unlock()
-	return s.writeText(fmt.Sprintf("umd+%d+{}", conid))
+	if created {
+		s.resubscribeAll()
+	}
+	return ch
+}
+
+// releaseLines gives back the stream's market-data lines when it ends.
+func (s *Stream) releaseLines() {
+	if lines := s.client.lineManager(); lines != nil {
+		lines.releaseAll(s)
+	}
+}
+
+// closeEvents closes the optional event channels when the stream ends.
+func (s *Stream) closeEvents() {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	s.eventsClosed = true
+	if s.availEvents != nil {
+		close(s.availEvents)
+	}
+	s.orders.close()
+	s.trades.close()
+	s.pnl.close()
+	s.summary.close()
+	s.ledger.close()
+	s.notifications.close()
+	s.bulletins.close()
+	s.accounts.close()
+	s.authStatus.close()
+	s.bars.close()
+	s.books.close()
+	s.states.close()
+	s.closeSubscriptionsLocked()
 }
 
 func (s *Stream) sendSubscribe(conid int, fields []string) error {
+	s.subsMu.Lock()
+	if s.availEvents != nil && !slices.Contains(fields, FieldAvailability) {
+		fields = append(slices.Clone(fields), FieldAvailability)
+	}
+	s.subsMu.Unlock()
 	args, err := json.Marshal(struct {
 		Fields []string `json:"fields"`
 	}{Fields: fields})
@@ -439,9 +611,10 @@ func (s *Stream) resubscribeAll() {
 // Updates returns the channel on which market-data updates are delivered. The
 // channel stays open across automatic reconnects and is closed only when the
 // stream ends (Close is called or the dial context is cancelled); check Err
-// afterwards to distinguish a clean Close from a context error.
+// afterwards to distinguish a clean Close from a context error. What happens
+// when the consumer falls behind depends on the stream's BackpressurePolicy.
 func (s *Stream) Updates() <-chan MarketDataUpdate {
-	return s.updates
+	return s.updates.ch
 }
 
 // Err returns the error that ended the Stream, or nil if it was closed cleanly
@@ -526,8 +699,8 @@ func (s *Stream) renewLoop() {
 }
 
 // dispatch parses a raw websocket frame and, if it is a market-data update,
-// delivers it on the updates channel. Non-market-data frames (system messages,
-// heartbeats, other topics) are ignored in this SMD-focused implementation.
+// delivers it on the updates channel. Frames for other topics go to
+// dispatchTopic, which delivers the ones the caller subscribed to.
 func (s *Stream) dispatch(data []byte) {
 	var envelope struct {
 		Topic string `json:"topic"`
@@ -535,7 +708,16 @@ func (s *Stream) dispatch(data []byte) {
 	if err := json.Unmarshal(data, &envelope); err != nil {
 		return
 	}
+	if strings.HasPrefix(envelope.Topic, "smh+") {
+		s.dispatchHistory(envelope.Topic, data)
+		return
+	}
+	if strings.HasPrefix(envelope.Topic, "sbd+") {
+		s.dispatchBook(envelope.Topic, data)
+		return
+	}
 	if !strings.HasPrefix(envelope.Topic, "smd+") {
+		s.dispatchTopic(envelope.Topic, data)
 		return
 	}
 	conid, err := strconv.Atoi(strings.TrimPrefix(envelope.Topic, "smd+"))
@@ -553,9 +735,36 @@ func (s *Stream) dispatch(data []byte) {
 	delete(fields, "server_id")
 	delete(fields, "_updated")
 
-	update := MarketDataUpdate{Conid: conid, Topic: envelope.Topic, Fields: fields}
-	select {
-	case s.updates <- update:
-	case <-s.done:
+	update := MarketDataUpdate{Conid: conid, Topic: envelope.Topic, Received: time.Now(), Fields: fields}
+	s.noteTick(conid, update.Received)
+	update.Availability = s.trackAvailability(conid, fields)
+	if s.hasDirect(conid) {
+		s.updates.deliver(update, s.done)
+	}
+	s.deliverSubscriptions(update)
+}
+
+// trackAvailability records the availability reported in an update's fields,
+// if any, and returns the conid's current availability. A change is reported
+// on the WatchAvailability channel when one has been requested.
+func (s *Stream) trackAvailability(conid int, fields map[string]json.RawMessage) Availability {
+	s.subsMu.Lock()
+	prev := s.avail[conid]
+	raw, ok := fieldString(fields, FieldAvailability)
+	next := ParseAvailability(raw)
+	if !ok || !next.Known() || next.Raw == prev.Raw {
+		s.subsMu.Unlock()
+		return prev
+	}
+	s.avail[conid] = next
+	ch := s.availEvents
+	s.subsMu.Unlock()
+
+	if ch != nil {
+		select {
+		case ch <- AvailabilityEvent{Conid: conid, Availability: next, Previous: prev}:
+		case <-s.done:
+		}
 	}
+	return next
 }
@@ -0,0 +1,235 @@
+package ibclientportal
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultUpdatesBuffer is the number of market-data updates a Stream buffers
+// for its consumer unless DialStreamOptions says otherwise.
+const defaultUpdatesBuffer = 256
+
+// BackpressurePolicy is what a Stream does with market-data updates when the
+// consumer of Updates falls behind and the buffer is full.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock stops reading from the gateway until the consumer
+	// catches up. No update is lost, but a consumer that stays behind delays
+	// every other topic too, and the gateway may drop the connection.
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureDropOldest discards the oldest buffered update to make room
+	// for the newest. Because updates are incremental, a dropped update's
+	// fields are lost unless a later update repeats them.
+	BackpressureDropOldest
+	// BackpressureConflate keeps at most one pending update per conid,
+	// merging each new update's fields into it until the consumer reads it.
+	// No field's latest value is lost, only the intermediate values.
+	BackpressureConflate
+)
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case BackpressureBlock:
+		return "block"
+	case BackpressureDropOldest:
+		return "drop-oldest"
+	case BackpressureConflate:
+		return "conflate"
+	}
+	return "unknown"
+}
+
+// DialStreamOptions configures DialStreamWithOptions. The zero value gives
+// DialStream's behavior.
+type DialStreamOptions struct {
+	// BufferSize is the number of market-data updates buffered for the
+	// consumer of Updates, and for each Subscription; 0 means 256. It does not
+	// apply to BackpressureConflate, which holds one pending update per conid
+	// instead.
+	BufferSize int
+	// Backpressure is what to do when a buffer is full.
+	Backpressure BackpressurePolicy
+}
+
+// Dropped returns the number of market-data updates the Stream discarded
+// (BackpressureDropOldest) or merged into a pending update
+// (BackpressureConflate) because a consumer fell behind. It is always 0
+// under BackpressureBlock.
+func (s *Stream) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// newSink returns an updateSink following the stream's options.
+func (s *Stream) newSink() *updateSink {
+	return newUpdateSink(s.bufferSize, s.backpressure, &s.dropped)
+}
+
+// updateSink delivers market-data updates to one consumer, on ch, according
+// to a BackpressurePolicy. It backs both Updates and each Subscription.
+type updateSink struct {
+	ch      chan MarketDataUpdate
+	policy  BackpressurePolicy
+	dropped *atomic.Uint64
+
+	// Under BackpressureConflate updates wait in conflator until forward can
+	// deliver them, and forward owns ch.
+	conflator *conflator
+
+	// stop is closed by close; it unblocks a waiting deliver and stops
+	// forward.
+	stop     chan struct{}
+	stopOnce sync.Once
+	// sendMu is held while sending on ch, so close cannot close it mid-send.
+	sendMu sync.Mutex
+	closed bool
+}
+
+func newUpdateSink(bufferSize int, policy BackpressurePolicy, dropped *atomic.Uint64) *updateSink {
+	if bufferSize <= 0 {
+		bufferSize = defaultUpdatesBuffer
+	}
+	if policy == BackpressureConflate {
+		// Updates wait in the conflator, where they can still be merged,
+		// rather than in the channel.
+		bufferSize = 0
+	}
+	k := &updateSink{
+		ch:      make(chan MarketDataUpdate, bufferSize),
+		policy:  policy,
+		dropped: dropped,
+		stop:    make(chan struct{}),
+	}
+	if policy == BackpressureConflate {
+		k.conflator = newConflator()
+		go k.forward()
+	}
+	return k
+}
+
+// deliver hands u to the consumer according to the sink's policy. Under
+// BackpressureBlock it waits until u is received, the sink is closed, or done
+// is closed.
+func (k *updateSink) deliver(u MarketDataUpdate, done <-chan struct{}) {
+	if k.conflator != nil {
+		if k.conflator.add(u) {
+			k.dropped.Add(1)
+		}
+		return
+	}
+	k.sendMu.Lock()
+	defer k.sendMu.Unlock()
+	if k.closed {
+		return
+	}
+	if k.policy == BackpressureDropOldest {
+		for {
+			select {
+			case k.ch <- u:
+				return
+			default:
+			}
+			// Only deliver sends, under sendMu, so once a buffered update
+			// has been taken (by us or by the consumer) there is room.
+			select {
+			case <-k.ch:
+				k.dropped.Add(1)
+			default:
+			}
+		}
+	}
+	select {
+	case k.ch <- u:
+	case <-k.stop:
+	case <-done:
+	}
+}
+
+// close closes the sink's channel. Updates still pending in a conflator are
+// discarded. It is safe to call more than once.
+func (k *updateSink) close() {
+	k.stopOnce.Do(func() { close(k.stop) })
+	if k.conflator != nil {
+		return // forward closes ch
+	}
+	k.sendMu.Lock()
+	if !k.closed {
+		k.closed = true
+		close(k.ch)
+	}
+	k.sendMu.Unlock()
+}
+
+// forward delivers conflated updates to the consumer, oldest conid first,
+// until the sink is closed.
+func (k *updateSink) forward() {
+	defer close(k.ch)
+	for {
+		u, ok := k.conflator.pop()
+		if !ok {
+			select {
+			case <-k.conflator.notify:
+				continue
+			case <-k.stop:
+				return
+			}
+		}
+		select {
+		case k.ch <- u:
+		case <-k.stop:
+			return
+		}
+	}
+}
+
+// conflator holds at most one pending market-data update per conid, in the
+// order the conids first became pending.
+type conflator struct {
+	mu      sync.Mutex
+	pending map[int]*MarketDataUpdate
+	order   []int
+	notify  chan struct{} // signalled when an update becomes pending
+}
+
+func newConflator() *conflator {
+	return &conflator{
+		pending: make(map[int]*MarketDataUpdate),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// add merges u into the conid's pending update, or makes it pending. It
+// reports whether u was merged into one already waiting.
+func (c *conflator) add(u MarketDataUpdate) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.pending[u.Conid]; ok {
+		maps.Copy(p.Fields, u.Fields)
+		p.Received = u.Received
+		p.Availability = u.Availability
+		return true
+	}
+	u.Fields = maps.Clone(u.Fields)
+	c.pending[u.Conid] = &u
+	c.order = append(c.order, u.Conid)
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+	return false
+}
+
+// pop removes and returns the oldest pending update.
+func (c *conflator) pop() (MarketDataUpdate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.order) == 0 {
+		return MarketDataUpdate{}, false
+	}
+	conid := c.order[0]
+	c.order = c.order[1:]
+	u := c.pending[conid]
+	delete(c.pending, conid)
+	return *u, true
+}
@@ -0,0 +1,74 @@
+package ibclientportal
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestBackpressureDropOldest(t *testing.T) {
+	t.Parallel()
+	var dropped atomic.Uint64
+	k := newUpdateSink(2, BackpressureDropOldest, &dropped)
+	done := make(chan struct{})
+	for conid := 1; conid <= 5; conid++ {
+		k.deliver(testUpdate(conid, `{"31":"1.00"}`), done)
+	}
+	if got := dropped.Load(); got != 3 {
+		t.Errorf("dropped = %d, want 3", got)
+	}
+	for _, want := range []int{4, 5} {
+		if u := <-k.ch; u.Conid != want {
+			t.Errorf("got conid %d, want %d", u.Conid, want)
+		}
+	}
+	k.close()
+	k.deliver(testUpdate(6, `{"31":"1.00"}`), done) // must not panic
+	if _, ok := <-k.ch; ok {
+		t.Error("expected the channel to close")
+	}
+}
+
+func TestBackpressureConflate(t *testing.T) {
+	t.Parallel()
+	var dropped atomic.Uint64
+	k := &updateSink{
+		ch:        make(chan MarketDataUpdate),
+		policy:    BackpressureConflate,
+		dropped:   &dropped,
+		conflator: newConflator(),
+		stop:      make(chan struct{}),
+	}
+	done := make(chan struct{})
+	k.deliver(testUpdate(265598, `{"31":"190.00"}`), done)
+	k.deliver(testUpdate(265598, `{"84":"189.99"}`), done)
+	k.deliver(testUpdate(8314, `{"31":"12.00"}`), done)
+	last := testUpdate(265598, `{"31":"190.05"}`)
+	last.Availability = ParseAvailability("DpB")
+	k.deliver(last, done)
+	if got := dropped.Load(); got != 2 {
+		t.Errorf("dropped = %d, want 2", got)
+	}
+
+	go k.forward()
+	u := <-k.ch
+	if u.Conid != 265598 {
+		t.Fatalf("first update is for %d, want 265598", u.Conid)
+	}
+	if l, _ := u.Float(FieldLastPrice); l != 190.05 {
+		t.Errorf("conflated last = %v, want the latest 190.05", l)
+	}
+	if b, _ := u.Float(FieldBidPrice); b != 189.99 {
+		t.Errorf("conflated bid = %v, want 189.99 merged from an earlier update", b)
+	}
+	if u.Availability.Status != AvailabilityDelayed {
+		t.Errorf("conflated availability = %v, want the latest", u.Availability)
+	}
+	if u := <-k.ch; u.Conid != 8314 {
+		t.Errorf("second update is for %d, want 8314", u.Conid)
+	}
+
+	k.close()
+	if _, ok := <-k.ch; ok {
+		t.Error("expected the channel to close when the sink is closed")
+	}
+}
@@ -0,0 +1,225 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HistoryRequest describes the bars to stream with (*Stream).SubscribeHistory.
+// Period and Bar use the same notation as the REST history endpoint, for
+// example "1d" and "5min".
+type HistoryRequest struct {
+	// Exchange is the exchange to source bars from. Empty means the contract's
+	// primary exchange.
+	Exchange string `json:"exchange,omitempty"`
+	// Period is the total duration covered by the initial bars, such as "2h",
+	// "1d" or "1w".
+	Period string `json:"period"`
+	// Bar is the width of each bar, such as "1min", "5min" or "1h".
+	Bar string `json:"bar"`
+	// OutsideRTH includes bars outside regular trading hours.
+	OutsideRTH bool `json:"outsideRth"`
+	// Source is what the bars are built from: "trades" (the default),
+	// "midpoint" or "bid_ask".
+	Source string `json:"source,omitempty"`
+	// Format selects the bar fields the gateway sends, such as
+	// "%o/%c/%h/%l/%v". Empty means all of them.
+	Format string `json:"format,omitempty"`
+}
+
+// HistoryUpdate is a batch of bars for one contract, delivered on the channel
+// returned by (*Stream).SubscribeHistory. The first update for a
+// subscription carries the whole requested period; later ones carry the bars
+// that were added or changed, the last of which may still be forming.
+type HistoryUpdate struct {
+	// Conid is the contract the bars are for.
+	Conid int
+	// Topic is the raw websocket topic, e.g. "smh+265598".
+	Topic string
+	// ServerID identifies the subscription on the gateway.
+	ServerID string
+	// Symbol is the contract's symbol.
+	Symbol string
+	// TimePeriod is the period the bars cover, as the gateway describes it.
+	TimePeriod string
+	// BarLength is the width of each bar, in seconds.
+	BarLength int
+	// Bars are the bars, oldest first.
+	Bars []MarketDataHistoryData
+}
+
+// historySub tracks a SubscribeHistory subscription.
+type historySub struct {
+	// serverID is the gateway's ID for the subscription on the current
+	// connection; it is learned from the first frame.
+	serverID string
+	// released is set when the caller unsubscribed before the server ID was
+	// known, so the subscription is released as soon as it is.
+	released bool
+}
+
+// SubscribeHistory streams bars for a contract (the "smh" topic): first the
+// requested period, then each new or updated bar. The gateway allows only a
+// few such subscriptions at once (five at the time of writing), so release
+// them with UnsubscribeHistory.
+//
+// Every contract's bars arrive on one channel, which is the same on every
+// call. It behaves as the one from SubscribeOrders does: it must be drained,
+// and is closed when the stream ends. Calling SubscribeHistory again for the
+// same conid replaces the request. The subscription is replayed on reconnect,
+// and the first update after a reconnect again carries the whole period.
+func (s *Stream) SubscribeHistory(conid int, req HistoryRequest) (<-chan HistoryUpdate, error) {
+	if req.Period == "" || req.Bar == "" {
+		return nil, fmt.Errorf("ibclientportal: SubscribeHistory: Period and Bar are required")
+	}
+	args, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	key := historyTopic(conid)
+	s.subsMu.Lock()
+	ch := s.bars.open(s)
+	prev := s.history[conid]
+	s.history[conid] = &historySub{}
+	var release string
+	switch {
+	case prev == nil:
+	case prev.serverID != "":
+		release = prev.serverID
+		s.historyReleased[release] = struct{}{}
+	default:
+		// Its first frame has not arrived, so it cannot be released yet;
+		// dispatchHistory releases it when it does.
+		s.historyOrphans[conid]++
+	}
+	s.subsMu.Unlock()
+	if release != "" {
+		s.releaseHistory(release)
+	}
+	return ch, s.subscribeTopic(key, fmt.Sprintf("smh+%d+%s", conid, args))
+}
+
+// UnsubscribeHistory stops the bars for a contract and releases the
+// gateway's subscription. If no bars have arrived yet, the gateway has not
+// said which subscription to release; it is released when they do.
+func (s *Stream) UnsubscribeHistory(conid int) error {
+	s.unwatchTopic(historyTopic(conid))
+	s.subsMu.Lock()
+	sub := s.history[conid]
+	if sub == nil {
+		s.subsMu.Unlock()
+		return nil
+	}
+	serverID := sub.serverID
+	if serverID == "" {
+		sub.released = true
+	} else {
+		delete(s.history, conid)
+		s.historyReleased[serverID] = struct{}{}
+	}
+	s.subsMu.Unlock()
+	if serverID == "" {
+		return nil
+	}
+	return s.releaseHistory(serverID)
+}
+
+func (s *Stream) releaseHistory(serverID string) error {
+	return s.writeText("umh+" + serverID)
+}
+
+func historyTopic(conid int) string {
+	return "smh+" + strconv.Itoa(conid)
+}
+
+// forgetHistoryServerIDs clears the server IDs learned on the previous
+// connection; the gateway assigns new ones when the subscriptions are
+// replayed. Subscriptions awaiting release are dropped, since the gateway
+// released them with the connection.
+func (s *Stream) forgetHistoryServerIDs() {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	clear(s.historyOrphans)
+	clear(s.historyReleased)
+	for conid, sub := range s.history {
+		if sub.released {
+			delete(s.history, conid)
+			continue
+		}
+		sub.serverID = ""
+	}
+}
+
+// dispatchHistory delivers an "smh" frame, recording the subscription's
+// server ID, or releases the subscription if the caller no longer wants it.
+func (s *Stream) dispatchHistory(topic string, data []byte) {
+	conid, err := strconv.Atoi(strings.TrimPrefix(topic, "smh+"))
+	if err != nil {
+		return
+	}
+	var frame struct {
+		ServerID   string                  `json:"serverId"`
+		Symbol     string                  `json:"symbol"`
+		TimePeriod string                  `json:"timePeriod"`
+		BarLength  int                     `json:"barLength"`
+		Data       []MarketDataHistoryData `json:"data"`
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		wsDebugf("decoding %s frame: %v", topic, err)
+		return
+	}
+
+	s.subsMu.Lock()
+	if _, ok := s.historyReleased[frame.ServerID]; ok {
+		// A late frame for a subscription already released.
+		s.subsMu.Unlock()
+		return
+	}
+	sub := s.history[conid]
+	release := false
+	switch {
+	case frame.ServerID == "":
+	case sub != nil && sub.serverID == frame.ServerID:
+	case s.historyOrphans[conid] > 0:
+		// The gateway answers subscriptions in order, so an unknown server
+		// ID belongs to the oldest subscription that was replaced before
+		// its first frame.
+		if s.historyOrphans[conid]--; s.historyOrphans[conid] == 0 {
+			delete(s.historyOrphans, conid)
+		}
+		release = true
+	case sub != nil:
+		sub.serverID = frame.ServerID
+		if sub.released {
+			delete(s.history, conid)
+			release = true
+		}
+	}
+	if release {
+		s.historyReleased[frame.ServerID] = struct{}{}
+	}
+	_, subscribed := s.topics[topic]
+	ch := s.bars.ch
+	s.subsMu.Unlock()
+
+	if release {
+		if err := s.releaseHistory(frame.ServerID); err != nil {
+			wsDebugf("releasing history subscription %s: %v", frame.ServerID, err)
+		}
+		return
+	}
+	if !subscribed || ch == nil {
+		return
+	}
+	deliver(s, ch, HistoryUpdate{
+		Conid:      conid,
+		Topic:      topic,
+		ServerID:   frame.ServerID,
+		Symbol:     frame.Symbol,
+		TimePeriod: frame.TimePeriod,
+		BarLength:  frame.BarLength,
+		Bars:       frame.Data,
+	})
+}
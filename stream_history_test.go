@@ -0,0 +1,208 @@
+package ibclientportal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamHistory(t *testing.T) {
+	t.Parallel()
+
+	var conns atomic.Int32
+	received := make(chan string, 16) // "<connection>:<frame>"
+	drop := make(chan struct{})
+
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		n := conns.Add(1)
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		if n == 1 {
+			go func() {
+				<-drop
+				conn.Close()
+			}()
+		}
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg := string(data)
+			if msg == "tic" {
+				continue
+			}
+			received <- fmt.Sprintf("%d:%s", n, msg)
+			if strings.HasPrefix(msg, "smh+265598+") {
+				conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(
+					`{"topic":"smh+265598","serverId":"1822%d","symbol":"AAPL","timePeriod":"2h","barLength":300,`+
+						`"data":[{"o":192.1,"c":192.2,"h":192.3,"l":192.0,"v":1500,"t":1702582200000},`+
+						`{"o":192.2,"c":192.4,"h":192.5,"l":192.1,"v":900,"t":1702582500000}]}`, n)))
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := New(srv.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatalf("DialStream: %v", err)
+	}
+	defer stream.Close()
+
+	if _, err := stream.SubscribeHistory(265598, HistoryRequest{Period: "2h"}); err == nil {
+		t.Error("expected an error without a bar size")
+	}
+	bars, err := stream.SubscribeHistory(265598, HistoryRequest{Period: "2h", Bar: "5min", Source: "trades"})
+	if err != nil {
+		t.Fatalf("SubscribeHistory: %v", err)
+	}
+	expectFrame := func(want string) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("gateway received %q, want %q", got, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	expectUpdate := func(serverID string) {
+		t.Helper()
+		select {
+		case u := <-bars:
+			if u.Conid != 265598 || u.ServerID != serverID || u.BarLength != 300 || len(u.Bars) != 2 {
+				t.Fatalf("unexpected history update: %+v", u)
+			}
+			if b := u.Bars[1]; b.Close != 192.4 || b.Volume != 900 || b.Time.UnixMilli() != 1702582500000 {
+				t.Errorf("unexpected bar: %+v", b)
+			}
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for bars")
+		}
+	}
+
+	const subscribe = `smh+265598+{"period":"2h","bar":"5min","outsideRth":false,"source":"trades"}`
+	expectFrame("1:" + subscribe)
+	expectUpdate("18221")
+
+	// The subscription resumes after a reconnect, under a new server ID.
+	close(drop)
+	expectFrame("2:" + subscribe)
+	expectUpdate("18222")
+
+	if err := stream.UnsubscribeHistory(265598); err != nil {
+		t.Fatalf("UnsubscribeHistory: %v", err)
+	}
+	expectFrame("2:umh+18222")
+}
+
+// Subscribing again before the first frame arrives releases the replaced
+// subscription once its server ID is known, and does not mistake its frames
+// for the new subscription's.
+func TestStreamHistoryResubscribeBeforeFirstFrame(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan string, 16)
+	frames := make(chan string, 4)
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		go func() {
+			for f := range frames {
+				conn.WriteMessage(websocket.TextMessage, []byte(f))
+			}
+		}()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg := string(data); msg != "tic" {
+				received <- msg
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := New(srv.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatalf("DialStream: %v", err)
+	}
+	defer stream.Close()
+	expectFrame := func(prefix string) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if !strings.HasPrefix(got, prefix) {
+				t.Errorf("gateway received %q, want %s...", got, prefix)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s", prefix)
+		}
+	}
+
+	if _, err := stream.SubscribeHistory(265598, HistoryRequest{Period: "2h", Bar: "5min"}); err != nil {
+		t.Fatal(err)
+	}
+	expectFrame("smh+265598+")
+	bars, err := stream.SubscribeHistory(265598, HistoryRequest{Period: "1d", Bar: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFrame("smh+265598+")
+
+	frame := func(serverID string) string {
+		return `{"topic":"smh+265598","serverId":"` + serverID + `","barLength":300,"data":[{"o":1,"c":1,"h":1,"l":1,"v":1,"t":1702582200000}]}`
+	}
+	frames <- frame("101")
+	frames <- frame("101")
+	frames <- frame("102")
+	expectFrame("umh+101")
+	select {
+	case u := <-bars:
+		if u.ServerID != "102" {
+			t.Errorf("delivered bars from subscription %s, want 102", u.ServerID)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for bars")
+	}
+	if err := stream.UnsubscribeHistory(265598); err != nil {
+		t.Fatal(err)
+	}
+	expectFrame("umh+102")
+	close(frames)
+}
@@ -0,0 +1,88 @@
+package ibclientportal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// orderWaiter is a (*Stream).WaitForOrder call waiting on order events.
+type orderWaiter struct {
+	orderID string
+	wake    chan struct{}
+}
+
+// WaitForOrder is OrdersService.WaitForOrder, told of the order's changes by
+// the stream's order events instead of finding them by polling every second.
+// Each event for the order triggers a status lookup, since events carry only
+// the fields that changed; a slow poll remains as a backstop in case an event
+// is missed across a reconnect.
+//
+// It subscribes to order events if SubscribeOrders has not, without opening
+// the channel SubscribeOrders returns, and unsubscribes when the last waiter
+// is done. On a stream that has ended it polls as OrdersService.WaitForOrder
+// does.
+func (s *Stream) WaitForOrder(ctx context.Context, orderID string, until WaitUntil) (OrderStatus, error) {
+	w := &orderWaiter{orderID: orderID, wake: make(chan struct{}, 1)}
+	err := s.watchOrder(w)
+	defer s.unwatchOrder(w)
+	if errors.Is(err, errStreamEnded) {
+		// No events will come, so poll as OrdersService does.
+		return s.client.Orders.WaitForOrder(ctx, orderID, until)
+	}
+	if err != nil {
+		return OrderStatus{}, err
+	}
+	return s.client.Orders.waitForOrder(ctx, orderID, until, streamOrderPollInterval, w.wake)
+}
+
+// watchOrder registers w for order events, subscribing to them if nothing
+// else has.
+func (s *Stream) watchOrder(w *orderWaiter) error {
+	s.orderTopicMu.Lock()
+	defer s.orderTopicMu.Unlock()
+	s.subsMu.Lock()
+	if s.orderWaiters == nil {
+		s.orderWaiters = make(map[*orderWaiter]struct{})
+	}
+	s.orderWaiters[w] = struct{}{}
+	_, subscribed := s.topics[topicOrders]
+	s.subsMu.Unlock()
+	if subscribed {
+		return nil
+	}
+	return s.subscribeTopic(topicOrders, "sor+{}")
+}
+
+// unwatchOrder removes w, unsubscribing from order events if nothing else
+// wants them.
+func (s *Stream) unwatchOrder(w *orderWaiter) {
+	s.orderTopicMu.Lock()
+	defer s.orderTopicMu.Unlock()
+	s.subsMu.Lock()
+	delete(s.orderWaiters, w)
+	unsubscribe := len(s.orderWaiters) == 0 && !s.orderEvents
+	s.subsMu.Unlock()
+	if !unsubscribe {
+		return
+	}
+	if err := s.unsubscribeTopic(topicOrders, "uor+{}"); err != nil {
+		wsDebugf("unsubscribe %s send failed: %v", topicOrders, err)
+	}
+}
+
+// wakeOrderWaiters tells the waiters on the order in an event to look it up.
+func (s *Stream) wakeOrderWaiters(o Order) {
+	id := strconv.FormatInt(o.OrderID, 10)
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for w := range s.orderWaiters {
+		if w.orderID != id {
+			continue
+		}
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
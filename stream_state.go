@@ -0,0 +1,113 @@
+package ibclientportal
+
+import "time"
+
+// StreamState is the state of a Stream's connection to the gateway.
+type StreamState int
+
+const (
+	// StateConnecting means the Stream is dialing the gateway.
+	StateConnecting StreamState = iota
+	// StateEstablished means the gateway reported an authenticated session
+	// ("sts") on a new connection.
+	StateEstablished
+	// StateDisconnected means the connection was lost. Market data is stale
+	// from this point until StateResubscribed.
+	StateDisconnected
+	// StateReconnecting means the Stream is waiting before a reconnect
+	// attempt.
+	StateReconnecting
+	// StateResubscribed means every subscription was replayed on a new
+	// connection; data flows again.
+	StateResubscribed
+	// StateClosed means the stream ended, because Close was called or its
+	// context was cancelled. It is the last state reported.
+	StateClosed
+)
+
+func (s StreamState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateEstablished:
+		return "established"
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateResubscribed:
+		return "resubscribed"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StreamStateEvent reports a change in a Stream's connection state.
+type StreamStateEvent struct {
+	// State is the new state.
+	State StreamState
+	// Time is when the Stream entered the state.
+	Time time.Time
+	// Err is why the connection was lost (StateDisconnected), why the
+	// previous reconnect attempt failed (StateReconnecting), or what ended the
+	// stream (StateClosed; nil after Close).
+	Err error
+	// Attempt numbers the reconnect attempts since the connection was lost,
+	// starting at 1. It is 0 for the initial connection.
+	Attempt int
+	// Backoff is how long the Stream waits before the attempt
+	// (StateReconnecting).
+	Backoff time.Duration
+}
+
+// Live reports whether data is flowing in this state: the connection is up
+// and its subscriptions are in place.
+func (e StreamStateEvent) Live() bool {
+	return e.State == StateEstablished || e.State == StateResubscribed
+}
+
+// States returns a channel reporting each change in the connection's state:
+// disconnections with their cause, each reconnect attempt and its backoff,
+// the new connection being established, and the subscriptions being
+// replayed. Use it to show a feed outage, or to stop trading while quotes
+// are stale.
+//
+// The first event on the channel is the current state. The channel is the
+// same on every call. Like Updates it must be drained: the Stream waits for
+// each event to be received before going on. StateClosed is the last event,
+// after which the channel is closed.
+func (s *Stream) States() <-chan StreamStateEvent {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	created := s.states.ch == nil
+	ch := s.states.open(s)
+	if created && !s.eventsClosed {
+		ch <- s.state
+	}
+	return ch
+}
+
+// State returns the connection's current state.
+func (s *Stream) State() StreamStateEvent {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	return s.state
+}
+
+// setState records a state change and reports it on the States channel, if
+// one was requested.
+func (s *Stream) setState(ev StreamStateEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	s.subsMu.Lock()
+	s.state = ev
+	ch := s.states.ch
+	closed := s.eventsClosed
+	s.subsMu.Unlock()
+	wsDebugf("state: %s (attempt %d, err %v)", ev.State, ev.Attempt, ev.Err)
+	if ch != nil && !closed {
+		deliver(s, ch, ev)
+	}
+}
@@ -0,0 +1,97 @@
+package ibclientportal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamStates(t *testing.T) {
+	t.Parallel()
+
+	var conns atomic.Int32
+	drop := make(chan struct{})
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		if conns.Add(1) == 1 {
+			<-drop
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := New(srv.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatalf("DialStream: %v", err)
+	}
+	defer stream.Close()
+
+	states := stream.States()
+	next := func(want StreamState) StreamStateEvent {
+		t.Helper()
+		select {
+		case ev, ok := <-states:
+			if !ok {
+				t.Fatalf("states closed while waiting for %v", want)
+			}
+			if ev.State != want {
+				t.Fatalf("state = %v (%+v), want %v", ev.State, ev, want)
+			}
+			return ev
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %v", want)
+		}
+		panic("unreachable")
+	}
+
+	if ev := next(StateEstablished); ev.Attempt != 0 || !ev.Live() {
+		t.Errorf("unexpected initial state: %+v", ev)
+	}
+	close(drop)
+	if ev := next(StateDisconnected); ev.Err == nil || ev.Live() {
+		t.Errorf("expected a disconnect cause, got %+v", ev)
+	}
+	if ev := next(StateReconnecting); ev.Attempt != 1 || ev.Backoff != reconnectMinBackoff {
+		t.Errorf("unexpected reconnect state: %+v", ev)
+	}
+	next(StateConnecting)
+	next(StateEstablished)
+	if ev := next(StateResubscribed); ev.Attempt != 1 {
+		t.Errorf("unexpected resubscribed state: %+v", ev)
+	}
+	if got := stream.State(); got.State != StateResubscribed {
+		t.Errorf("State() = %v, want resubscribed", got.State)
+	}
+
+	stream.Close()
+	if ev := next(StateClosed); ev.Err != nil {
+		t.Errorf("expected a clean close, got %v", ev.Err)
+	}
+	if _, ok := <-states; ok {
+		t.Error("expected the states channel to be closed after StateClosed")
+	}
+}
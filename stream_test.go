@@ -364,3 +364,98 @@ func drainSubscribes(t *testing.T, ctx context.Context, ch <-chan int, want []in
 		}
 	}
 }
+
+// TestStreamWatchAvailability verifies that watching availability adds field
+// 6509 to subscriptions, reports each change as an event, and stamps the
+// last-known availability on updates that do not carry the field.
+func TestStreamWatchAvailability(t *testing.T) {
+	t.Parallel()
+
+	gotSubscribe := make(chan string, 4)
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg := string(data); strings.HasPrefix(msg, "smd+") {
+				gotSubscribe <- msg
+				for _, frame := range []string{
+					`{"topic":"smd+265598","conid":265598,"31":"190.00","6509":"RpB"}`,
+					`{"topic":"smd+265598","conid":265598,"31":"190.05"}`,
+					`{"topic":"smd+265598","conid":265598,"31":"190.05","6509":"DpB"}`,
+				} {
+					conn.WriteMessage(websocket.TextMessage, []byte(frame))
+				}
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := New(srv.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatalf("DialStream: %v", err)
+	}
+	defer stream.Close()
+
+	events := stream.WatchAvailability()
+	if err := stream.SubscribeMarketData(265598, FieldLastPrice); err != nil {
+		t.Fatalf("SubscribeMarketData: %v", err)
+	}
+	select {
+	case sub := <-gotSubscribe:
+		if want := `smd+265598+{"fields":["31","6509"]}`; sub != want {
+			t.Errorf("subscribe frame = %q, want %q", sub, want)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for subscribe frame")
+	}
+
+	wantUpdates := []AvailabilityStatus{AvailabilityRealtime, AvailabilityRealtime, AvailabilityDelayed}
+	wantEvents := []AvailabilityStatus{AvailabilityRealtime, AvailabilityDelayed}
+	var gotEvents []AvailabilityEvent
+	for i := 0; i < len(wantUpdates); {
+		select {
+		case u := <-stream.Updates():
+			if u.Availability.Status != wantUpdates[i] {
+				t.Errorf("update %d availability = %v, want %v", i, u.Availability, wantUpdates[i])
+			}
+			i++
+		case ev := <-events:
+			gotEvents = append(gotEvents, ev)
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for updates")
+		}
+	}
+	for len(gotEvents) < len(wantEvents) {
+		select {
+		case ev := <-events:
+			gotEvents = append(gotEvents, ev)
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for availability events")
+		}
+	}
+	for i, ev := range gotEvents {
+		if ev.Conid != 265598 || ev.Availability.Status != wantEvents[i] {
+			t.Errorf("event %d = %#v, want status %v", i, ev, wantEvents[i])
+		}
+	}
+	if gotEvents[1].Previous.Status != AvailabilityRealtime {
+		t.Errorf("expected the delayed event to report the realtime status it replaced, got %#v", gotEvents[1].Previous)
+	}
+}
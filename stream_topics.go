@@ -0,0 +1,496 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The websocket topics other than market data. Each subscribed topic's key is
+// the topic name the gateway puts on its frames: "sor", "spl" and so on, or
+// "ssd+<account>" and "sld+<account>" for the per-account topics.
+const (
+	topicOrders         = "sor"
+	topicTrades         = "str"
+	topicPnL            = "spl"
+	topicAccountSummary = "ssd"
+	topicLedger         = "sld"
+	topicNotifications  = "ntf"
+	topicBulletins      = "blt"
+	topicAccount        = "act"
+	topicAuthStatus     = "sts"
+)
+
+// topicEventBuffer is the buffer size of each topic's event channel.
+const topicEventBuffer = 64
+
+// topicFeed is the event channel for one kind of websocket topic. It is
+// created by the first Subscribe call for the topic and stays open, across
+// unsubscribes and reconnects, until the stream ends.
+type topicFeed[T any] struct {
+	ch chan T
+}
+
+// open returns the feed's channel, creating it if needed. s.subsMu must be
+// held.
+func (f *topicFeed[T]) open(s *Stream) chan T {
+	if f.ch == nil {
+		f.ch = make(chan T, topicEventBuffer)
+		if s.eventsClosed {
+			close(f.ch)
+		}
+	}
+	return f.ch
+}
+
+// close closes the feed's channel, if it was created. s.subsMu must be held.
+func (f *topicFeed[T]) close() {
+	if f.ch != nil {
+		close(f.ch)
+	}
+}
+
+// PnLUpdate is one partition of the account's profit and loss, delivered on
+// the channel returned by (*Stream).SubscribePnL. The gateway sends only the
+// values that changed; an absent value is left zero.
+type PnLUpdate struct {
+	// Key identifies the partition, for example "U1234567.Core".
+	Key string
+	// RowType is IB's row type; 1 is the account's core partition.
+	RowType int `json:"rowType"`
+	// DailyPnL is the day's profit and loss.
+	DailyPnL float64 `json:"dpl"`
+	// UnrealizedPnL is the unrealized profit and loss.
+	UnrealizedPnL float64 `json:"upl"`
+	// NetLiquidation is the net liquidation value.
+	NetLiquidation float64 `json:"nl"`
+	// ExcessLiquidity is the excess liquidity.
+	ExcessLiquidity float64 `json:"el"`
+	// MarketValue is the market value of positions.
+	MarketValue float64 `json:"mv"`
+}
+
+// AccountSummaryUpdate is one value of an account's summary, delivered on the
+// channel returned by (*Stream).SubscribeAccountSummary.
+type AccountSummaryUpdate struct {
+	// Account is the account the value belongs to.
+	Account string `json:"-"`
+	// Key names the value, for example "NetLiquidation-S" or
+	// "ExcessLiquidity-C".
+	Key string `json:"key"`
+	// Currency is the currency of MonetaryValue.
+	Currency string `json:"currency"`
+	// MonetaryValue holds a monetary value.
+	MonetaryValue float64 `json:"monetaryValue"`
+	// Value holds a non-monetary value, such as the account type.
+	Value string `json:"value"`
+	// Severity is IB's warning level for the value; 0 is normal.
+	Severity int `json:"severity"`
+	// Timestamp is when the value was computed, in Unix milliseconds.
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Notification is a notification or server prompt delivered on the channel
+// returned by (*Stream).SubscribeNotifications. A server prompt carries an
+// OrderID, a ReqID and Options; answer it via /iserver/notification.
+type Notification struct {
+	ID      string   `json:"id"`
+	Title   string   `json:"title"`
+	Text    string   `json:"text"`
+	URL     string   `json:"url"`
+	OrderID OrderID  `json:"orderId"`
+	ReqID   string   `json:"reqId"`
+	Options []string `json:"options"`
+}
+
+// Bulletin is an exchange or IB bulletin delivered on the channel returned by
+// (*Stream).SubscribeBulletins.
+type Bulletin struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// SubscribeOrders subscribes to live order updates (the "sor" topic). The
+// gateway first sends the session's current orders, then each order again as
+// it changes, with only the changed fields populated besides its OrderID.
+//
+// The returned channel is the same on every call. It stays open across
+// UnsubscribeOrders and reconnects, and is closed when the stream ends. Like
+// Updates it must be drained: the Stream waits for each event to be received
+// before reading further frames. The subscription is replayed on reconnect.
+// It returns an error, and the closed channel, if the stream has ended.
+func (s *Stream) SubscribeOrders() (<-chan Order, error) {
+	s.orderTopicMu.Lock()
+	defer s.orderTopicMu.Unlock()
+	s.subsMu.Lock()
+	ch := s.orders.open(s)
+	s.orderEvents = true
+	s.subsMu.Unlock()
+	return ch, s.subscribeTopic(topicOrders, "sor+{}")
+}
+
+// UnsubscribeOrders stops live order updates. The gateway keeps sending them
+// while a WaitForOrder call needs them, but they are no longer delivered.
+func (s *Stream) UnsubscribeOrders() error {
+	s.orderTopicMu.Lock()
+	defer s.orderTopicMu.Unlock()
+	s.subsMu.Lock()
+	s.orderEvents = false
+	waiting := len(s.orderWaiters) > 0
+	s.subsMu.Unlock()
+	if waiting {
+		return nil
+	}
+	return s.unsubscribeTopic(topicOrders, "uor+{}")
+}
+
+// SubscribeTrades subscribes to executions (the "str" topic): first the
+// session's recent trades, then each new one. The channel behaves as the one
+// from SubscribeOrders does.
+func (s *Stream) SubscribeTrades() (<-chan Trade, error) {
+	s.subsMu.Lock()
+	ch := s.trades.open(s)
+	s.subsMu.Unlock()
+	return ch, s.subscribeTopic(topicTrades, "str+{}")
+}
+
+// UnsubscribeTrades stops execution updates.
+func (s *Stream) UnsubscribeTrades() error {
+	return s.unsubscribeTopic(topicTrades, "utr")
+}
+
+// SubscribePnL subscribes to profit and loss updates (the "spl" topic) for
+// the selected account. The channel behaves as the one from SubscribeOrders
+// does.
+func (s *Stream) SubscribePnL() (<-chan PnLUpdate, error) {
+	s.subsMu.Lock()
+	ch := s.pnl.open(s)
+	s.subsMu.Unlock()
+	return ch, s.subscribeTopic(topicPnL, "spl+{}")
+}
+
+// UnsubscribePnL stops profit and loss updates.
+func (s *Stream) UnsubscribePnL() error {
+	return s.unsubscribeTopic(topicPnL, "upl{}")
+}
+
+// SubscribeAccountSummary subscribes to the summary values of an account (the
+// "ssd" topic). If keys is empty every value is sent; otherwise only the named
+// ones, such as "NetLiquidation-S". Every account subscribed this way shares
+// one channel, which behaves as the one from SubscribeOrders does; each update
+// names its Account.
+func (s *Stream) SubscribeAccountSummary(accountID string, keys ...string) (<-chan AccountSummaryUpdate, error) {
+	if accountID == "" {
+		return nil, fmt.Errorf("ibclientportal: SubscribeAccountSummary: no account ID given")
+	}
+	args, err := topicKeysArgs(keys)
+	if err != nil {
+		return nil, err
+	}
+	s.subsMu.Lock()
+	ch := s.summary.open(s)
+	s.subsMu.Unlock()
+	return ch, s.subscribeTopic(topicAccountSummary+"+"+accountID, "ssd+"+accountID+"+"+args)
+}
+
+// UnsubscribeAccountSummary stops summary updates for an account.
+func (s *Stream) UnsubscribeAccountSummary(accountID string) error {
+	return s.unsubscribeTopic(topicAccountSummary+"+"+accountID, "usd+"+accountID+"+{}")
+}
+
+// SubscribeLedger subscribes to an account's cash balances by currency (the
+// "sld" topic). If keys is empty every currency is sent; otherwise only the
+// named ledger keys, such as "LedgerListBASE" or "LedgerListUSD". Every
+// account subscribed this way shares one channel, which behaves as the one
+// from SubscribeOrders does; each entry names its AccountCode.
+func (s *Stream) SubscribeLedger(accountID string, keys ...string) (<-chan LedgerEntry, error) {
+	if accountID == "" {
+		return nil, fmt.Errorf("ibclientportal: SubscribeLedger: no account ID given")
+	}
+	args, err := topicKeysArgs(keys)
+	if err != nil {
+		return nil, err
+	}
+	s.subsMu.Lock()
+	ch := s.ledger.open(s)
+	s.subsMu.Unlock()
+	return ch, s.subscribeTopic(topicLedger+"+"+accountID, "sld+"+accountID+"+"+args)
+}
+
+// UnsubscribeLedger stops ledger updates for an account.
+func (s *Stream) UnsubscribeLedger(accountID string) error {
+	return s.unsubscribeTopic(topicLedger+"+"+accountID, "uld+"+accountID+"+{}")
+}
+
+// SubscribeNotifications delivers the gateway's notifications and server
+// prompts (the "ntf" topic). The gateway sends them unprompted, so nothing is
+// sent to it; until this is called they are discarded. The channel behaves as
+// the one from SubscribeOrders does.
+func (s *Stream) SubscribeNotifications() <-chan Notification {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	s.topics[topicNotifications] = ""
+	return s.notifications.open(s)
+}
+
+// UnsubscribeNotifications discards notifications again.
+func (s *Stream) UnsubscribeNotifications() {
+	s.unwatchTopic(topicNotifications)
+}
+
+// SubscribeBulletins delivers exchange and IB bulletins (the "blt" topic).
+// Like notifications they arrive unprompted and are discarded until this is
+// called.
+func (s *Stream) SubscribeBulletins() <-chan Bulletin {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	s.topics[topicBulletins] = ""
+	return s.bulletins.open(s)
+}
+
+// UnsubscribeBulletins discards bulletins again.
+func (s *Stream) UnsubscribeBulletins() {
+	s.unwatchTopic(topicBulletins)
+}
+
+// SubscribeAccountUpdates delivers the gateway's account status frames (the
+// "act" topic), which it sends on connect and when the selected account
+// changes. They carry the same fields as /iserver/accounts.
+func (s *Stream) SubscribeAccountUpdates() <-chan TradableAccountsResponse {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	s.topics[topicAccount] = ""
+	return s.accounts.open(s)
+}
+
+// UnsubscribeAccountUpdates discards account status frames again.
+func (s *Stream) UnsubscribeAccountUpdates() {
+	s.unwatchTopic(topicAccount)
+}
+
+// SubscribeAuthStatus delivers the gateway's authentication status frames
+// (the "sts" topic) received after the connection is established, such as
+// the session being lost to a competing login. The frame that opens each
+// connection is consumed by the Stream itself and not delivered.
+func (s *Stream) SubscribeAuthStatus() <-chan AuthStatusResponse {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	s.topics[topicAuthStatus] = ""
+	return s.authStatus.open(s)
+}
+
+// UnsubscribeAuthStatus discards authentication status frames again.
+func (s *Stream) UnsubscribeAuthStatus() {
+	s.unwatchTopic(topicAuthStatus)
+}
+
+// topicKeysArgs builds the argument object for the per-account topics.
+func topicKeysArgs(keys []string) (string, error) {
+	if len(keys) == 0 {
+		return "{}", nil
+	}
+	args, err := json.Marshal(struct {
+		Keys []string `json:"keys"`
+	}{Keys: keys})
+	return string(args), err
+}
+
+// subscribeTopic records a topic subscription, so it is replayed on
+// reconnect, and sends it. As with SubscribeMarketData, a failed send is not
+// returned, because the subscription is replayed on reconnect. Once the
+// stream has ended there is nothing to replay it on, and errStreamEnded is
+// returned instead.
+func (s *Stream) subscribeTopic(key, msg string) error {
+	s.subsMu.Lock()
+	if s.eventsClosed {
+		s.subsMu.Unlock()
+		return errStreamEnded
+	}
+	s.topics[key] = msg
+	s.subsMu.Unlock()
+	if err := s.writeText(msg); err != nil {
+		wsDebugf("subscribe %s send failed (will retry on reconnect): %v", key, err)
+	}
+	return nil
+}
+
+// unsubscribeTopic forgets a topic subscription and tells the gateway to stop
+// sending it.
+func (s *Stream) unsubscribeTopic(key, msg string) error {
+	s.unwatchTopic(key)
+	return s.writeText(msg)
+}
+
+func (s *Stream) unwatchTopic(key string) {
+	s.subsMu.Lock()
+	delete(s.topics, key)
+	s.subsMu.Unlock()
+}
+
+// replayTopics re-sends every topic subscription on the current connection.
+// Called by the supervisor after a successful reconnect. Unlike market data,
+// these subscriptions do not expire, so they are not renewed periodically;
+// re-sending one makes the gateway repeat its initial snapshot.
+func (s *Stream) replayTopics() {
+	s.subsMu.Lock()
+	var msgs []string
+	for _, msg := range s.topics {
+		if msg != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	s.subsMu.Unlock()
+
+	for _, msg := range msgs {
+		if err := s.writeText(msg); err != nil {
+			wsDebugf("resubscribe %q failed: %v", msg, err)
+		}
+	}
+}
+
+// dispatchTopic delivers a frame for one of the non-market-data topics, if the
+// caller subscribed to it. Frames for unsubscribed topics, and system frames
+// and heartbeats, are dropped.
+func (s *Stream) dispatchTopic(topic string, data []byte) {
+	s.subsMu.Lock()
+	_, subscribed := s.topics[topic]
+	s.subsMu.Unlock()
+	if !subscribed {
+		return
+	}
+
+	kind, account, _ := strings.Cut(topic, "+")
+	switch kind {
+	case topicOrders:
+		var frame struct {
+			Args []json.RawMessage `json:"args"`
+		}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			wsDebugf("decoding %s frame: %v", topic, err)
+			return
+		}
+		s.subsMu.Lock()
+		ch := s.orders.ch
+		if !s.orderEvents {
+			ch = nil
+		}
+		s.subsMu.Unlock()
+		for _, raw := range frame.Args {
+			var o Order
+			if err := json.Unmarshal(raw, &o); err != nil {
+				wsDebugf("decoding order: %v", err)
+				continue
+			}
+			s.wakeOrderWaiters(o)
+			if ch != nil {
+				deliver(s, ch, o)
+			}
+		}
+	case topicTrades:
+		var frame struct {
+			Args []json.RawMessage `json:"args"`
+		}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			wsDebugf("decoding %s frame: %v", topic, err)
+			return
+		}
+		for _, raw := range frame.Args {
+			var t Trade
+			if err := json.Unmarshal(raw, &t); err != nil {
+				wsDebugf("decoding trade: %v", err)
+				continue
+			}
+			deliver(s, s.trades.ch, t)
+		}
+	case topicPnL:
+		var frame struct {
+			Args map[string]PnLUpdate `json:"args"`
+		}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			wsDebugf("decoding %s frame: %v", topic, err)
+			return
+		}
+		for key, u := range frame.Args {
+			u.Key = key
+			deliver(s, s.pnl.ch, u)
+		}
+	case topicAccountSummary:
+		var frame struct {
+			Result []AccountSummaryUpdate `json:"result"`
+		}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			wsDebugf("decoding %s frame: %v", topic, err)
+			return
+		}
+		for _, u := range frame.Result {
+			u.Account = account
+			deliver(s, s.summary.ch, u)
+		}
+	case topicLedger:
+		var frame struct {
+			Result []LedgerEntry `json:"result"`
+		}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			wsDebugf("decoding %s frame: %v", topic, err)
+			return
+		}
+		for _, e := range frame.Result {
+			if e.AccountCode == "" {
+				e.AccountCode = account
+			}
+			deliver(s, s.ledger.ch, e)
+		}
+	case topicNotifications:
+		var frame struct {
+			Args Notification `json:"args"`
+		}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			wsDebugf("decoding %s frame: %v", topic, err)
+			return
+		}
+		deliver(s, s.notifications.ch, frame.Args)
+	case topicBulletins:
+		var frame struct {
+			Args Bulletin `json:"args"`
+		}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			wsDebugf("decoding %s frame: %v", topic, err)
+			return
+		}
+		deliver(s, s.bulletins.ch, frame.Args)
+	case topicAccount:
+		var frame struct {
+			Args TradableAccountsResponse `json:"args"`
+		}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			wsDebugf("decoding %s frame: %v", topic, err)
+			return
+		}
+		deliver(s, s.accounts.ch, frame.Args)
+	case topicAuthStatus:
+		var frame struct {
+			Args AuthStatusResponse `json:"args"`
+		}
+		if err := json.Unmarshal(data, &frame); err != nil {
+			wsDebugf("decoding %s frame: %v", topic, err)
+			return
+		}
+		deliver(s, s.authStatus.ch, frame.Args)
+	}
+}
+
+// deliver sends v on ch, waiting until it is received or the stream is
+// closed. A value that fits in the buffer is always sent, even after Close,
+// so that the last events before the channel closes are not lost. ch is never
+// nil for a subscribed topic.
+func deliver[T any](s *Stream, ch chan T, v T) {
+	select {
+	case ch <- v:
+		return
+	default:
+	}
+	select {
+	case ch <- v:
+	case <-s.done:
+	}
+}
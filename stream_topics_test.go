@@ -0,0 +1,208 @@
+package ibclientportal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// topicReplies are the frames the fake gateway sends in response to each
+// topic subscription, shaped like the gateway's own.
+var topicReplies = map[string][]string{
+	"sor+{}": {
+		`{"topic":"sor","args":[{"acct":"DU123","conid":265598,"orderId":1234568790,"status":"Submitted","side":"BUY","ticker":"AAPL","totalSize":5,"remainingQuantity":5}]}`,
+		`{"topic":"ntf","args":{"id":"INDICATIVE_DATA_SUSPENDED","title":"Notice","text":"Data suspended"}}`,
+		`{"topic":"blt","args":{"id":"1","message":"Exchange halted"}}`,
+	},
+	"str+{}": {
+		`{"topic":"str","args":[{"execution_id":"0000e0d5.6576fd38.01.01","symbol":"AAPL","side":"B","size":5,"price":"192.26","order_id":1234568790,"conid":265598}]}`,
+	},
+	"spl+{}": {
+		`{"topic":"spl","args":{"DU123.Core":{"rowType":1,"dpl":-12.5,"nl":1290000.0,"upl":256.0,"el":824600.0,"mv":1700.0}}}`,
+	},
+	`ssd+DU123+{"keys":["NetLiquidation-S"]}`: {
+		`{"topic":"ssd+DU123","result":[{"key":"NetLiquidation-S","currency":"USD","monetaryValue":1290000.0,"severity":0,"timestamp":1702582422000}]}`,
+	},
+	"sld+DU123+{}": {
+		`{"topic":"sld+DU123","result":[{"key":"LedgerListBASE","cashbalance":9000.5,"currency":"BASE","secondKey":"BASE"}]}`,
+	},
+}
+
+func TestStreamTopics(t *testing.T) {
+	t.Parallel()
+
+	var conns atomic.Int32
+	received := make(chan string, 64) // "<connection>:<frame>"
+	drop := make(chan struct{})
+
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		n := conns.Add(1)
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		if n == 1 {
+			go func() {
+				<-drop
+				conn.Close()
+			}()
+		}
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg := string(data)
+			if msg == "tic" {
+				continue
+			}
+			received <- string(rune('0'+n)) + ":" + msg
+			if n == 1 {
+				for _, frame := range topicReplies[msg] {
+					conn.WriteMessage(websocket.TextMessage, []byte(frame))
+				}
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := New(srv.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatalf("DialStream: %v", err)
+	}
+	defer stream.Close()
+
+	notifications := stream.SubscribeNotifications()
+	bulletins := stream.SubscribeBulletins()
+	orders, err := stream.SubscribeOrders()
+	if err != nil {
+		t.Fatalf("SubscribeOrders: %v", err)
+	}
+	select {
+	case o := <-orders:
+		if o.OrderID != 1234568790 || o.Status != "Submitted" || o.Ticker != "AAPL" {
+			t.Errorf("unexpected order: %+v", o)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for order")
+	}
+	select {
+	case n := <-notifications:
+		if n.ID != "INDICATIVE_DATA_SUSPENDED" || n.Text != "Data suspended" {
+			t.Errorf("unexpected notification: %+v", n)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for notification")
+	}
+	select {
+	case b := <-bulletins:
+		if b.Message != "Exchange halted" {
+			t.Errorf("unexpected bulletin: %+v", b)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for bulletin")
+	}
+
+	trades, _ := stream.SubscribeTrades()
+	select {
+	case tr := <-trades:
+		if tr.ExecutionID != "0000e0d5.6576fd38.01.01" || tr.Price != "192.26" || tr.OrderID != 1234568790 {
+			t.Errorf("unexpected trade: %+v", tr)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for trade")
+	}
+
+	pnl, _ := stream.SubscribePnL()
+	select {
+	case p := <-pnl:
+		if p.Key != "DU123.Core" || p.DailyPnL != -12.5 || p.NetLiquidation != 1290000 {
+			t.Errorf("unexpected PnL update: %+v", p)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for PnL")
+	}
+
+	summary, err := stream.SubscribeAccountSummary("DU123", "NetLiquidation-S")
+	if err != nil {
+		t.Fatalf("SubscribeAccountSummary: %v", err)
+	}
+	select {
+	case u := <-summary:
+		if u.Account != "DU123" || u.Key != "NetLiquidation-S" || u.MonetaryValue != 1290000 {
+			t.Errorf("unexpected summary update: %+v", u)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for account summary")
+	}
+
+	ledger, _ := stream.SubscribeLedger("DU123")
+	select {
+	case e := <-ledger:
+		if e.AccountCode != "DU123" || e.Key != "LedgerListBASE" || e.CashBalance != 9000.5 || e.SecondKey != "BASE" {
+			t.Errorf("unexpected ledger entry: %+v", e)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for ledger entry")
+	}
+
+	if err := stream.UnsubscribeTrades(); err != nil {
+		t.Fatalf("UnsubscribeTrades: %v", err)
+	}
+
+	var first []string
+	for len(first) < 6 {
+		select {
+		case msg := <-received:
+			first = append(first, msg)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for subscribe frames; got %q", first)
+		}
+	}
+	if !slices.Contains(first, "1:utr") {
+		t.Errorf("expected an unsubscribe frame for trades, got %q", first)
+	}
+
+	// After a reconnect every topic but the unsubscribed one is replayed.
+	close(drop)
+	want := []string{
+		"2:sor+{}",
+		"2:spl+{}",
+		`2:ssd+DU123+{"keys":["NetLiquidation-S"]}`,
+		"2:sld+DU123+{}",
+	}
+	var replayed []string
+	for len(replayed) < len(want) {
+		select {
+		case msg := <-received:
+			if strings.HasPrefix(msg, "2:") {
+				replayed = append(replayed, msg)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for replayed subscriptions; got %q", replayed)
+		}
+	}
+	slices.Sort(replayed)
+	slices.Sort(want)
+	if !slices.Equal(replayed, want) {
+		t.Errorf("replayed subscriptions = %q, want %q", replayed, want)
+	}
+}
@@ -0,0 +1,229 @@
+package ibclientportal
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// defaultStaleThreshold is how long a subscribed conid may go without an
+// update, while its market is open, before the watchdog acts.
+const defaultStaleThreshold = 30 * time.Second
+
+// WatchdogOptions configures (*Stream).StartWatchdog.
+type WatchdogOptions struct {
+	// Threshold is how long a subscribed conid may go without a market-data
+	// update, while its market is open, before it counts as stale; 0 means 30
+	// seconds. Set it above the longest quiet spell expected of the least
+	// active contract subscribed.
+	Threshold time.Duration
+	// Interval is how often to check; 0 means a quarter of Threshold.
+	Interval time.Duration
+	// MarketOpen reports whether conid's market is open at t, so that
+	// contracts that are quiet because they are not trading are left alone.
+	// nil means US equity regular hours: 9:30 to 16:00 New York time, Monday
+	// to Friday, not counting holidays.
+	MarketOpen func(conid int, t time.Time) bool
+	// Escalate is the number of conids that, found stale in the same check,
+	// make the watchdog reconnect the whole stream instead of resubscribing
+	// each one; several contracts going silent together points at the
+	// connection rather than the contracts. 0 means half the subscribed
+	// conids, and at least 2.
+	Escalate int
+}
+
+// StaleEvent reports a subscribed conid the watchdog found silent, and what
+// it did about it.
+type StaleEvent struct {
+	// Conid is the silent contract.
+	Conid int
+	// LastUpdate is when the last update for it arrived, or the zero time if
+	// none has since the watchdog started.
+	LastUpdate time.Time
+	// Silence is how long the contract had been silent, counted from the
+	// later of LastUpdate and the watchdog's last action on it.
+	Silence time.Duration
+	// Reconnected is set if the watchdog reconnected the stream, because too
+	// many conids were stale at once, rather than resubscribing the conid.
+	Reconnected bool
+	// Time is when the watchdog acted.
+	Time time.Time
+}
+
+// watchdog is the state of a Stream's staleness watchdog.
+type watchdog struct {
+	threshold  time.Duration
+	interval   time.Duration
+	marketOpen func(int, time.Time) bool
+	escalate   int
+	events     chan StaleEvent
+
+	// since is, per conid, when the watchdog started watching it or last
+	// acted on it. Only the watchdog goroutine uses it.
+	since map[int]time.Time
+}
+
+// StartWatchdog starts watching the stream's market-data subscriptions for
+// contracts that go silent, which the gateway's subscriptions are prone to
+// do without any error, and returns a channel of the StaleEvents it reports.
+//
+// When a subscribed conid has had no update for longer than the threshold
+// while its market is open, the watchdog unsubscribes and resubscribes just
+// that conid. If many conids are stale at once it reconnects the stream
+// instead, which replays every subscription; watch States to see it happen.
+// Silence while the stream is reconnecting does not count.
+//
+// Later calls return the same channel and ignore their options. The channel
+// must be drained, and is closed when the stream ends.
+func (s *Stream) StartWatchdog(opts WatchdogOptions) (<-chan StaleEvent, error) {
+	if opts.Threshold <= 0 {
+		opts.Threshold = defaultStaleThreshold
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = opts.Threshold / 4
+	}
+	if opts.MarketOpen == nil {
+		ny, err := time.LoadLocation("America/New_York")
+		if err != nil {
+			return nil, fmt.Errorf("ibclientportal: loading exchange time zone: %w", err)
+		}
+		opts.MarketOpen = func(_ int, t time.Time) bool { return usEquityHours(t.In(ny)) }
+	}
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	if s.watchdog != nil {
+		return s.watchdog.events, nil
+	}
+	w := &watchdog{
+		threshold:  opts.Threshold,
+		interval:   opts.Interval,
+		marketOpen: opts.MarketOpen,
+		escalate:   opts.Escalate,
+		events:     make(chan StaleEvent, topicEventBuffer),
+		since:      make(map[int]time.Time),
+	}
+	s.watchdog = w
+	s.lastTick = make(map[int]time.Time)
+	go s.runWatchdog(w)
+	return w.events, nil
+}
+
+// usEquityHours reports whether t, in New York time, falls in US equity
+// regular trading hours.
+func usEquityHours(t time.Time) bool {
+	if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	return minute >= 9*60+30 && minute < 16*60
+}
+
+// noteTick records that an update for conid arrived at t, if the watchdog is
+// running.
+func (s *Stream) noteTick(conid int, t time.Time) {
+	s.subsMu.Lock()
+	if s.lastTick != nil {
+		s.lastTick[conid] = t
+	}
+	s.subsMu.Unlock()
+}
+
+// runWatchdog checks for stale conids every interval until the stream ends,
+// then closes the event channel.
+func (s *Stream) runWatchdog(w *watchdog) {
+	defer close(w.events)
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.done:
+			return
+		case <-s.ctx.Done():
+			return
+		}
+		for _, ev := range s.checkStale(w, time.Now()) {
+			select {
+			case w.events <- ev:
+			case <-s.done:
+				return
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// checkStale finds the conids silent for longer than the threshold at now,
+// resubscribes them or reconnects, and returns the events to report.
+func (s *Stream) checkStale(w *watchdog, now time.Time) []StaleEvent {
+	s.subsMu.Lock()
+	state := s.state
+	subs := make(map[int][]string, len(s.subs))
+	last := make(map[int]time.Time, len(s.subs))
+	for conid, fields := range s.subs {
+		subs[conid] = fields
+		last[conid] = s.lastTick[conid]
+	}
+	s.subsMu.Unlock()
+
+	for conid := range w.since {
+		if _, ok := subs[conid]; !ok {
+			delete(w.since, conid)
+		}
+	}
+	if !state.Live() {
+		// Silence during an outage is the outage's; start afresh once it is
+		// over.
+		clear(w.since)
+		return nil
+	}
+	var stale []StaleEvent
+	for conid := range subs {
+		since, ok := w.since[conid]
+		if !ok {
+			w.since[conid] = now
+			continue
+		}
+		from := since
+		for _, t := range []time.Time{last[conid], state.Time} {
+			if t.After(from) {
+				from = t
+			}
+		}
+		if silence := now.Sub(from); silence > w.threshold && w.marketOpen(conid, now) {
+			stale = append(stale, StaleEvent{Conid: conid, LastUpdate: last[conid], Silence: silence, Time: now})
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	slices.SortFunc(stale, func(a, b StaleEvent) int { return a.Conid - b.Conid })
+
+	escalate := w.escalate
+	if escalate <= 0 {
+		escalate = max(2, len(subs)/2)
+	}
+	if len(stale) >= escalate {
+		wsDebugf("watchdog: %d of %d conids stale; reconnecting", len(stale), len(subs))
+		for i := range stale {
+			stale[i].Reconnected = true
+		}
+		clear(w.since)
+		if conn := s.currentConn(); conn != nil {
+			conn.Close() // supervise reconnects and replays everything
+		}
+		return stale
+	}
+	for _, ev := range stale {
+		wsDebugf("watchdog: conid %d silent for %v; resubscribing", ev.Conid, ev.Silence)
+		w.since[ev.Conid] = now
+		if err := s.writeText(fmt.Sprintf("umd+%d+{}", ev.Conid)); err != nil {
+			wsDebugf("watchdog: unsubscribe conid %d: %v", ev.Conid, err)
+		}
+		if err := s.sendSubscribe(ev.Conid, subs[ev.Conid]); err != nil {
+			wsDebugf("watchdog: resubscribe conid %d: %v", ev.Conid, err)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,171 @@
+package ibclientportal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamWatchdog(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan string, 64)
+	var conns atomic.Int32
+	var ticking atomic.Bool // whether conids 1 and 2 keep ticking
+	ticking.Store(true)
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conns.Add(1)
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			tick := time.NewTicker(20 * time.Millisecond)
+			defer tick.Stop()
+			for {
+				select {
+				case <-tick.C:
+				case <-done:
+					return
+				}
+				if !ticking.Load() {
+					continue
+				}
+				for _, conid := range []int{1, 2} {
+					conn.WriteMessage(websocket.TextMessage, fmt.Appendf(nil, `{"topic":"smd+%d","31":"1.00"}`, conid))
+				}
+			}
+		}()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg := string(data); msg != "tic" {
+				received <- msg
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := New(srv.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatalf("DialStream: %v", err)
+	}
+	defer stream.Close()
+	go func() {
+		for range stream.Updates() {
+		}
+	}()
+
+	events, err := stream.StartWatchdog(WatchdogOptions{
+		Threshold:  200 * time.Millisecond,
+		Interval:   25 * time.Millisecond,
+		MarketOpen: func(int, time.Time) bool { return true },
+		Escalate:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for conid := 1; conid <= 3; conid++ {
+		if err := stream.SubscribeMarketData(conid, FieldLastPrice); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next := func() StaleEvent {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for a stale event")
+		}
+		panic("unreachable")
+	}
+
+	ev := next()
+	if ev.Conid != 3 || ev.Reconnected || !ev.LastUpdate.IsZero() || ev.Silence < 200*time.Millisecond {
+		t.Errorf("unexpected stale event: %+v", ev)
+	}
+	// The original subscribe, then the watchdog's unsubscribe and resubscribe.
+	want := []string{`smd+3+{"fields":["31"]}`, "umd+3+{}", `smd+3+{"fields":["31"]}`}
+	var resent []string
+	for len(resent) < len(want) {
+		select {
+		case msg := <-received:
+			if strings.Contains(msg, "+3+") {
+				resent = append(resent, msg)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for the resubscribe; got %q", resent)
+		}
+	}
+	if !slices.Equal(resent, want) {
+		t.Errorf("gateway received %q, want %q", resent, want)
+	}
+
+	// Everything goes quiet: the watchdog reconnects the stream.
+	ticking.Store(false)
+	for {
+		ev := next()
+		if ev.Reconnected {
+			break
+		}
+	}
+	deadline := time.After(5 * time.Second)
+	for conns.Load() < 2 {
+		select {
+		case <-deadline:
+			t.Fatal("expected the watchdog to force a reconnect")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	stream.Close()
+	for range events {
+	}
+}
+
+func TestUSEquityHours(t *testing.T) {
+	t.Parallel()
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{time.Date(2026, 10, 19, 9, 29, 0, 0, ny), false},
+		{time.Date(2026, 10, 19, 9, 30, 0, 0, ny), true},
+		{time.Date(2026, 10, 19, 15, 59, 0, 0, ny), true},
+		{time.Date(2026, 10, 19, 16, 0, 0, 0, ny), false},
+		{time.Date(2026, 10, 18, 12, 0, 0, 0, ny), false}, // Sunday
+	}
+	for _, tt := range tests {
+		if got := usEquityHours(tt.t); got != tt.want {
+			t.Errorf("usEquityHours(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
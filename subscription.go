@@ -0,0 +1,223 @@
+package ibclientportal
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Subscription is one consumer's market-data subscription to a contract,
+// returned by (*Stream).Subscribe. Several Subscriptions, from unrelated parts
+// of a program, may share a conid: the Stream asks the gateway for the union
+// of their fields and unsubscribes only when the last one is closed.
+type Subscription struct {
+	stream *Stream
+	conid  int
+	fields []string
+	sink   *updateSink
+
+	closeOnce sync.Once
+}
+
+// Subscribe starts streaming market data for conid and returns a handle whose
+// Updates channel carries only the requested fields. If no fields are given,
+// the same defaults as SubscribeMarketData are used.
+//
+// Unlike SubscribeMarketData, Subscribe does not replace an earlier
+// subscription to the same conid, by either method: the fields sent to the
+// gateway are the union of all of them, and each consumer sees only its own.
+// Updates for a conid held only by Subscriptions are not delivered on
+// (*Stream).Updates.
+//
+// Each Subscription's channel is buffered and subject to the stream's
+// BackpressurePolicy, like Updates; under BackpressureBlock a Subscription
+// that is not drained delays every other consumer. The channel is closed by
+// Close, when the stream ends, or when a LineManager evicts the conid.
+//
+// If the client has a LineManager, a conid's first subscription takes a line
+// from it, and Subscribe returns a *LineBudgetError if none is free and none
+// can be evicted.
+func (s *Stream) Subscribe(conid int, fields ...string) (*Subscription, error) {
+	if len(fields) == 0 {
+		fields = defaultMarketDataFields()
+	}
+	if lines := s.client.lineManager(); lines != nil {
+		if err := lines.acquire([]int{conid}, s); err != nil {
+			return nil, err
+		}
+	}
+	sub := &Subscription{
+		stream: s,
+		conid:  conid,
+		fields: slices.Clone(fields),
+		sink:   s.newSink(),
+	}
+	s.subsMu.Lock()
+	if s.eventsClosed {
+		s.subsMu.Unlock()
+		sub.sink.close()
+		// The stream gave back its lines when it ended, so return the one
+		// taken above.
+		if lines := s.client.lineManager(); lines != nil {
+			lines.releaseStream(conid, s)
+		}
+		return sub, nil
+	}
+	s.handles[conid] = append(s.handles[conid], sub)
+	union := s.mergeSubLocked(conid)
+	s.subsMu.Unlock()
+
+	// Sent even if the union is unchanged, so that the gateway sends the new
+	// consumer a fresh snapshot of the fields.
+	if err := s.sendSubscribe(conid, union); err != nil {
+		wsDebugf("subscribe conid %d send failed (will retry on reconnect): %v", conid, err)
+	}
+	return sub, nil
+}
+
+// Conid returns the subscribed contract.
+func (sub *Subscription) Conid() int {
+	return sub.conid
+}
+
+// Fields returns the fields the subscription requested.
+func (sub *Subscription) Fields() []string {
+	return slices.Clone(sub.fields)
+}
+
+// Updates returns the channel on which the subscription's market-data updates
+// are delivered. Each update's Fields holds only fields the subscription
+// requested; updates carrying none of them are skipped. The channel stays open
+// across automatic reconnects.
+func (sub *Subscription) Updates() <-chan MarketDataUpdate {
+	return sub.sink.ch
+}
+
+// Close ends the subscription and closes its Updates channel. If it was the
+// last consumer of the conid, the Stream unsubscribes from the gateway;
+// otherwise it narrows the subscription to the fields still wanted. Calling
+// Close more than once is a no-op.
+func (sub *Subscription) Close() error {
+	var err error
+	sub.closeOnce.Do(func() {
+		s := sub.stream
+		s.subsMu.Lock()
+		removed := false
+		if hs := s.handles[sub.conid]; slices.Contains(hs, sub) {
+			hs = slices.DeleteFunc(slices.Clone(hs), func(h *Subscription) bool { return h == sub })
+			if len(hs) == 0 {
+				delete(s.handles, sub.conid)
+			} else {
+				s.handles[sub.conid] = hs
+			}
+			removed = true
+		}
+		s.subsMu.Unlock()
+		sub.sink.close()
+		if removed {
+			err = s.refreshSub(sub.conid)
+		}
+	})
+	return err
+}
+
+// mergeSubLocked recomputes the fields requested for conid from
+// SubscribeMarketData and every open Subscription, records them for replay,
+// and returns them. s.subsMu must be held.
+func (s *Stream) mergeSubLocked(conid int) []string {
+	union := slices.Clone(s.direct[conid])
+	for _, h := range s.handles[conid] {
+		for _, f := range h.fields {
+			if !slices.Contains(union, f) {
+				union = append(union, f)
+			}
+		}
+	}
+	if len(union) == 0 {
+		delete(s.subs, conid)
+		delete(s.avail, conid)
+		delete(s.lastTick, conid)
+		return nil
+	}
+	s.subs[conid] = union
+	return union
+}
+
+// refreshSub brings the gateway's subscription for conid in line with what is
+// still requested after a consumer went away: it unsubscribes and gives back
+// the line if nothing is left, re-sends a narrower field list if that changed,
+// and otherwise does nothing.
+func (s *Stream) refreshSub(conid int) error {
+	s.subsMu.Lock()
+	prev := s.subs[conid]
+	union := s.mergeSubLocked(conid)
+	s.subsMu.Unlock()
+	if len(union) == 0 {
+		if lines := s.client.lineManager(); lines != nil {
+			lines.releaseStream(conid, s)
+		}
+		return s.writeText(fmt.Sprintf("umd+%d+{}", conid))
+	}
+	if slices.Equal(prev, union) {
+		return nil
+	}
+	return s.sendSubscribe(conid, union)
+}
+
+// evictMarketData drops every subscription to conid, closing its
+// Subscriptions, because a LineManager took the line away.
+func (s *Stream) evictMarketData(conid int) error {
+	s.subsMu.Lock()
+	delete(s.direct, conid)
+	hs := s.handles[conid]
+	delete(s.handles, conid)
+	s.subsMu.Unlock()
+	for _, h := range hs {
+		h.closeOnce.Do(h.sink.close)
+	}
+	return s.refreshSub(conid)
+}
+
+// hasDirect reports whether updates for conid belong on Updates: always,
+// unless the conid is held only by Subscriptions.
+func (s *Stream) hasDirect(conid int) bool {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	if _, ok := s.direct[conid]; ok {
+		return true
+	}
+	return len(s.handles[conid]) == 0
+}
+
+// deliverSubscriptions delivers an update to each Subscription on its conid,
+// keeping only the fields that Subscription requested.
+func (s *Stream) deliverSubscriptions(u MarketDataUpdate) {
+	s.subsMu.Lock()
+	hs := s.handles[u.Conid]
+	s.subsMu.Unlock()
+	for _, h := range hs {
+		fields := make(map[string]json.RawMessage, len(h.fields))
+		for _, f := range h.fields {
+			if v, ok := u.Fields[f]; ok {
+				fields[f] = v
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		hu := u
+		hu.Fields = fields
+		h.sink.deliver(hu, s.done)
+	}
+}
+
+// closeSubscriptionsLocked closes every Subscription's channel when the
+// stream ends. s.subsMu must be held.
+func (s *Stream) closeSubscriptionsLocked() {
+	for _, hs := range s.handles {
+		for _, h := range hs {
+			h.closeOnce.Do(h.sink.close)
+		}
+	}
+}
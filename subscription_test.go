@@ -0,0 +1,213 @@
+package ibclientportal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSubscriptionRefcount(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan string, 16)
+	send := make(chan string, 16)
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		go func() {
+			for msg := range send {
+				conn.WriteMessage(websocket.TextMessage, []byte(msg))
+			}
+		}()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg := string(data); msg != "tic" {
+				received <- msg
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer close(send)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := New(srv.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatalf("DialStream: %v", err)
+	}
+	defer stream.Close()
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("gateway received %q, want %q", got, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	next := func(sub *Subscription) MarketDataUpdate {
+		t.Helper()
+		select {
+		case u := <-sub.Updates():
+			return u
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for an update on %v", sub.Fields())
+		}
+		panic("unreachable")
+	}
+
+	last, err := stream.Subscribe(265598, FieldLastPrice)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	expect(`smd+265598+{"fields":["31"]}`)
+	quote, err := stream.Subscribe(265598, FieldBidPrice, FieldLastPrice)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	expect(`smd+265598+{"fields":["31","84"]}`)
+
+	send <- `{"topic":"smd+265598","conid":265598,"31":"190.00","84":"189.99"}`
+	if u := next(last); len(u.Fields) != 1 || u.Fields[FieldLastPrice] == nil {
+		t.Errorf("last-price subscription got fields %v, want only 31", u.Fields)
+	}
+	if u := next(quote); len(u.Fields) != 2 {
+		t.Errorf("quote subscription got fields %v, want 31 and 84", u.Fields)
+	}
+	// An update with none of a subscription's fields is not delivered to it.
+	send <- `{"topic":"smd+265598","conid":265598,"84":"190.01"}`
+	if b, _ := next(quote).Float(FieldBidPrice); b != 190.01 {
+		t.Errorf("bid = %v, want 190.01", b)
+	}
+	select {
+	case u := <-last.Updates():
+		t.Errorf("unexpected update for the last-price subscription: %v", u.Fields)
+	default:
+	}
+	select {
+	case u := <-stream.Updates():
+		t.Errorf("unexpected update on Updates for a conid held only by Subscriptions: %v", u.Fields)
+	default:
+	}
+
+	if err := quote.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	expect(`smd+265598+{"fields":["31"]}`)
+	if _, ok := <-quote.Updates(); ok {
+		t.Error("expected the closed subscription's channel to close")
+	}
+	if err := quote.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if err := last.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	expect("umd+265598+{}")
+
+	// A direct subscription survives a Subscription on the same conid.
+	if err := stream.SubscribeMarketData(8314, FieldLastPrice); err != nil {
+		t.Fatalf("SubscribeMarketData: %v", err)
+	}
+	expect(`smd+8314+{"fields":["31"]}`)
+	sub, err := stream.Subscribe(8314, FieldVolume)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	expect(`smd+8314+{"fields":["31","87"]}`)
+	sub.Close()
+	expect(`smd+8314+{"fields":["31"]}`)
+	send <- `{"topic":"smd+8314","conid":8314,"31":"12.00"}`
+	select {
+	case u := <-stream.Updates():
+		if u.Conid != 8314 {
+			t.Errorf("Updates got conid %d, want 8314", u.Conid)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the direct subscription's update")
+	}
+
+	other, err := stream.Subscribe(8314)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	<-received
+	stream.Close()
+	if _, ok := <-other.Updates(); ok {
+		t.Error("expected subscriptions to close when the stream ends")
+	}
+}
+
+func TestSubscribeAfterStreamEnds(t *testing.T) {
+	t.Parallel()
+
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		writeTickleAuthed(w)
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := New(srv.URL)
+	lines := NewLineManager(LineManagerOptions{MaxLines: 1})
+	client.SetLineManager(lines)
+	stream, err := client.DialStream(ctx)
+	if err != nil {
+		t.Fatalf("DialStream: %v", err)
+	}
+	stream.Close()
+	for range stream.Updates() {
+	}
+
+	sub, err := stream.Subscribe(265598, FieldLastPrice)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, ok := <-sub.Updates(); ok {
+		t.Error("expected a closed channel from a stream that has ended")
+	}
+	if err := stream.SubscribeMarketData(8314, FieldLastPrice); err == nil {
+		t.Error("expected an error from SubscribeMarketData on an ended stream")
+	}
+	if held := lines.Held(); held != 0 {
+		t.Errorf("Held() = %d after subscribing on an ended stream, want 0", held)
+	}
+}
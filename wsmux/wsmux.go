@@ -0,0 +1,329 @@
+// Package wsmux shares one Client Portal Gateway websocket among many local
+// clients.
+//
+// IBKR allows one brokerage session per user, and a Stream belongs to the
+// process that dialed it. A Server holds a single upstream Stream and serves
+// the gateway's websocket protocol for market data ("smd+" and "umd+") on a
+// local endpoint, so several processes can stream through one session. Each
+// downstream subscription becomes an ibclientportal.Subscription on the
+// upstream Stream: the gateway is asked for the union of the fields the
+// clients want, and a conid uses one market-data line however many clients
+// are subscribed to it.
+//
+// Clients connect exactly as they would to the gateway. A Server answers
+// /v1/api/tickle and /v1/api/ws, so
+//
+//	stream, err := ibclientportal.New("http://localhost:5001").DialStream(ctx)
+//
+// works unchanged. Other topics (orders, PnL, market depth, history) are not
+// relayed.
+package wsmux
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kevinburke/ibclientportal"
+)
+
+// writeTimeout bounds each write to a downstream client. A client that cannot
+// keep up for that long is disconnected, rather than holding up the upstream
+// Stream for everyone.
+const writeTimeout = 10 * time.Second
+
+// stsFrame tells a downstream client the session is established, as the
+// gateway does on connect; ibclientportal waits for it before subscribing.
+const stsFrame = `{"topic":"sts","args":{"authenticated":true,"connected":true}}`
+
+// Server relays market data from one upstream Stream to any number of
+// downstream websocket clients. It is an http.Handler.
+type Server struct {
+	stream   *ibclientportal.Stream
+	logger   *slog.Logger
+	upgrader websocket.Upgrader
+	done     chan struct{}
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	closed  bool
+}
+
+// NewServer returns a Server relaying stream. The Server drains
+// stream.Updates itself, so do not read it elsewhere; when the stream ends
+// the Server disconnects every client and refuses new ones.
+//
+// Use a stream dialed with BackpressureConflate or BackpressureDropOldest:
+// under the default BackpressureBlock a client that stops reading holds up
+// every other client until its write times out.
+func NewServer(stream *ibclientportal.Stream) *Server {
+	return NewServerWithOptions(stream, Options{})
+}
+
+// Options configures NewServerWithOptions.
+type Options struct {
+	// Logger receives a line when a client connects or disconnects, or
+	// sends a message the Server cannot apply. nil discards them.
+	Logger *slog.Logger
+}
+
+// NewServerWithOptions is NewServer with options.
+func NewServerWithOptions(stream *ibclientportal.Stream, opts Options) *Server {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	s := &Server{
+		stream:  stream,
+		logger:  logger,
+		done:    make(chan struct{}),
+		clients: make(map[*client]struct{}),
+	}
+	go func() {
+		// Updates carries frames for conids no client holds, such as the
+		// tail of a subscription after its last client left.
+		for range stream.Updates() {
+		}
+		close(s.done)
+		s.Close()
+	}()
+	return s
+}
+
+// Done is closed when the upstream stream ends.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Clients returns the number of connected downstream clients.
+func (s *Server) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// Close disconnects every client and refuses new ones. It does not close the
+// upstream stream.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+	for _, c := range clients {
+		c.conn.Close()
+	}
+	return nil
+}
+
+// ServeHTTP serves the gateway's tickle and websocket endpoints.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/api/tickle":
+		s.serveTickle(w)
+	case "/v1/api/ws":
+		s.serveWS(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveTickle reports the session as authenticated while the upstream stream
+// is connected, which is what a client checks before dialing the websocket.
+func (s *Server) serveTickle(w http.ResponseWriter) {
+	live := s.stream.State().Live()
+	var resp ibclientportal.TickleResponse
+	resp.Session = "wsmux"
+	resp.IServer.AuthStatus.Authenticated = live
+	resp.IServer.AuthStatus.Connected = live
+	if !live {
+		resp.IServer.AuthStatus.Message = "upstream stream is " + s.stream.State().State.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has replied
+	}
+	c := &client{conn: conn, subs: make(map[int]*ibclientportal.Subscription)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.clients[c] = struct{}{}
+	n := len(s.clients)
+	s.mu.Unlock()
+	s.logger.Info("client connected", "remote", r.RemoteAddr, "clients", n)
+
+	defer func() {
+		c.closeAll()
+		conn.Close()
+		s.mu.Lock()
+		delete(s.clients, c)
+		n := len(s.clients)
+		s.mu.Unlock()
+		s.logger.Info("client disconnected", "remote", r.RemoteAddr, "clients", n)
+	}()
+	if err := c.write([]byte(stsFrame)); err != nil {
+		return
+	}
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := s.handle(c, string(data)); err != nil {
+			s.logger.Warn("bad message from client", "remote", r.RemoteAddr, "message", truncate(string(data), maxLoggedMessage), "error", err)
+		}
+	}
+}
+
+// maxLoggedMessage is how much of a bad client message is logged.
+const maxLoggedMessage = 200
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// handle applies one message from a client.
+func (s *Server) handle(c *client, msg string) error {
+	switch {
+	case msg == "tic":
+		return nil
+	case strings.HasPrefix(msg, "smd+"):
+		conid, args, err := parseTopic(strings.TrimPrefix(msg, "smd+"))
+		if err != nil {
+			return err
+		}
+		var req struct {
+			Fields []string `json:"fields"`
+		}
+		if args != "" {
+			if err := json.Unmarshal([]byte(args), &req); err != nil {
+				return fmt.Errorf("parsing subscription arguments: %w", err)
+			}
+		}
+		sub, err := s.stream.Subscribe(conid, req.Fields...)
+		if err != nil {
+			return err
+		}
+		// The gateway replaces a subscription's fields when a conid is
+		// subscribed again; so do we. The new handle is taken first so the
+		// conid's upstream subscription does not lapse in between.
+		if old := c.swap(conid, sub); old != nil {
+			old.Close()
+		}
+		c.forward(sub)
+		return nil
+	case strings.HasPrefix(msg, "umd+"):
+		conid, _, err := parseTopic(strings.TrimPrefix(msg, "umd+"))
+		if err != nil {
+			return err
+		}
+		if old := c.swap(conid, nil); old != nil {
+			return old.Close()
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported topic")
+}
+
+// parseTopic splits "265598+{...}" into the conid and its arguments.
+func parseTopic(s string) (int, string, error) {
+	id, args, _ := strings.Cut(s, "+")
+	conid, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, "", fmt.Errorf("bad conid %q", id)
+	}
+	return conid, args, nil
+}
+
+// client is one downstream websocket connection and its subscriptions.
+type client struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	subs map[int]*ibclientportal.Subscription
+}
+
+// swap makes sub the client's subscription to conid, or removes it if sub is
+// nil, and returns the one it replaced.
+func (c *client) swap(conid int, sub *ibclientportal.Subscription) *ibclientportal.Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.subs[conid]
+	if sub == nil {
+		delete(c.subs, conid)
+	} else {
+		c.subs[conid] = sub
+	}
+	return old
+}
+
+// closeAll closes every subscription when the client disconnects.
+func (c *client) closeAll() {
+	c.mu.Lock()
+	subs := c.subs
+	c.subs = nil
+	c.mu.Unlock()
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+// forward relays sub's updates to the client until sub is closed. If a write
+// fails it disconnects the client, and keeps draining sub until the
+// disconnect closes it.
+func (c *client) forward(sub *ibclientportal.Subscription) {
+	go func() {
+		failed := false
+		for u := range sub.Updates() {
+			if failed {
+				continue
+			}
+			if err := c.write(encodeUpdate(u)); err != nil {
+				failed = true
+				c.conn.Close()
+			}
+		}
+	}()
+}
+
+func (c *client) write(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// encodeUpdate renders an update as the gateway frame it came from.
+func encodeUpdate(u ibclientportal.MarketDataUpdate) []byte {
+	frame := make(map[string]any, len(u.Fields)+3)
+	for k, v := range u.Fields {
+		frame[k] = v
+	}
+	frame["topic"] = fmt.Sprintf("smd+%d", u.Conid)
+	frame["conid"] = u.Conid
+	if !u.Received.IsZero() {
+		frame["_updated"] = u.Received.UnixMilli()
+	}
+	data, _ := json.Marshal(frame)
+	return data
+}
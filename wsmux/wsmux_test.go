@@ -0,0 +1,179 @@
+package wsmux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kevinburke/ibclientportal"
+)
+
+// fakeGateway serves the gateway's tickle and websocket endpoints, recording
+// what it is sent and writing whatever is put on send.
+func fakeGateway(t *testing.T) (srv *httptest.Server, received <-chan string, send chan<- string) {
+	t.Helper()
+	recv := make(chan string, 64)
+	out := make(chan string, 64)
+	var upgrader websocket.Upgrader
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/api/tickle", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"session":"sess","iserver":{"authStatus":{"authenticated":true}}}`))
+	})
+	mux.HandleFunc("/v1/api/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(stsFrame))
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case msg := <-out:
+					conn.WriteMessage(websocket.TextMessage, []byte(msg))
+				case <-done:
+					return
+				}
+			}
+		}()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg := string(data); msg != "tic" {
+				recv <- msg
+			}
+		}
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, recv, out
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	gw, received, send := fakeGateway(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upstream, err := ibclientportal.New(gw.URL).DialStreamWithOptions(ctx, ibclientportal.DialStreamOptions{
+		Backpressure: ibclientportal.BackpressureConflate,
+	})
+	if err != nil {
+		t.Fatalf("dial upstream: %v", err)
+	}
+	defer upstream.Close()
+	server := NewServer(upstream)
+	local := httptest.NewServer(server)
+	defer local.Close()
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("gateway received %q, want %q", got, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for the gateway to receive %q", want)
+		}
+	}
+	next := func(s *ibclientportal.Stream) ibclientportal.MarketDataUpdate {
+		t.Helper()
+		select {
+		case u := <-s.Updates():
+			return u
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for a relayed update")
+		}
+		panic("unreachable")
+	}
+
+	a, err := ibclientportal.New(local.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatalf("dial mux: %v", err)
+	}
+	defer a.Close()
+	b, err := ibclientportal.New(local.URL).DialStream(ctx)
+	if err != nil {
+		t.Fatalf("dial mux: %v", err)
+	}
+	defer b.Close()
+
+	a.SubscribeMarketData(265598, ibclientportal.FieldLastPrice)
+	expect(`smd+265598+{"fields":["31"]}`)
+	b.SubscribeMarketData(265598, ibclientportal.FieldBidPrice)
+	expect(`smd+265598+{"fields":["31","84"]}`)
+	if n := server.Clients(); n != 2 {
+		t.Errorf("Clients() = %d, want 2", n)
+	}
+
+	send <- `{"topic":"smd+265598","conid":265598,"31":"190.00","84":"189.99"}`
+	ua, ub := next(a), next(b)
+	if ua.Conid != 265598 || len(ua.Fields) != 1 {
+		t.Errorf("client a got %d %v, want only the last price", ua.Conid, ua.Fields)
+	}
+	if bid, _ := ub.Float(ibclientportal.FieldBidPrice); bid != 189.99 || len(ub.Fields) != 1 {
+		t.Errorf("client b got %v, want only the bid", ub.Fields)
+	}
+
+	b.UnsubscribeMarketData(265598)
+	expect(`smd+265598+{"fields":["31"]}`)
+	// A client that disconnects gives up its subscriptions.
+	a.Close()
+	expect("umd+265598+{}")
+	deadline := time.Now().Add(5 * time.Second)
+	for server.Clients() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := server.Clients(); n != 1 {
+		t.Errorf("Clients() = %d after a disconnect, want 1", n)
+	}
+
+	upstream.Close()
+	select {
+	case <-server.Done():
+	case <-ctx.Done():
+		t.Fatal("expected Done to close when the upstream stream ends")
+	}
+	// The remaining client is disconnected; its Stream would now keep trying
+	// to reconnect, and be told the session is down.
+	for server.Clients() != 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the remaining client to be disconnected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestEncodeUpdate(t *testing.T) {
+	t.Parallel()
+	u := ibclientportal.MarketDataUpdate{
+		Conid:    8314,
+		Received: time.UnixMilli(1760880000000),
+		Fields:   map[string]json.RawMessage{"31": json.RawMessage(`"12.50"`)},
+	}
+	var frame map[string]any
+	if err := json.Unmarshal(encodeUpdate(u), &frame); err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprint(frame["topic"], " ", frame["conid"], " ", frame["31"], " ", frame["_updated"])
+	if want := "smd+8314 8314 12.50 1.76088e+12"; got != want {
+		t.Errorf("frame = %q, want %q", got, want)
+	}
+	if _, _, err := parseTopic("abc+{}"); err == nil || !strings.Contains(err.Error(), "conid") {
+		t.Errorf("parseTopic: expected a bad conid error, got %v", err)
+	}
+}